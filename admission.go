@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// admissionConfig protects the service itself: per-client-IP request rate and a
+// server-wide cap on simultaneous crawls with a bounded wait queue.
+type admissionConfig struct {
+	ClientRPS    float64       // requests per second per client IP; 0 = unlimited
+	ClientBurst  int           // token bucket burst per client IP
+	MaxCrawls    int           // simultaneous crawls; 0 = unlimited
+	QueueSize    int           // crawls allowed to wait for a slot
+	QueueTimeout time.Duration // how long a queued crawl waits before giving up
+	TrustProxy   bool          // take the client IP from X-Forwarded-For / X-Real-IP
+}
+
+func loadAdmissionConfig() admissionConfig {
+	cfg := admissionConfig{
+		ClientRPS:    envFloat("ZONERAMA_CLIENT_RPS", 1),
+		ClientBurst:  envInt("ZONERAMA_CLIENT_BURST", 5),
+		MaxCrawls:    envInt("ZONERAMA_MAX_CRAWLS", 4),
+		QueueSize:    envInt("ZONERAMA_CRAWL_QUEUE", 8),
+		QueueTimeout: envMillis("ZONERAMA_QUEUE_TIMEOUT_MS", 30*time.Second),
+		TrustProxy:   envBool("ZONERAMA_TRUST_PROXY", false),
+	}
+	if cfg.ClientBurst < 1 {
+		cfg.ClientBurst = 1
+	}
+	if cfg.QueueSize < 0 {
+		cfg.QueueSize = 0
+	}
+	return cfg
+}
+
+var (
+	admissionCfg = loadAdmissionConfig()
+	clientLimits = newClientLimiter(admissionCfg.ClientRPS, admissionCfg.ClientBurst)
+	crawlSlots   = newCrawlAdmission(admissionCfg.MaxCrawls, admissionCfg.QueueSize, admissionCfg.QueueTimeout)
+)
+
+// writeJSONError writes {"error": msg} with the usual JSON and CORS headers.
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+// clientIP returns the caller's IP, honoring proxy headers only when configured.
+func clientIP(r *http.Request) string {
+	if admissionCfg.TrustProxy {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			return strings.TrimSpace(strings.Split(xff, ",")[0])
+		}
+		if xr := strings.TrimSpace(r.Header.Get("X-Real-IP")); xr != "" {
+			return xr
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// clientLimiter keeps one token bucket per client IP. Idle buckets are swept.
+type clientLimiter struct {
+	mu        sync.Mutex
+	rps       float64
+	burst     int
+	clients   map[string]*clientBucket
+	lastSweep time.Time
+}
+
+type clientBucket struct {
+	lim      *rate.Limiter
+	lastSeen time.Time
+}
+
+func newClientLimiter(rps float64, burst int) *clientLimiter {
+	return &clientLimiter{rps: rps, burst: burst, clients: make(map[string]*clientBucket)}
+}
+
+// allow takes a token for ip. When none is available it returns how long to wait.
+func (c *clientLimiter) allow(ip string, now time.Time) (bool, time.Duration) {
+	if c.rps <= 0 {
+		return true, 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.Sub(c.lastSweep) > time.Minute {
+		for k, b := range c.clients {
+			if now.Sub(b.lastSeen) > 10*time.Minute {
+				delete(c.clients, k)
+			}
+		}
+		c.lastSweep = now
+	}
+	b, ok := c.clients[ip]
+	if !ok {
+		b = &clientBucket{lim: rate.NewLimiter(rate.Limit(c.rps), c.burst)}
+		c.clients[ip] = b
+	}
+	b.lastSeen = now
+	res := b.lim.ReserveN(now, 1)
+	if d := res.DelayFrom(now); d > 0 {
+		res.CancelAt(now)
+		return false, d
+	}
+	return true, 0
+}
+
+// crawlAdmission caps simultaneous crawls. Requests beyond the cap wait in a
+// bounded queue; when the queue is full they are rejected right away.
+type crawlAdmission struct {
+	slots   chan struct{}
+	timeout time.Duration
+
+	mu       sync.Mutex
+	queued   int
+	maxQueue int
+	avgCrawl time.Duration // moving average of crawl durations, for Retry-After
+}
+
+func newCrawlAdmission(maxCrawls, queueSize int, timeout time.Duration) *crawlAdmission {
+	a := &crawlAdmission{timeout: timeout, maxQueue: queueSize, avgCrawl: 10 * time.Second}
+	if maxCrawls > 0 {
+		a.slots = make(chan struct{}, maxCrawls)
+	}
+	return a
+}
+
+// acquire returns a release func, or ok=false when the queue is full or the wait timed out.
+func (a *crawlAdmission) acquire(ctx context.Context) (release func(), ok bool) {
+	if a.slots == nil {
+		return func() {}, true
+	}
+	select {
+	case a.slots <- struct{}{}:
+		return a.releaser(), true
+	default:
+	}
+	a.mu.Lock()
+	if a.queued >= a.maxQueue {
+		a.mu.Unlock()
+		return nil, false
+	}
+	a.queued++
+	a.mu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		a.queued--
+		a.mu.Unlock()
+	}()
+
+	timer := time.NewTimer(a.timeout)
+	defer timer.Stop()
+	select {
+	case a.slots <- struct{}{}:
+		return a.releaser(), true
+	case <-timer.C:
+		return nil, false
+	case <-ctx.Done():
+		return nil, false
+	}
+}
+
+func (a *crawlAdmission) releaser() func() {
+	start := time.Now()
+	return func() {
+		<-a.slots
+		a.mu.Lock()
+		a.avgCrawl = (a.avgCrawl*4 + time.Since(start)) / 5
+		a.mu.Unlock()
+	}
+}
+
+// retryAfter estimates when a slot frees up, in whole seconds.
+func (a *crawlAdmission) retryAfter() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	waves := 1.0
+	if n := cap(a.slots); n > 0 {
+		waves += float64(a.queued) / float64(n)
+	}
+	return int(math.Max(1, math.Ceil(a.avgCrawl.Seconds()*waves)))
+}
+
+// limitClientRate rejects callers that exceed their per-IP token bucket with 429.
+func limitClientRate(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := clientLimits.allow(clientIP(r), time.Now()); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeJSONError(w, http.StatusTooManyRequests, "too many requests from this client")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// admitCrawl holds a crawl slot for the duration of the handler, queueing when
+// all slots are busy and answering 503 with Retry-After when it cannot get one.
+func admitCrawl(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		release, ok := crawlSlots.acquire(r.Context())
+		if !ok {
+			if r.Context().Err() != nil {
+				return
+			}
+			log.Printf("admission: rejecting crawl from %s, server busy", clientIP(r))
+			w.Header().Set("Retry-After", strconv.Itoa(crawlSlots.retryAfter()))
+			writeJSONError(w, http.StatusServiceUnavailable, "server busy: too many crawls in progress, retry later")
+			return
+		}
+		defer release()
+		next(w, r)
+	}
+}
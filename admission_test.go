@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fixtureTransport answers every crawl request with a saved page from other/.
+type fixtureTransport struct {
+	page   string
+	served chan struct{}
+	once   sync.Once
+}
+
+func (f *fixtureTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	rec := httptest.NewRecorder()
+	body, err := os.ReadFile(filepath.Join("other", f.page))
+	if err != nil {
+		return nil, err
+	}
+	rec.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rec.Write(body)
+	f.once.Do(func() { close(f.served) })
+	res := rec.Result()
+	res.Request = r
+	return res, nil
+}
+
+func TestCancelledCrawlFreesSlot(t *testing.T) {
+	ft := &fixtureTransport{page: "clean.html", served: make(chan struct{})}
+	crawlTransport = ft
+	oldSlots := crawlSlots
+	crawlSlots = newCrawlAdmission(1, 0, time.Second)
+	t.Cleanup(func() { crawlTransport = nil; crawlSlots = oldSlots })
+
+	// rps=0.2 lets the profile page through and holds every album page back,
+	// so the crawl is cancelled with album requests queued behind one slot.
+	q := url.Values{
+		"link":        {"https://eu.zonerama.com/Fixture/1"},
+		"album_limit": {"0"},
+		"concurrency": {"1"},
+		"rps":         {"0.2"},
+		"rendered":    {"false"},
+	}
+	h := admitCrawl(func(w http.ResponseWriter, r *http.Request) {
+		scrapeProfile(r.Context(), r.URL.Query(), false)
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest("GET", "/zonerama?"+q.Encode(), nil).WithContext(ctx)
+	done := make(chan struct{})
+	go func() {
+		h(httptest.NewRecorder(), req)
+		close(done)
+	}()
+
+	select {
+	case <-ft.served:
+	case <-time.After(10 * time.Second):
+		t.Fatal("profile page was never requested")
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("crawl kept running after the client went away")
+	}
+
+	release, ok := crawlSlots.acquire(context.Background())
+	if !ok {
+		t.Fatal("admission slot still held after a cancelled crawl")
+	}
+	release()
+}
+
+func TestClientLimiter(t *testing.T) {
+	c := newClientLimiter(1, 2)
+	now := time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		if ok, _ := c.allow("10.0.0.1", now); !ok {
+			t.Fatalf("request %d within the burst was refused", i+1)
+		}
+	}
+	ok, wait := c.allow("10.0.0.1", now)
+	if ok || wait <= 0 || wait > time.Second {
+		t.Fatalf("request over the burst: ok %v, wait %v", ok, wait)
+	}
+	// Buckets are per client, and refused requests do not use up tokens
+	if ok, _ := c.allow("10.0.0.2", now); !ok {
+		t.Error("another client was refused")
+	}
+	if ok, _ := c.allow("10.0.0.1", now.Add(time.Second)); !ok {
+		t.Error("client was refused after its token refilled")
+	}
+	// Idle buckets are swept
+	c.allow("10.0.0.3", now.Add(20*time.Minute))
+	if _, ok := c.clients["10.0.0.1"]; ok {
+		t.Error("idle client bucket was not swept")
+	}
+	if ok, _ := newClientLimiter(0, 1).allow("10.0.0.1", now); !ok {
+		t.Error("rps 0 should not limit")
+	}
+}
+
+func TestCrawlAdmissionQueue(t *testing.T) {
+	a := newCrawlAdmission(1, 1, time.Second)
+	release, ok := a.acquire(context.Background())
+	if !ok {
+		t.Fatal("first crawl was not admitted")
+	}
+
+	// The second crawl queues and gets the slot once the first is done
+	got := make(chan bool)
+	go func() {
+		r, ok := a.acquire(context.Background())
+		if ok {
+			r()
+		}
+		got <- ok
+	}()
+	for {
+		a.mu.Lock()
+		queued := a.queued
+		a.mu.Unlock()
+		if queued == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// The queue is full, so a third crawl is refused right away
+	if _, ok := a.acquire(context.Background()); ok {
+		t.Fatal("crawl beyond the queue was admitted")
+	}
+	if a.retryAfter() < 1 {
+		t.Errorf("retryAfter = %d, want at least 1", a.retryAfter())
+	}
+	release()
+	if !<-got {
+		t.Fatal("queued crawl did not get the released slot")
+	}
+
+	// A queued crawl gives up after the timeout
+	short := newCrawlAdmission(1, 1, 10*time.Millisecond)
+	release, _ = short.acquire(context.Background())
+	defer release()
+	if _, ok := short.acquire(context.Background()); ok {
+		t.Error("queued crawl was admitted past its timeout")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	old := admissionCfg.TrustProxy
+	t.Cleanup(func() { admissionCfg.TrustProxy = old })
+	r := httptest.NewRequest("GET", "/zonerama", nil)
+	r.RemoteAddr = "192.0.2.1:5000"
+	r.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+
+	admissionCfg.TrustProxy = false
+	if got := clientIP(r); got != "192.0.2.1" {
+		t.Errorf("untrusted proxy headers: got %q", got)
+	}
+	admissionCfg.TrustProxy = true
+	if got := clientIP(r); got != "203.0.113.7" {
+		t.Errorf("X-Forwarded-For: got %q", got)
+	}
+	r.Header.Del("X-Forwarded-For")
+	r.Header.Set("X-Real-IP", "203.0.113.8")
+	if got := clientIP(r); got != "203.0.113.8" {
+		t.Errorf("X-Real-IP: got %q", got)
+	}
+}
+
+func TestAdmitCrawlBusy(t *testing.T) {
+	old := crawlSlots
+	crawlSlots = newCrawlAdmission(1, 0, time.Second)
+	t.Cleanup(func() { crawlSlots = old })
+	release, _ := crawlSlots.acquire(context.Background())
+	defer release()
+
+	w := httptest.NewRecorder()
+	admitCrawl(func(http.ResponseWriter, *http.Request) {
+		t.Error("handler ran without a crawl slot")
+	})(w, httptest.NewRequest("GET", "/zonerama", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("got %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+}
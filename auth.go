@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiKey describes one client key and its quotas. Zero values mean "no limit".
+type apiKey struct {
+	Key               string `json:"key"`
+	Name              string `json:"name,omitempty"`
+	RequestsPerMinute int    `json:"requests_per_minute,omitempty"`
+	RequestsPerDay    int    `json:"requests_per_day,omitempty"`
+	MaxConcurrent     int    `json:"max_concurrent,omitempty"`
+	MaxAlbumLimit     int    `json:"max_album_limit,omitempty"`
+	MaxPhotoLimit     int    `json:"max_photo_limit,omitempty"`
+}
+
+// keyUsage is the mutable quota state of a key.
+type keyUsage struct {
+	minuteStart time.Time
+	minuteCount int
+	dayStart    time.Time
+	dayCount    int
+	active      int
+}
+
+// apiKeyStore holds the configured keys. With no keys configured, auth is disabled.
+type apiKeyStore struct {
+	mu    sync.Mutex
+	keys  []apiKey
+	usage map[string]*keyUsage
+}
+
+type apiKeyCtxKey struct{}
+
+var apiKeys = loadAPIKeys()
+
+// loadAPIKeys reads keys from ZONERAMA_API_KEYS_FILE (JSON, with quotas) and
+// ZONERAMA_API_KEYS (comma-separated, no quotas).
+func loadAPIKeys() *apiKeyStore {
+	s := &apiKeyStore{usage: make(map[string]*keyUsage)}
+	if path := envString("ZONERAMA_API_KEYS_FILE", ""); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("auth: reading %s: %v", path, err)
+		}
+		var f struct {
+			Keys []apiKey `json:"keys"`
+		}
+		if err := json.Unmarshal(data, &f); err != nil {
+			log.Fatalf("auth: parsing %s: %v", path, err)
+		}
+		s.keys = append(s.keys, f.Keys...)
+	}
+	for _, k := range strings.Split(envString("ZONERAMA_API_KEYS", ""), ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			s.keys = append(s.keys, apiKey{Key: k})
+		}
+	}
+	return s
+}
+
+func (s *apiKeyStore) enabled() bool {
+	return len(s.keys) > 0
+}
+
+// lookup finds a key using a constant-time comparison.
+func (s *apiKeyStore) lookup(key string) (apiKey, bool) {
+	for _, k := range s.keys {
+		if k.Key != "" && subtle.ConstantTimeCompare([]byte(k.Key), []byte(key)) == 1 {
+			return k, true
+		}
+	}
+	return apiKey{}, false
+}
+
+// quotaError carries what the 429 response needs.
+type quotaError struct {
+	reason string
+	limit  int
+	reset  time.Time
+}
+
+// acquire counts one request against k and reserves a concurrent slot.
+// The returned func releases the slot.
+func (s *apiKeyStore) acquire(k apiKey, now time.Time) (release func(), remaining int, qe *quotaError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.usage[k.Key]
+	if !ok {
+		u = &keyUsage{}
+		s.usage[k.Key] = u
+	}
+	if now.Sub(u.minuteStart) >= time.Minute {
+		u.minuteStart, u.minuteCount = now, 0
+	}
+	if now.Sub(u.dayStart) >= 24*time.Hour {
+		u.dayStart, u.dayCount = now, 0
+	}
+	if k.RequestsPerMinute > 0 && u.minuteCount >= k.RequestsPerMinute {
+		return nil, 0, &quotaError{reason: "per-minute request quota exceeded", limit: k.RequestsPerMinute, reset: u.minuteStart.Add(time.Minute)}
+	}
+	if k.RequestsPerDay > 0 && u.dayCount >= k.RequestsPerDay {
+		return nil, 0, &quotaError{reason: "daily request quota exceeded", limit: k.RequestsPerDay, reset: u.dayStart.Add(24 * time.Hour)}
+	}
+	if k.MaxConcurrent > 0 && u.active >= k.MaxConcurrent {
+		return nil, 0, &quotaError{reason: "concurrent crawl quota exceeded", limit: k.MaxConcurrent, reset: now.Add(time.Second)}
+	}
+	u.minuteCount++
+	u.dayCount++
+	u.active++
+	remaining = -1
+	if k.RequestsPerMinute > 0 {
+		remaining = k.RequestsPerMinute - u.minuteCount
+	}
+	return func() {
+		s.mu.Lock()
+		u.active--
+		s.mu.Unlock()
+	}, remaining, nil
+}
+
+// apiKeyFromRequest reads the key from X-API-Key, Authorization: Bearer or ?api_key=.
+func apiKeyFromRequest(r *http.Request) string {
+	if k := strings.TrimSpace(r.Header.Get("X-API-Key")); k != "" {
+		return k
+	}
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(h, "Bearer "))
+	}
+	return strings.TrimSpace(r.URL.Query().Get("api_key"))
+}
+
+// requireAPIKey authenticates the request and enforces the key's quotas.
+// album_limit/photo_limit are clamped to the key's maximums before the handler sees them.
+func requireAPIKey(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !apiKeys.enabled() {
+			next(w, r)
+			return
+		}
+		k, ok := apiKeys.lookup(apiKeyFromRequest(r))
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="zonerama"`)
+			writeJSONError(w, http.StatusUnauthorized, "missing or invalid API key (use X-API-Key header, Authorization: Bearer or api_key param)")
+			return
+		}
+		release, remaining, qe := apiKeys.acquire(k, time.Now())
+		if qe != nil {
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(qe.limit))
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(qe.reset.Unix(), 10))
+			w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(qe.reset).Seconds())+1))
+			writeJSONError(w, http.StatusTooManyRequests, qe.reason)
+			return
+		}
+		defer release()
+		if k.RequestsPerMinute > 0 {
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(k.RequestsPerMinute))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		}
+
+		q := r.URL.Query()
+		clampLimit(q, "album_limit", 5, k.MaxAlbumLimit)
+		clampLimit(q, "photo_limit", 10, k.MaxPhotoLimit)
+		r.URL.RawQuery = q.Encode()
+		next(w, r.WithContext(context.WithValue(r.Context(), apiKeyCtxKey{}, k)))
+	}
+}
+
+// clampLimit caps a "0 = no limit" query param at max (when max > 0).
+func clampLimit(q url.Values, name string, def, max int) {
+	if max <= 0 {
+		return
+	}
+	n := def
+	if vs := q[name]; len(vs) > 0 && vs[0] != "" {
+		fmt.Sscanf(vs[0], "%d", &n)
+	}
+	if n <= 0 || n > max {
+		n = max
+	}
+	q[name] = []string{strconv.Itoa(n)}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestAPIKeyFromRequest(t *testing.T) {
+	tests := []struct {
+		header, value, query, want string
+	}{
+		{"X-API-Key", " k1 ", "", "k1"},
+		{"Authorization", "Bearer k2", "", "k2"},
+		{"Authorization", "Basic k2", "", ""},
+		{"", "", "api_key=k3", "k3"},
+		// The header wins over the query param
+		{"X-API-Key", "k1", "api_key=k3", "k1"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/zonerama?"+tt.query, nil)
+		if tt.header != "" {
+			r.Header.Set(tt.header, tt.value)
+		}
+		if got := apiKeyFromRequest(r); got != tt.want {
+			t.Errorf("%s: %q, ?%s: got %q, want %q", tt.header, tt.value, tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestAPIKeyQuotas(t *testing.T) {
+	s := &apiKeyStore{usage: map[string]*keyUsage{}}
+	k := apiKey{Key: "k", RequestsPerMinute: 2, RequestsPerDay: 3, MaxConcurrent: 1}
+	now := time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	release, remaining, qe := s.acquire(k, now)
+	if qe != nil || remaining != 1 {
+		t.Fatalf("first request: remaining %d, error %+v", remaining, qe)
+	}
+	if _, _, qe := s.acquire(k, now); qe == nil || qe.reason != "concurrent crawl quota exceeded" {
+		t.Fatalf("second concurrent request: got %+v", qe)
+	}
+	release()
+	release, remaining, qe = s.acquire(k, now.Add(time.Second))
+	if qe != nil || remaining != 0 {
+		t.Fatalf("second request: remaining %d, error %+v", remaining, qe)
+	}
+	release()
+	_, _, qe = s.acquire(k, now.Add(2*time.Second))
+	if qe == nil || qe.limit != 2 || !qe.reset.Equal(now.Add(time.Minute)) {
+		t.Fatalf("third request in the minute: got %+v", qe)
+	}
+	// A new minute resets the minute quota but not the day
+	release, _, qe = s.acquire(k, now.Add(time.Minute))
+	if qe != nil {
+		t.Fatalf("next minute: %+v", qe)
+	}
+	release()
+	_, _, qe = s.acquire(k, now.Add(2*time.Minute))
+	if qe == nil || qe.reason != "daily request quota exceeded" || !qe.reset.Equal(now.Add(24*time.Hour)) {
+		t.Fatalf("fourth request of the day: got %+v", qe)
+	}
+	if _, _, qe = s.acquire(k, now.Add(24*time.Hour)); qe != nil {
+		t.Errorf("next day: %+v", qe)
+	}
+}
+
+func TestClampLimit(t *testing.T) {
+	tests := []struct {
+		query string
+		max   int
+		want  string
+	}{
+		{"", 20, "5"},
+		{"album_limit=8", 20, "8"},
+		{"album_limit=50", 20, "20"},
+		// 0 means "no limit", which a capped key cannot ask for
+		{"album_limit=0", 20, "20"},
+		{"album_limit=50", 0, "50"},
+	}
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.query)
+		clampLimit(q, "album_limit", 5, tt.max)
+		if got := q.Get("album_limit"); got != tt.want {
+			t.Errorf("%q with max %d: got %q, want %q", tt.query, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestRequireAPIKey(t *testing.T) {
+	old := apiKeys
+	apiKeys = &apiKeyStore{usage: map[string]*keyUsage{}, keys: []apiKey{{Key: "secret", RequestsPerMinute: 1, MaxAlbumLimit: 3}}}
+	t.Cleanup(func() { apiKeys = old })
+
+	var seen url.Values
+	h := requireAPIKey(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.URL.Query()
+		if k, ok := r.Context().Value(apiKeyCtxKey{}).(apiKey); !ok || k.Key != "secret" {
+			t.Error("handler did not get the key in its context")
+		}
+	})
+
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest("GET", "/zonerama?api_key=wrong", nil))
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("wrong key: got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	h(w, httptest.NewRequest("GET", "/zonerama?api_key=secret&album_limit=10", nil))
+	if w.Code != http.StatusOK || seen.Get("album_limit") != "3" || w.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Errorf("valid key: got %d, album_limit %q, remaining %q", w.Code, seen.Get("album_limit"), w.Header().Get("X-RateLimit-Remaining"))
+	}
+
+	w = httptest.NewRecorder()
+	h(w, httptest.NewRequest("GET", "/zonerama?api_key=secret", nil))
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" || w.Header().Get("X-RateLimit-Reset") == "" {
+		t.Errorf("over quota: got %d, headers %v", w.Code, w.Header())
+	}
+}
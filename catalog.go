@@ -0,0 +1,620 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// catalogStore keeps every scraped Response in SQLite so accounts can be read
+// back, and compared, without touching Zonerama. Rows are upserted; first_seen
+// is set once and last_seen on every scrape that includes the row. removed_at
+// is set when a complete scrape no longer has the row, and cleared if it returns.
+type catalogStore struct {
+	db *sql.DB
+}
+
+// Enabled by ZONERAMA_CATALOG=<path to .db>; nil when unset.
+var catalog *catalogStore
+
+const catalogSchema = `
+CREATE TABLE IF NOT EXISTS accounts (
+	account    TEXT PRIMARY KEY, -- account name, or "id:<AccountId>" when only the ID is known
+	account_id TEXT NOT NULL DEFAULT '',
+	url        TEXT NOT NULL DEFAULT '',
+	first_seen TEXT NOT NULL,
+	last_seen  TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS tabs (
+	account    TEXT NOT NULL,
+	tab_id     TEXT NOT NULL,
+	url        TEXT NOT NULL DEFAULT '',
+	first_seen TEXT NOT NULL,
+	last_seen  TEXT NOT NULL,
+	PRIMARY KEY (account, tab_id)
+);
+CREATE TABLE IF NOT EXISTS albums (
+	id           TEXT PRIMARY KEY,
+	account      TEXT NOT NULL DEFAULT '',
+	tab_id       TEXT NOT NULL DEFAULT '',
+	title        TEXT NOT NULL DEFAULT '',
+	url          TEXT NOT NULL DEFAULT '',
+	date         TEXT NOT NULL DEFAULT '',
+	date_iso     TEXT NOT NULL DEFAULT '',
+	date_end_iso TEXT NOT NULL DEFAULT '',
+	photos_count INTEGER NOT NULL DEFAULT 0,
+	views_count  INTEGER NOT NULL DEFAULT 0,
+	likes_count  INTEGER,
+	cover_url    TEXT NOT NULL DEFAULT '',
+	first_seen   TEXT NOT NULL,
+	last_seen    TEXT NOT NULL,
+	removed_at   TEXT
+);
+CREATE INDEX IF NOT EXISTS albums_account ON albums (account, date_iso);
+CREATE TABLE IF NOT EXISTS photos (
+	id          TEXT PRIMARY KEY,
+	album_id    TEXT NOT NULL,
+	type        TEXT NOT NULL DEFAULT 'photo',
+	page_url    TEXT NOT NULL DEFAULT '',
+	image_1500  TEXT NOT NULL DEFAULT '',
+	width       INTEGER NOT NULL DEFAULT 0,
+	height      INTEGER NOT NULL DEFAULT 0,
+	likes_count INTEGER,
+	first_seen  TEXT NOT NULL,
+	last_seen   TEXT NOT NULL,
+	removed_at  TEXT
+);
+CREATE INDEX IF NOT EXISTS photos_album ON photos (album_id);
+CREATE TABLE IF NOT EXISTS photo_hashes (
+	photo_id    TEXT PRIMARY KEY, -- perceptual hashes from dupes=true, 16 hex digits each
+	ahash       TEXT NOT NULL,
+	dhash       TEXT NOT NULL,
+	phash       TEXT NOT NULL,
+	computed_at TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS watches (
+	id               TEXT PRIMARY KEY,
+	link             TEXT NOT NULL,
+	params           TEXT NOT NULL DEFAULT '{}', -- JSON object of /zonerama query parameters
+	interval_seconds INTEGER NOT NULL,
+	created_at       TEXT NOT NULL,
+	next_run         TEXT NOT NULL,
+	last_run         TEXT NOT NULL DEFAULT '',
+	last_status      TEXT NOT NULL DEFAULT '',
+	last_error       TEXT NOT NULL DEFAULT '',
+	last_result      TEXT, -- latest successful response
+	runs             INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS watches_next_run ON watches (next_run);
+CREATE TABLE IF NOT EXISTS webhooks (
+	id         TEXT PRIMARY KEY,
+	url        TEXT NOT NULL,
+	secret     TEXT NOT NULL,
+	events     TEXT NOT NULL, -- comma-separated
+	watch_id   TEXT NOT NULL DEFAULT '', -- only this watch's events when set
+	created_at TEXT NOT NULL,
+	owner      TEXT NOT NULL DEFAULT '' -- SHA-256 of the creating API key
+);
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id          TEXT PRIMARY KEY,
+	webhook_id  TEXT NOT NULL,
+	event       TEXT NOT NULL,
+	payload     TEXT NOT NULL,
+	attempts    INTEGER NOT NULL DEFAULT 0,
+	status_code INTEGER NOT NULL DEFAULT 0,
+	error       TEXT NOT NULL DEFAULT '',
+	delivered   INTEGER NOT NULL DEFAULT 0,
+	created_at  TEXT NOT NULL,
+	updated_at  TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_hook ON webhook_deliveries (webhook_id, created_at);
+`
+
+func openCatalog(path string) (*catalogStore, error) {
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows one writer; a single connection avoids SQLITE_BUSY between handlers
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(catalogSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating catalog schema: %w", err)
+	}
+	for _, m := range catalogMigrations {
+		if _, err := db.Exec(m); err != nil && !strings.Contains(err.Error(), "duplicate column") {
+			db.Close()
+			return nil, fmt.Errorf("migrating catalog schema: %w", err)
+		}
+	}
+	return &catalogStore{db: db}, nil
+}
+
+// Columns added after a table was first released; catalogs created since already have them.
+var catalogMigrations = []string{
+	`ALTER TABLE albums ADD COLUMN removed_at TEXT`,
+	`ALTER TABLE photos ADD COLUMN removed_at TEXT`,
+	`ALTER TABLE albums ADD COLUMN date_end_iso TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE webhooks ADD COLUMN owner TEXT NOT NULL DEFAULT ''`,
+}
+
+// catalogAccount is the key albums are filed under for a scraped link.
+func catalogAccount(c *CanonicalLink) string {
+	if c == nil {
+		return ""
+	}
+	if c.Account != "" {
+		return c.Account
+	}
+	if c.AccountID != "" {
+		return "id:" + c.AccountID
+	}
+	return ""
+}
+
+// upsert stores one scrape. Albums and photos missing from resp are only marked
+// removed when completeAlbums/completePhotos say the scrape saw everything (see
+// scrapeIsComplete); otherwise their last_seen simply stops advancing, since
+// limits and filters make most scrapes partial.
+func (c *catalogStore) upsert(resp *Response, completeAlbums, completePhotos bool) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	account := catalogAccount(resp.Canonical)
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if account != "" {
+		if _, err := tx.Exec(`INSERT INTO accounts (account, account_id, url, first_seen, last_seen) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (account) DO UPDATE SET account_id = COALESCE(NULLIF(excluded.account_id, ''), account_id), last_seen = excluded.last_seen`,
+			account, resp.Canonical.AccountID, accountURL(resp.Canonical), now, now); err != nil {
+			return err
+		}
+		if tab := resp.Canonical.TabID; tab != "" {
+			if _, err := tx.Exec(`INSERT INTO tabs (account, tab_id, url, first_seen, last_seen) VALUES (?, ?, ?, ?, ?)
+				ON CONFLICT (account, tab_id) DO UPDATE SET url = excluded.url, last_seen = excluded.last_seen`,
+				account, tab, resp.Canonical.URL, now, now); err != nil {
+				return err
+			}
+		}
+	}
+	tabID := ""
+	if resp.Canonical != nil {
+		tabID = resp.Canonical.TabID
+	}
+	for _, a := range resp.Albums {
+		if a.ID == "" {
+			continue
+		}
+		cover := ""
+		if a.Cover != nil {
+			cover = a.Cover.URL
+		}
+		if _, err := tx.Exec(`INSERT INTO albums (id, account, tab_id, title, url, date, date_iso, date_end_iso, photos_count, views_count, likes_count, cover_url, first_seen, last_seen)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (id) DO UPDATE SET
+				account = COALESCE(NULLIF(excluded.account, ''), account),
+				tab_id = COALESCE(NULLIF(excluded.tab_id, ''), tab_id),
+				url = excluded.url,
+				title = CASE WHEN excluded.title <> '' THEN excluded.title ELSE title END,
+				date = CASE WHEN excluded.date <> '' THEN excluded.date ELSE date END,
+				date_iso = CASE WHEN excluded.date <> '' THEN excluded.date_iso ELSE date_iso END,
+				date_end_iso = CASE WHEN excluded.date <> '' THEN excluded.date_end_iso ELSE date_end_iso END,
+				photos_count = CASE WHEN excluded.photos_count > 0 THEN excluded.photos_count ELSE photos_count END,
+				views_count = CASE WHEN excluded.views_count > 0 THEN excluded.views_count ELSE views_count END,
+				likes_count = COALESCE(excluded.likes_count, likes_count),
+				cover_url = COALESCE(NULLIF(excluded.cover_url, ''), cover_url),
+				last_seen = excluded.last_seen, removed_at = NULL`,
+			a.ID, account, tabID, a.Title, a.URL, a.Date, a.DateISO, a.DateEndISO, a.PhotosCnt, a.ViewsCnt, a.LikesCount, cover, now, now); err != nil {
+			return err
+		}
+		for _, p := range a.Photos {
+			if _, err := tx.Exec(`INSERT INTO photos (id, album_id, type, page_url, image_1500, width, height, likes_count, first_seen, last_seen)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+				ON CONFLICT (id) DO UPDATE SET
+					album_id = excluded.album_id, type = excluded.type,
+					page_url = COALESCE(NULLIF(excluded.page_url, ''), page_url),
+					image_1500 = excluded.image_1500,
+					width = CASE WHEN excluded.width > 0 THEN excluded.width ELSE width END,
+					height = CASE WHEN excluded.height > 0 THEN excluded.height ELSE height END,
+					likes_count = COALESCE(excluded.likes_count, likes_count),
+					last_seen = excluded.last_seen, removed_at = NULL`,
+				p.ID, a.ID, p.Type, p.PageURL, p.Image1500, p.Width, p.Height, p.LikesCount, now, now); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Mark what a complete scrape no longer has, so the next diff does not report it again
+	if where, args := snapshotScope(resp.Canonical); completeAlbums && where != "" {
+		var ids []string
+		for _, a := range resp.Albums {
+			if a.ID != "" {
+				ids = append(ids, a.ID)
+			}
+		}
+		notIn, idArgs := notInClause("id", ids)
+		if _, err := tx.Exec(`UPDATE albums SET removed_at = ? WHERE removed_at IS NULL AND `+where+notIn,
+			append(append([]any{now}, args...), idArgs...)...); err != nil {
+			return err
+		}
+	}
+	if completePhotos {
+		for _, a := range resp.Albums {
+			// Albums kept from their tiles (mode=incremental) carry no photos to compare
+			if a.ID == "" || len(a.Photos) == 0 {
+				continue
+			}
+			ids := make([]string, len(a.Photos))
+			for i, p := range a.Photos {
+				ids[i] = p.ID
+			}
+			notIn, idArgs := notInClause("id", ids)
+			if _, err := tx.Exec(`UPDATE photos SET removed_at = ? WHERE removed_at IS NULL AND album_id = ?`+notIn,
+				append([]any{now, a.ID}, idArgs...)...); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// notInClause is " AND column NOT IN (?, ...)" for ids, empty when there are none.
+func notInClause(column string, ids []string) (string, []any) {
+	if len(ids) == 0 {
+		return "", nil
+	}
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return ` AND ` + column + ` NOT IN (?` + strings.Repeat(`, ?`, len(ids)-1) + `)`, args
+}
+
+func accountURL(c *CanonicalLink) string {
+	if c.Kind == "account" {
+		return c.URL
+	}
+	if c.Account != "" {
+		return c.base + "/" + c.Account
+	}
+	return ""
+}
+
+// storeResponse upserts a scrape made with the query q when the catalog is
+// enabled; failures are logged, not returned to the client.
+func storeResponse(resp *Response, q url.Values) {
+	if catalog == nil || resp.Canonical == nil {
+		return
+	}
+	completeAlbums, completePhotos := scrapeIsComplete(resp.Canonical, q, resp)
+	if err := catalog.upsert(resp, completeAlbums, completePhotos); err != nil {
+		log.Printf("catalog: storing %s: %v", resp.InputLink, err)
+	}
+}
+
+// CatalogAlbum is an album as stored, with its sighting timestamps.
+type CatalogAlbum struct {
+	ID          string `json:"id"`
+	Account     string `json:"account,omitempty"`
+	TabID       string `json:"tab_id,omitempty"`
+	Title       string `json:"title"`
+	URL         string `json:"url"`
+	Date        string `json:"date,omitempty"`
+	DateISO     string `json:"date_iso,omitempty"`
+	DateEndISO  string `json:"date_end_iso,omitempty"`
+	PhotosCnt   int    `json:"photos_count"`
+	ViewsCnt    int    `json:"views_count"`
+	LikesCount  *int   `json:"likes_count,omitempty"`
+	CoverURL    string `json:"cover_url,omitempty"`
+	StoredCount int    `json:"stored_photos"` // photos of this album in the catalog
+	FirstSeen   string `json:"first_seen"`
+	LastSeen    string `json:"last_seen"`
+	RemovedAt   string `json:"removed_at,omitempty"` // when a complete scrape no longer found it
+}
+
+// CatalogPhoto is a photo as stored.
+type CatalogPhoto struct {
+	ID         string       `json:"id"`
+	AlbumID    string       `json:"album_id"`
+	Type       string       `json:"type"`
+	PageURL    string       `json:"page_url,omitempty"`
+	Image1500  string       `json:"image_1500"`
+	Width      int          `json:"width,omitempty"`
+	Height     int          `json:"height,omitempty"`
+	LikesCount *int         `json:"likes_count,omitempty"`
+	Hashes     *PhotoHashes `json:"hashes,omitempty"` // once a dupes=true scrape hashed it
+	FirstSeen  string       `json:"first_seen"`
+	LastSeen   string       `json:"last_seen"`
+	RemovedAt  string       `json:"removed_at,omitempty"`
+}
+
+// CatalogAccount is an account as stored.
+type CatalogAccount struct {
+	Account   string `json:"account"`
+	AccountID string `json:"account_id,omitempty"`
+	URL       string `json:"url,omitempty"`
+	Albums    int    `json:"albums"`
+	FirstSeen string `json:"first_seen"`
+	LastSeen  string `json:"last_seen"`
+}
+
+// albums lists stored albums of an account (all accounts when empty), newest first.
+func (c *catalogStore) albums(account string, limit, offset int) ([]CatalogAlbum, error) {
+	q := `SELECT a.id, a.account, a.tab_id, a.title, a.url, a.date, a.date_iso, a.date_end_iso, a.photos_count, a.views_count, a.likes_count, a.cover_url,
+			(SELECT COUNT(*) FROM photos p WHERE p.album_id = a.id), a.first_seen, a.last_seen, COALESCE(a.removed_at, '')
+		FROM albums a`
+	var args []any
+	if account != "" {
+		where, accountArgs := accountClause("a.account", account)
+		q += ` WHERE ` + where
+		args = append(args, accountArgs...)
+	}
+	q += ` ORDER BY a.date_iso DESC, a.title LIMIT ? OFFSET ?`
+	args = append(args, limit, offset)
+	rows, err := c.db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []CatalogAlbum{}
+	for rows.Next() {
+		var a CatalogAlbum
+		var likes sql.NullInt64
+		if err := rows.Scan(&a.ID, &a.Account, &a.TabID, &a.Title, &a.URL, &a.Date, &a.DateISO, &a.DateEndISO, &a.PhotosCnt, &a.ViewsCnt, &likes, &a.CoverURL, &a.StoredCount, &a.FirstSeen, &a.LastSeen, &a.RemovedAt); err != nil {
+			return nil, err
+		}
+		a.LikesCount = nullInt(likes)
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+func (c *catalogStore) photos(albumID string, limit, offset int) ([]CatalogPhoto, error) {
+	rows, err := c.db.Query(`SELECT p.id, p.album_id, p.type, p.page_url, p.image_1500, p.width, p.height, p.likes_count, h.ahash, h.dhash, h.phash, p.first_seen, p.last_seen, COALESCE(p.removed_at, '')
+		FROM photos p LEFT JOIN photo_hashes h ON h.photo_id = p.id
+		WHERE p.album_id = ? ORDER BY p.first_seen, p.id LIMIT ? OFFSET ?`, albumID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []CatalogPhoto{}
+	for rows.Next() {
+		var p CatalogPhoto
+		var likes sql.NullInt64
+		var ahash, dhash, phash sql.NullString
+		if err := rows.Scan(&p.ID, &p.AlbumID, &p.Type, &p.PageURL, &p.Image1500, &p.Width, &p.Height, &likes, &ahash, &dhash, &phash, &p.FirstSeen, &p.LastSeen, &p.RemovedAt); err != nil {
+			return nil, err
+		}
+		p.LikesCount = nullInt(likes)
+		if ahash.Valid {
+			p.Hashes = &PhotoHashes{AHash: ahash.String, DHash: dhash.String, PHash: phash.String}
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+func (c *catalogStore) accounts() ([]CatalogAccount, error) {
+	rows, err := c.db.Query(`SELECT ac.account, ac.account_id, ac.url, (SELECT COUNT(*) FROM albums a WHERE a.account = ac.account), ac.first_seen, ac.last_seen
+		FROM accounts ac ORDER BY ac.account`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []CatalogAccount{}
+	for rows.Next() {
+		var a CatalogAccount
+		if err := rows.Scan(&a.Account, &a.AccountID, &a.URL, &a.Albums, &a.FirstSeen, &a.LastSeen); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+// snapshotScope is the albums WHERE clause for what a link covers: one album,
+// one tab or a whole account. It is empty for links the catalog cannot file.
+func snapshotScope(canon *CanonicalLink) (string, []any) {
+	if canon == nil {
+		return "", nil
+	}
+	switch account := catalogAccount(canon); {
+	case canon.AlbumID != "":
+		return `id = ?`, []any{canon.AlbumID}
+	case account != "":
+		where, args := accountClause("account", account)
+		if canon.TabID != "" {
+			where += ` AND tab_id = ?`
+			args = append(args, canon.TabID)
+		}
+		return where, args
+	}
+	return "", nil
+}
+
+// snapshot loads what the catalog holds for a link (one album, one tab or a
+// whole account) as a Response, photos carrying only their IDs. Rows marked
+// removed are left out, so a removal is reported once. since is the latest
+// last_seen among those albums, empty when nothing is stored yet.
+func (c *catalogStore) snapshot(canon *CanonicalLink) (*Response, string, error) {
+	resp := &Response{Canonical: canon, Albums: []Album{}}
+	where, args := snapshotScope(canon)
+	if where == "" {
+		return resp, "", nil
+	}
+	where = `removed_at IS NULL AND ` + where
+
+	// One connection: each result set is drained before the next query
+	rows, err := c.db.Query(`SELECT id, title, url, date, date_iso, date_end_iso, photos_count, views_count, likes_count, cover_url, last_seen
+		FROM albums WHERE `+where, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	index := map[string]int{}
+	since := ""
+	for rows.Next() {
+		var a Album
+		var likes sql.NullInt64
+		var cover, seen string
+		if err := rows.Scan(&a.ID, &a.Title, &a.URL, &a.Date, &a.DateISO, &a.DateEndISO, &a.PhotosCnt, &a.ViewsCnt, &likes, &cover, &seen); err != nil {
+			rows.Close()
+			return nil, "", err
+		}
+		a.LikesCount = nullInt(likes)
+		if cover != "" {
+			a.Cover = &Cover{URL: cover}
+		}
+		since = max(since, seen)
+		index[a.ID] = len(resp.Albums)
+		resp.Albums = append(resp.Albums, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	rows, err = c.db.Query(`SELECT id, album_id, type FROM photos WHERE removed_at IS NULL AND album_id IN (SELECT id FROM albums WHERE `+where+`) ORDER BY first_seen, id`, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p Photo
+		var albumID string
+		if err := rows.Scan(&p.ID, &albumID, &p.Type); err != nil {
+			return nil, "", err
+		}
+		if i, ok := index[albumID]; ok {
+			resp.Albums[i].Photos = append(resp.Albums[i].Photos, p)
+		}
+	}
+	return resp, since, rows.Err()
+}
+
+// albumPhotoCounts maps the stored album IDs of an account to their photo counts.
+func (c *catalogStore) albumPhotoCounts(account string) (map[string]int, error) {
+	counts := map[string]int{}
+	if account == "" {
+		return counts, nil
+	}
+	where, args := accountClause("account", account)
+	rows, err := c.db.Query(`SELECT id, photos_count FROM albums WHERE `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var n int
+		if err := rows.Scan(&id, &n); err != nil {
+			return nil, err
+		}
+		counts[id] = n
+	}
+	return counts, rows.Err()
+}
+
+// accountClause matches albums filed under account, or under the name of the
+// account whose ID it is ("id:<AccountId>").
+func accountClause(column, account string) (string, []any) {
+	return `(` + column + ` = ? OR ` + column + ` IN (SELECT account FROM accounts WHERE account_id = ?))`,
+		[]any{account, strings.TrimPrefix(account, "id:")}
+}
+
+func nullInt(n sql.NullInt64) *int {
+	if !n.Valid {
+		return nil
+	}
+	v := int(n.Int64)
+	return &v
+}
+
+// catalogPage reads limit (default 100, max 1000) and offset for catalog listings.
+func catalogPage(r *http.Request) (limit, offset int, err error) {
+	limit = 100
+	if s := r.URL.Query().Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit < 1 || limit > 1000 {
+			return 0, 0, errors.New("limit must be 1-1000")
+		}
+	}
+	if s := r.URL.Query().Get("offset"); s != "" {
+		if offset, err = strconv.Atoi(s); err != nil || offset < 0 {
+			return 0, 0, errors.New("offset must be >= 0")
+		}
+	}
+	return limit, offset, nil
+}
+
+// catalogHandler serves /catalog/accounts, /catalog/albums?account= and /catalog/photos?album=
+// from the store only.
+func catalogHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if catalog == nil {
+		writeJSONError(w, http.StatusNotFound, "catalog is disabled; set ZONERAMA_CATALOG to a database path")
+		return
+	}
+	limit, offset, err := catalogPage(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var out any
+	switch strings.TrimSuffix(r.URL.Path, "/") {
+	case "/catalog/accounts":
+		var accounts []CatalogAccount
+		accounts, err = catalog.accounts()
+		out = map[string]any{"accounts": accounts}
+	case "/catalog/albums":
+		var albums []CatalogAlbum
+		albums, err = catalog.albums(strings.TrimSpace(r.URL.Query().Get("account")), limit, offset)
+		out = map[string]any{"albums": albums}
+	case "/catalog/photos":
+		album := strings.TrimSpace(r.URL.Query().Get("album"))
+		if album == "" {
+			writeJSONError(w, http.StatusBadRequest, "missing album param: /catalog/photos?album=<AlbumId>")
+			return
+		}
+		var photos []CatalogPhoto
+		photos, err = catalog.photos(album, limit, offset)
+		out = map[string]any{"photos": photos}
+	case "/catalog/duplicates":
+		account := strings.TrimSpace(r.URL.Query().Get("account"))
+		if account == "" {
+			writeJSONError(w, http.StatusBadRequest, "missing account param: /catalog/duplicates?account=<Account>")
+			return
+		}
+		maxDistance, derr := parseDupeDistance(r.URL.Query().Get("dupe_distance"))
+		if derr != nil {
+			writeJSONError(w, http.StatusBadRequest, derr.Error())
+			return
+		}
+		// Grouping compares every pair, so it takes the same cap as dupes=true
+		var (
+			items   []hashedPhoto
+			skipped int
+		)
+		if items, skipped, err = catalog.accountHashedPhotos(account, dupeMaxPhotos); err == nil {
+			out = &DuplicateReport{MaxDistance: maxDistance, Hashed: len(items), Skipped: skipped, Groups: groupDuplicates(items, maxDistance)}
+		}
+	default:
+		writeJSONError(w, http.StatusNotFound, "unknown catalog endpoint; use /catalog/accounts, /catalog/albums, /catalog/photos or /catalog/duplicates")
+		return
+	}
+	if err != nil {
+		log.Printf("catalog: %s: %v", r.URL.Path, err)
+		writeJSONError(w, http.StatusInternalServerError, "catalog query failed")
+		return
+	}
+	writeJSON(w, r, out)
+}
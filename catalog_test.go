@@ -0,0 +1,182 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// testCatalog opens an empty catalog in a temporary directory.
+func testCatalog(t *testing.T) *catalogStore {
+	t.Helper()
+	c, err := openCatalog(filepath.Join(t.TempDir(), "catalog.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.db.Close() })
+	return c
+}
+
+// profileScrape is a scrape of the FKKofolaKrnov tab with the given albums.
+func profileScrape(t *testing.T, albums []Album) *Response {
+	t.Helper()
+	canon, err := resolveZoneramaLink("https://eu.zonerama.com/FKKofolaKrnov/1470757")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Response{InputLink: canon.URL, Canonical: canon, Albums: albums}
+}
+
+func storedIDs(t *testing.T, c *catalogStore, removed bool) []string {
+	t.Helper()
+	albums, err := c.albums("FKKofolaKrnov", 100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{}
+	for _, a := range albums {
+		if (a.RemovedAt != "") == removed {
+			ids = append(ids, a.ID)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func TestCatalogUpsert(t *testing.T) {
+	c := testCatalog(t)
+	full := fixtureAlbums(t, "snippet1.html")
+	photos := fixturePhotos(t, "snippet3.html")
+	for i := range full {
+		if full[i].ID == "13903610" {
+			full[i].Photos = photos
+		}
+	}
+	if err := c.upsert(profileScrape(t, full), true, true); err != nil {
+		t.Fatal(err)
+	}
+	if got := storedIDs(t, c, false); len(got) != len(full) {
+		t.Fatalf("stored %d albums, want %d", len(got), len(full))
+	}
+	accounts, err := c.accounts()
+	if err != nil || len(accounts) != 1 || accounts[0].Account != "FKKofolaKrnov" || accounts[0].Albums != len(full) {
+		t.Fatalf("accounts: %+v, %v", accounts, err)
+	}
+	stored, err := c.photos("13903610", 100, 0)
+	if err != nil || len(stored) != len(photos) {
+		t.Fatalf("photos: got %d, %v, want %d", len(stored), err, len(photos))
+	}
+	counts, err := c.albumPhotoCounts("FKKofolaKrnov")
+	if err != nil || counts["13903610"] != full[slices.IndexFunc(full, func(a Album) bool { return a.ID == "13903610" })].PhotosCnt {
+		t.Fatalf("photo counts: %v, %v", counts, err)
+	}
+
+	// A partial scrape leaves what it did not see alone
+	clean := fixtureAlbums(t, "clean.html")
+	if err := c.upsert(profileScrape(t, clean), false, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := storedIDs(t, c, true); len(got) != 0 {
+		t.Fatalf("partial scrape removed %v", got)
+	}
+	// A complete one marks the rest removed, and the snapshot leaves them out
+	if err := c.upsert(profileScrape(t, clean), true, true); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"13726013", "13774004", "13774084", "13796305"}
+	if got := storedIDs(t, c, true); !slices.Equal(got, want) {
+		t.Fatalf("removed %v, want %v", got, want)
+	}
+	snap, since, err := c.snapshot(profileScrape(t, nil).Canonical)
+	if err != nil || len(snap.Albums) != len(clean) || since == "" {
+		t.Fatalf("snapshot: %d albums, since %q, %v", len(snap.Albums), since, err)
+	}
+	// Albums that come back are no longer removed
+	if err := c.upsert(profileScrape(t, full), true, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := storedIDs(t, c, true); len(got) != 0 {
+		t.Fatalf("albums still removed after they came back: %v", got)
+	}
+}
+
+func TestCatalogUpsertKeepsKnownFields(t *testing.T) {
+	c := testCatalog(t)
+	full := Album{ID: "13903610", Title: "Zápas", URL: "https://eu.zonerama.com/FKKofolaKrnov/Album/13903610",
+		Date: "1. - 2. 9. 2025", DateISO: "2025-09-01", DateEndISO: "2025-09-02", PhotosCnt: 22, ViewsCnt: 140}
+	if err := c.upsert(profileScrape(t, []Album{full}), false, false); err != nil {
+		t.Fatal(err)
+	}
+	// A scrape that could not read the title, date or counts does not blank them
+	if err := c.upsert(profileScrape(t, []Album{{ID: full.ID, URL: full.URL}}), false, false); err != nil {
+		t.Fatal(err)
+	}
+	// Non-empty values still win
+	if err := c.upsert(profileScrape(t, []Album{{ID: full.ID, URL: full.URL, ViewsCnt: 150}}), false, false); err != nil {
+		t.Fatal(err)
+	}
+	albums, err := c.albums("FKKofolaKrnov", 10, 0)
+	if err != nil || len(albums) != 1 {
+		t.Fatalf("albums: %+v, %v", albums, err)
+	}
+	a := albums[0]
+	if a.Title != full.Title || a.Date != full.Date || a.DateISO != full.DateISO || a.DateEndISO != full.DateEndISO || a.PhotosCnt != 22 || a.ViewsCnt != 150 {
+		t.Errorf("got %+v", a)
+	}
+}
+
+func TestCatalogPhotoRemoval(t *testing.T) {
+	c := testCatalog(t)
+	photos := fixturePhotos(t, "snippet3.html")
+	album := func(list []Photo) []Album {
+		return []Album{{ID: "13903610", Title: "Album", URL: "https://eu.zonerama.com/FKKofolaKrnov/Album/13903610", Photos: list}}
+	}
+	if err := c.upsert(profileScrape(t, album(photos)), false, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.upsert(profileScrape(t, album(photos[:20])), false, true); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := c.photos("13903610", 100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed := 0
+	for _, p := range stored {
+		if p.RemovedAt != "" {
+			removed++
+		}
+	}
+	if removed != 2 {
+		t.Errorf("%d photos marked removed, want 2", removed)
+	}
+	snap, _, err := c.snapshot(profileScrape(t, nil).Canonical)
+	if err != nil || len(snap.Albums) != 1 || len(snap.Albums[0].Photos) != 20 {
+		t.Fatalf("snapshot: %+v, %v", snap, err)
+	}
+}
+
+func TestSnapshotScope(t *testing.T) {
+	tests := []struct {
+		link  string
+		where string
+		args  int
+	}{
+		{"https://eu.zonerama.com/FKKofolaKrnov/Album/13903610", "id = ?", 1},
+		{"https://eu.zonerama.com/FKKofolaKrnov/1470757", "(account = ? OR account IN (SELECT account FROM accounts WHERE account_id = ?)) AND tab_id = ?", 3},
+		{"https://eu.zonerama.com/FKKofolaKrnov", "(account = ? OR account IN (SELECT account FROM accounts WHERE account_id = ?))", 2},
+	}
+	for _, tt := range tests {
+		canon, err := resolveZoneramaLink(tt.link)
+		if err != nil {
+			t.Fatal(err)
+		}
+		where, args := snapshotScope(canon)
+		if where != tt.where || len(args) != tt.args {
+			t.Errorf("%s: got %q %v", tt.link, where, args)
+		}
+	}
+	if where, _ := snapshotScope(nil); where != "" {
+		t.Errorf("nil link: got %q", where)
+	}
+}
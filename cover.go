@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Cover is an album's cover image. Pattern contains {width} and {height}.
+type Cover struct {
+	PhotoID string `json:"photo_id,omitempty"` // set when the owner picked a cover photo
+	Pattern string `json:"pattern"`
+	URL     string `json:"url"` // Pattern at Width x Height
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+}
+
+// Profile tiles are laid out at 560x428 (data-layout-options ratio), so covers default to that.
+const (
+	coverDefaultWidth  = 560
+	coverDefaultHeight = 428
+)
+
+var (
+	coverWidthRe  = regexp.MustCompile(`([?&]width=)\d+`)
+	coverHeightRe = regexp.MustCompile(`([?&]height=)\d+`)
+)
+
+// sizedURL fills a {width}/{height} pattern.
+func sizedURL(pattern string, width, height int) string {
+	return strings.NewReplacer("{width}", strconv.Itoa(width), "{height}", strconv.Itoa(height)).Replace(pattern)
+}
+
+// coverFromPattern builds a Cover from a /PublicAlbumCover/<id>?width={width}&height={height}&photoId=<n> pattern.
+func coverFromPattern(pattern string, width, height int) *Cover {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" || !strings.Contains(pattern, "{width}") {
+		return nil
+	}
+	if width <= 0 || height <= 0 {
+		width, height = coverDefaultWidth, coverDefaultHeight
+	}
+	c := &Cover{Pattern: pattern, Width: width, Height: height, URL: sizedURL(pattern, width, height)}
+	if u, err := url.Parse(pattern); err == nil {
+		if id := u.Query().Get("photoId"); id != "" && id != "0" && numericRe.MatchString(id) {
+			c.PhotoID = id
+		}
+	}
+	return c
+}
+
+// coverFromTile reads the cover of a profile tile (li.list-alb). join resolves relative URLs.
+func coverFromTile(s *goquery.Selection, join func(string) string) *Cover {
+	sels := currentSelectors()
+	img := s.Find(sels.Profile.TileCover).First()
+	pattern, _ := firstValue(sels.Profile.TileCoverPattern, img)
+	if strings.HasPrefix(pattern, "/") {
+		pattern = join(pattern)
+	}
+	w, _ := strconv.Atoi(s.AttrOr("data-width", img.AttrOr("data-width", "")))
+	h, _ := strconv.Atoi(s.AttrOr("data-height", img.AttrOr("data-height", "")))
+	return coverFromPattern(pattern, w, h)
+}
+
+// coverFromAlbumPage turns the album page's og:image (a sized PublicAlbumCover URL) into a Cover.
+func coverFromAlbumPage(doc *goquery.Document) *Cover {
+	og := currentSelectors().Album.Cover.value(doc.Selection)
+	if !strings.Contains(og, "/PublicAlbumCover/") {
+		return nil
+	}
+	pattern := coverWidthRe.ReplaceAllString(og, "${1}{width}")
+	pattern = coverHeightRe.ReplaceAllString(pattern, "${1}{height}")
+	return coverFromPattern(pattern, 0, 0)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCoverFromPattern(t *testing.T) {
+	tests := []struct {
+		pattern       string
+		width, height int
+		want          *Cover
+	}{
+		{"https://eu.zonerama.com/PublicAlbumCover/1?width={width}&height={height}&photoId=565775564", 300, 200,
+			&Cover{PhotoID: "565775564", Pattern: "https://eu.zonerama.com/PublicAlbumCover/1?width={width}&height={height}&photoId=565775564",
+				URL: "https://eu.zonerama.com/PublicAlbumCover/1?width=300&height=200&photoId=565775564", Width: 300, Height: 200}},
+		// photoId=0 means Zonerama picked the cover; sizes default to the tile layout
+		{" https://eu.zonerama.com/PublicAlbumCover/1?width={width}&height={height}&photoId=0 ", 0, 0,
+			&Cover{Pattern: "https://eu.zonerama.com/PublicAlbumCover/1?width={width}&height={height}&photoId=0",
+				URL: "https://eu.zonerama.com/PublicAlbumCover/1?width=560&height=428&photoId=0", Width: 560, Height: 428}},
+		{"https://eu.zonerama.com/PublicAlbumCover/1?width=560&height=428", 0, 0, nil},
+		{"", 0, 0, nil},
+	}
+	for _, tt := range tests {
+		got := coverFromPattern(tt.pattern, tt.width, tt.height)
+		if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+			t.Errorf("%q: got %+v, want %+v", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestCoverFromTile(t *testing.T) {
+	sels := currentSelectors()
+	// clean.html is stripped of its images, snippet1.html is not
+	tiles, _ := firstMatch(sels.Profile.Tiles, loadFixture(t, "snippet1.html").Selection)
+	tiles = tiles.Filter("[data-album-id]")
+	if tiles.Length() != 19 {
+		t.Fatalf("got %d album tiles, want 19", tiles.Length())
+	}
+	join := func(u string) string { return "https://eu.zonerama.com" + u }
+	for i := range tiles.Length() {
+		s := tiles.Eq(i)
+		c := coverFromTile(s, join)
+		id := s.AttrOr("data-album-id", "")
+		if c == nil {
+			t.Errorf("tile %s: no cover", id)
+			continue
+		}
+		if !strings.HasPrefix(c.Pattern, "https://eu.zonerama.com/PublicAlbumCover/"+id+"?") || c.Width != 560 || c.Height != 428 {
+			t.Errorf("tile %s: got %+v", id, c)
+		}
+		if c.URL != sizedURL(c.Pattern, 560, 428) {
+			t.Errorf("tile %s: URL %s does not fill the pattern", id, c.URL)
+		}
+	}
+}
+
+func TestCoverFromAlbumPage(t *testing.T) {
+	c := coverFromAlbumPage(loadFixture(t, "albums.html"))
+	if c == nil {
+		t.Fatal("albums.html: no cover")
+	}
+	const pattern = "https://eu.zonerama.com/PublicAlbumCover/13903610?width={width}&height={height}&topStrip=False&mode=0&photoId=0&plusNumber=False&v=638941596125600000"
+	if c.Pattern != pattern || c.PhotoID != "" || c.Width != 560 || c.Height != 428 {
+		t.Errorf("got %+v", c)
+	}
+	// A profile page's og:image is not an album cover
+	if c := coverFromAlbumPage(loadFixture(t, "main.html")); c != nil {
+		t.Errorf("main.html: got %+v", c)
+	}
+}
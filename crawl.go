@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/geziyor/geziyor"
+	"github.com/geziyor/geziyor/client"
+	"github.com/geziyor/geziyor/middleware"
+)
+
+// crawl is what every page fetch of one API call shares: its politeness, and
+// whether pages are rendered and saved for debugging.
+type crawl struct {
+	polite   *crawlPoliteness
+	rendered bool
+	debug    bool
+}
+
+func newCrawl(polite *crawlPoliteness, params *scrapeParams) *crawl {
+	return &crawl{polite: polite, rendered: params.Rendered, debug: params.Debug}
+}
+
+// get queues a page, rendered or not, and retries it when Zonerama throttles.
+func (c *crawl) get(g *geziyor.Geziyor, u string, cb func(*geziyor.Geziyor, *client.Response)) {
+	getCrawl(g, u, c.rendered, c.polite.retrying(cb))
+}
+
+var debugNameRe = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// save stores the page under debuging/ when the call asked for debug=true,
+// named after the stage and the page URL.
+func (c *crawl) save(stage string, cr *client.Response) {
+	if !c.debug || cr == nil || cr.Request == nil {
+		return
+	}
+	u := cr.Request.URL.String()
+	h := sha1.Sum([]byte(u))
+	saveDebugFile(fmt.Sprintf("%s_%s_%s.html", stage, hex.EncodeToString(h[:6]), debugNameRe.ReplaceAllString(u, "_")), cr.Body)
+}
+
+// options are the crawler settings every crawl starts from: fetch start and
+// hand it, and anything queued without a callback, to parse.
+func (c *crawl) options(start string, parse func(*geziyor.Geziyor, *client.Response)) *geziyor.Options {
+	return &geziyor.Options{
+		StartRequestsFunc: func(g *geziyor.Geziyor) {
+			c.get(g, start, parse)
+		},
+		ParseFunc:          parse,
+		RetryTimes:         2,
+		RetryHTTPCodes:     politeRetryHTTPCodes,
+		RequestMiddlewares: []middleware.RequestProcessor{c.polite},
+		Timeout:            30 * time.Second,
+		LogDisabled:        true,
+		RobotsTxtDisabled:  !c.polite.cfg.Robots,
+		// Throttled requests are re-issued by polite.retrying
+		URLRevisitEnabled: true,
+	}
+}
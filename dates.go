@@ -0,0 +1,240 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Month names in the locales Zonerama serves (cs, sk, en, de, pl), nominative and genitive.
+var monthNames = map[string]time.Month{
+	// cs
+	"leden": 1, "ledna": 1, "únor": 2, "února": 2, "březen": 3, "března": 3, "duben": 4, "dubna": 4,
+	"květen": 5, "května": 5, "červen": 6, "června": 6, "červenec": 7, "července": 7, "srpen": 8, "srpna": 8,
+	"září": 9, "říjen": 10, "října": 10, "listopad": 11, "listopadu": 11, "prosinec": 12, "prosince": 12,
+	// sk
+	"január": 1, "januára": 1, "február": 2, "februára": 2, "marec": 3, "marca": 3, "apríl": 4, "apríla": 4,
+	"máj": 5, "mája": 5, "jún": 6, "júna": 6, "júl": 7, "júla": 7, "augusta": 8,
+	"septembra": 9, "október": 10, "októbra": 10, "novembra": 11, "decembra": 12,
+	// en
+	"january": 1, "jan": 1, "february": 2, "feb": 2, "march": 3, "mar": 3, "april": 4, "apr": 4,
+	"may": 5, "june": 6, "jun": 6, "july": 7, "jul": 7, "august": 8, "aug": 8, "september": 9, "sep": 9, "sept": 9,
+	"october": 10, "oct": 10, "november": 11, "nov": 11, "december": 12, "dec": 12,
+	// de
+	"januar": 1, "jänner": 1, "februar": 2, "märz": 3, "mai": 5, "juni": 6, "juli": 7,
+	"oktober": 10, "dezember": 12,
+	// pl
+	"styczeń": 1, "stycznia": 1, "luty": 2, "lutego": 2, "marzec": 3, "kwiecień": 4, "kwietnia": 4,
+	"maj": 5, "maja": 5, "czerwiec": 6, "czerwca": 6, "lipiec": 7, "lipca": 7, "sierpień": 8, "sierpnia": 8,
+	"wrzesień": 9, "września": 9, "październik": 10, "października": 10, "listopada": 11, "grudzień": 12, "grudnia": 12,
+}
+
+// Words for "today" and "yesterday" in the same locales.
+var relativeDays = map[string]int{
+	"today": 0, "dnes": 0, "heute": 0, "dziś": 0, "dzisiaj": 0,
+	"yesterday": 1, "včera": 1, "gestern": 1, "wczoraj": 1,
+}
+
+var (
+	isoDateRe = regexp.MustCompile(`\d{4}-\d{1,2}-\d{1,2}`)
+	// "3 days ago", "před 3 dny", "pred 3 dňami", "vor 3 Tagen", "3 dni temu"
+	agoRe       = regexp.MustCompile(`(\d+)\s*(\pL+)`)
+	agoMarkerRe = regexp.MustCompile(`\b(ago|temu|vor)\b|před|pred`)
+	rangeSepRe  = regexp.MustCompile(`\s*(?:–|—|\s-\s|-)\s*`)
+	dateTokenRe = regexp.MustCompile(`\d+|\pL+`)
+)
+
+// pageDateContext reads the page locale and UTC offset from znrm:lang and
+// znrm:timezoneoffset (minutes east of UTC). It falls back to cs and UTC.
+func pageDateContext(doc *goquery.Document) (lang string, loc *time.Location) {
+	lang, loc = "cs", time.UTC
+	if doc == nil {
+		return lang, loc
+	}
+	if v := currentSelectors().Page.Lang.value(doc.Selection); v != "" {
+		lang = strings.ToLower(v)
+	}
+	if v := currentSelectors().Page.TimezoneOffset.value(doc.Selection); v != "" {
+		if min, err := strconv.Atoi(v); err == nil {
+			loc = time.FixedZone("", min*60)
+		}
+	}
+	return lang, loc
+}
+
+// parseZoneramaDate parses the dates Zonerama shows on tiles and album headers:
+// "20. 9. 2025", "20. září 2025", "September 20, 2025", "9/20/2025", "2025-09-20",
+// relative dates ("včera", "3 days ago") and ranges ("20. - 22. 9. 2025").
+// end is zero unless s is a range. Dates are midnight in loc.
+func parseZoneramaDate(s, lang string, loc *time.Location, now time.Time) (start, end time.Time, ok bool) {
+	s = strings.ToLower(strings.Join(strings.Fields(s), " "))
+	if s == "" {
+		return time.Time{}, time.Time{}, false
+	}
+	now = now.In(loc)
+	if t, ok := parseRelativeDate(s, loc, now); ok {
+		return t, time.Time{}, true
+	}
+	if iso := isoDateRe.FindAllString(s, 2); len(iso) > 0 {
+		start, ok = parseDateParts(dateTokenRe.FindAllString(iso[0], -1), true, false, loc, now)
+		if ok && len(iso) == 2 {
+			end, _ = parseDateParts(dateTokenRe.FindAllString(iso[1], -1), true, false, loc, now)
+		}
+		return start, end, ok
+	}
+	parts := rangeSepRe.Split(s, 2)
+	last := parts[len(parts)-1]
+	endTokens := dateTokenRe.FindAllString(last, -1)
+	// Only the English locale writes numeric dates month first (9/20/2025)
+	monthFirst := lang == "en" && strings.Contains(last, "/")
+	t, ok := parseDateParts(endTokens, false, monthFirst, loc, now)
+	if !ok {
+		return time.Time{}, time.Time{}, false
+	}
+	if len(parts) == 1 {
+		return t, time.Time{}, true
+	}
+	// The start of a range may omit the month and year it shares with the end
+	start, ok = completeRangeStart(dateTokenRe.FindAllString(parts[0], -1), t, monthFirst, loc, now)
+	if !ok || start.After(t) {
+		return t, time.Time{}, true
+	}
+	return start, t, true
+}
+
+// parseDateParts turns day/month/year tokens into a date. Years may be missing,
+// in which case the current year is assumed.
+func parseDateParts(tokens []string, isoOrder, monthFirst bool, loc *time.Location, now time.Time) (time.Time, bool) {
+	var nums []int
+	var month time.Month
+	for _, tok := range tokens {
+		if n, err := strconv.Atoi(tok); err == nil {
+			nums = append(nums, n)
+		} else if m, ok := monthNames[tok]; ok {
+			month = m
+		}
+	}
+	day, year := 0, now.Year()
+	switch {
+	case isoOrder && len(nums) == 3:
+		year, month, day = nums[0], time.Month(nums[1]), nums[2]
+	case month != 0:
+		for _, n := range nums {
+			if n > 31 {
+				year = n
+			} else if day == 0 {
+				day = n
+			}
+		}
+	case len(nums) >= 2:
+		day, month = nums[0], time.Month(nums[1])
+		if monthFirst {
+			day, month = nums[1], time.Month(nums[0])
+		}
+		if len(nums) >= 3 {
+			year = nums[2]
+		}
+	default:
+		return time.Time{}, false
+	}
+	if year < 100 {
+		year += 2000
+	}
+	if month < 1 || month > 12 || day < 1 || day > 31 {
+		return time.Time{}, false
+	}
+	t := time.Date(year, month, day, 0, 0, 0, 0, loc)
+	if t.Day() != day { // e.g. 31. 2.
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+func completeRangeStart(tokens []string, end time.Time, monthFirst bool, loc *time.Location, now time.Time) (time.Time, bool) {
+	var nums []int
+	hasMonth := false
+	for _, tok := range tokens {
+		if n, err := strconv.Atoi(tok); err == nil {
+			nums = append(nums, n)
+		} else if _, ok := monthNames[tok]; ok {
+			hasMonth = true
+		}
+	}
+	switch {
+	case len(nums) == 1 && !hasMonth:
+		tokens = append(tokens, strconv.Itoa(int(end.Month())))
+		if monthFirst {
+			tokens = []string{strconv.Itoa(int(end.Month())), tokens[0]}
+		}
+		fallthrough
+	case len(nums) <= 2:
+		tokens = append(tokens, strconv.Itoa(end.Year()))
+	}
+	return parseDateParts(tokens, false, monthFirst, loc, now)
+}
+
+// parseRelativeDate handles "today", "yesterday" and "N <unit> ago" in the served locales.
+func parseRelativeDate(s string, loc *time.Location, now time.Time) (time.Time, bool) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	for _, w := range strings.Fields(s) {
+		if d, ok := relativeDays[w]; ok {
+			return today.AddDate(0, 0, -d), true
+		}
+	}
+	if !agoMarkerRe.MatchString(s) {
+		return time.Time{}, false
+	}
+	m := agoRe.FindStringSubmatch(s)
+	if m == nil {
+		return time.Time{}, false
+	}
+	n, _ := strconv.Atoi(m[1])
+	unit := m[2]
+	has := func(prefixes ...string) bool {
+		for _, p := range prefixes {
+			if strings.HasPrefix(unit, p) {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case has("min", "hour", "hod", "stund", "godz", "sek", "sec"):
+		return today, true
+	case has("day", "dn", "den", "tag", "dni", "dzie"):
+		return today.AddDate(0, 0, -n), true
+	case has("week", "týd", "tyž", "tydz", "woch"):
+		return today.AddDate(0, 0, -7*n), true
+	case has("month", "měs", "mes", "monat", "miesi"):
+		return today.AddDate(0, -n, 0), true
+	case has("year", "rok", "let", "jahr", "lat"):
+		return today.AddDate(-n, 0, 0), true
+	}
+	return time.Time{}, false
+}
+
+// setAlbumDate fills the typed date fields from the raw Album.Date.
+func setAlbumDate(a *Album, lang string, loc *time.Location) {
+	start, end, ok := parseZoneramaDate(a.Date, lang, loc, time.Now())
+	if !ok {
+		a.DateISO, a.DateEndISO = "", ""
+		return
+	}
+	a.DateISO = start.Format(time.RFC3339)
+	a.DateEndISO = ""
+	if !end.IsZero() {
+		a.DateEndISO = end.Format(time.RFC3339)
+	}
+}
+
+// albumTime is the sort key for an album: its typed start date, if any.
+func albumTime(a Album) (time.Time, bool) {
+	if a.DateISO == "" {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339, a.DateISO)
+	return t, err == nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseZoneramaDate(t *testing.T) {
+	loc := time.FixedZone("", 2*3600)
+	now := time.Date(2025, 10, 18, 15, 0, 0, 0, loc)
+	day := func(y int, m time.Month, d int) time.Time { return time.Date(y, m, d, 0, 0, 0, 0, loc) }
+	tests := []struct {
+		in, lang   string
+		start, end time.Time
+		ok         bool
+	}{
+		{"20. 9. 2025", "cs", day(2025, 9, 20), time.Time{}, true},
+		{"  20.9.2025 ", "cs", day(2025, 9, 20), time.Time{}, true},
+		{"20. září 2025", "cs", day(2025, 9, 20), time.Time{}, true},
+		{"20. septembra 2025", "sk", day(2025, 9, 20), time.Time{}, true},
+		{"20. September 2025", "de", day(2025, 9, 20), time.Time{}, true},
+		{"September 20, 2025", "en", day(2025, 9, 20), time.Time{}, true},
+		{"9/20/2025", "en", day(2025, 9, 20), time.Time{}, true},
+		{"20/9/2025", "cs", day(2025, 9, 20), time.Time{}, true},
+		{"2025-09-20", "cs", day(2025, 9, 20), time.Time{}, true},
+		{"20. 9.", "cs", day(2025, 9, 20), time.Time{}, true},
+		{"20. - 22. 9. 2025", "cs", day(2025, 9, 20), day(2025, 9, 22), true},
+		{"30. 8. - 3. 9. 2025", "cs", day(2025, 8, 30), day(2025, 9, 3), true},
+		{"2025-08-30 - 2025-09-03", "cs", day(2025, 8, 30), day(2025, 9, 3), true},
+		{"dnes", "cs", day(2025, 10, 18), time.Time{}, true},
+		{"včera", "cs", day(2025, 10, 17), time.Time{}, true},
+		{"před 3 dny", "cs", day(2025, 10, 15), time.Time{}, true},
+		{"3 days ago", "en", day(2025, 10, 15), time.Time{}, true},
+		{"vor 2 Wochen", "de", day(2025, 10, 4), time.Time{}, true},
+		{"1 month ago", "en", day(2025, 9, 18), time.Time{}, true},
+		{"31. 2. 2025", "cs", time.Time{}, time.Time{}, false},
+		{"13/20/2025", "en", time.Time{}, time.Time{}, false},
+		{"bez data", "cs", time.Time{}, time.Time{}, false},
+		{"", "cs", time.Time{}, time.Time{}, false},
+	}
+	for _, tt := range tests {
+		start, end, ok := parseZoneramaDate(tt.in, tt.lang, loc, now)
+		if ok != tt.ok || !start.Equal(tt.start) || !end.Equal(tt.end) {
+			t.Errorf("%q (%s): got %v %v %v, want %v %v %v", tt.in, tt.lang, start, end, ok, tt.start, tt.end, tt.ok)
+		}
+	}
+}
+
+func TestPageDateContext(t *testing.T) {
+	tests := []struct {
+		fixture string
+		lang    string
+		offset  int
+	}{
+		{"albums.html", "cs", 7200},
+		{"main.html", "cs", 7200},
+		{"clean.html", "cs", 0}, // no znrm meta tags: the defaults
+	}
+	for _, tt := range tests {
+		lang, loc := pageDateContext(loadFixture(t, tt.fixture))
+		if _, offset := time.Date(2025, 9, 20, 0, 0, 0, 0, loc).Zone(); lang != tt.lang || offset != tt.offset {
+			t.Errorf("%s: got %s %+d, want %s %+d", tt.fixture, lang, offset, tt.lang, tt.offset)
+		}
+	}
+}
+
+// Every tile date of the saved profile pages parses in the page's zone.
+func TestAlbumDatesFixtures(t *testing.T) {
+	_, loc := pageDateContext(loadFixture(t, "main.html"))
+	for _, fixture := range []string{"clean.html", "snippet1.html"} {
+		for _, a := range fixtureAlbums(t, fixture) {
+			setAlbumDate(&a, "cs", loc)
+			got, ok := albumTime(a)
+			if !ok || a.DateEndISO != "" {
+				t.Errorf("%s: %s: %q did not parse to a single date", fixture, a.ID, a.Date)
+				continue
+			}
+			if want := got.Format("2. 1. 2006"); want != a.Date || got.Year() != 2025 {
+				t.Errorf("%s: %s: %q parsed as %s", fixture, a.ID, a.Date, a.DateISO)
+			}
+			if _, offset := got.Zone(); offset != 7200 {
+				t.Errorf("%s: %s: offset %d, want 7200", fixture, a.ID, offset)
+			}
+		}
+	}
+	doc := loadFixture(t, "albums.html")
+	a := Album{Date: currentSelectors().Album.Date.value(doc.Selection)}
+	lang, loc := pageDateContext(doc)
+	setAlbumDate(&a, lang, loc)
+	if a.DateISO != "2025-09-20T00:00:00+02:00" {
+		t.Errorf("albums.html: header date %q parsed as %q", a.Date, a.DateISO)
+	}
+}
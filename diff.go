@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Changeset is what changed between two scrapes of the same link.
+type Changeset struct {
+	Link  string `json:"link,omitempty"`
+	Since string `json:"since,omitempty"` // when the previous snapshot was last seen
+	// Removals are only reported when the fresh scrape covered everything:
+	// no album_limit, filters or paging for albums, no photo_limit for photos.
+	CompleteAlbums bool           `json:"complete_albums"`
+	CompletePhotos bool           `json:"complete_photos"`
+	Summary        ChangeSummary  `json:"summary"`
+	AddedAlbums    []AlbumSummary `json:"added_albums"`
+	RemovedAlbums  []AlbumSummary `json:"removed_albums"`
+	ChangedAlbums  []AlbumChange  `json:"changed_albums"`
+}
+
+type ChangeSummary struct {
+	AddedAlbums   int `json:"added_albums"`
+	RemovedAlbums int `json:"removed_albums"`
+	ChangedAlbums int `json:"changed_albums"`
+	AddedPhotos   int `json:"added_photos"`
+	RemovedPhotos int `json:"removed_photos"`
+}
+
+type AlbumSummary struct {
+	ID        string `json:"id"`
+	Title     string `json:"title"`
+	URL       string `json:"url"`
+	Date      string `json:"date,omitempty"`
+	PhotosCnt int    `json:"photos_count,omitempty"`
+}
+
+// FieldChange is one album field's old and new value.
+type FieldChange struct {
+	Old any `json:"old"`
+	New any `json:"new"`
+}
+
+type AlbumChange struct {
+	ID            string                 `json:"id"`
+	Title         string                 `json:"title"`
+	URL           string                 `json:"url"`
+	Fields        map[string]FieldChange `json:"fields,omitempty"` // title, date, date_end, photos_count, views_count, likes_count, cover
+	AddedPhotos   []string               `json:"added_photos,omitempty"`
+	RemovedPhotos []string               `json:"removed_photos,omitempty"`
+}
+
+func summarize(a Album) AlbumSummary {
+	return AlbumSummary{ID: a.ID, Title: a.Title, URL: a.URL, Date: a.Date, PhotosCnt: a.PhotosCnt}
+}
+
+// albumKey identifies an album across scrapes; the URL stands in when the ID is missing.
+func albumKey(a Album) string {
+	if a.ID != "" {
+		return a.ID
+	}
+	return a.URL
+}
+
+// diffResponses compares two scrapes. completeAlbums/completePhotos say whether
+// the new scrape is exhaustive, i.e. whether absence means removal.
+func diffResponses(old, cur *Response, completeAlbums, completePhotos bool) *Changeset {
+	cs := &Changeset{
+		CompleteAlbums: completeAlbums,
+		CompletePhotos: completePhotos,
+		AddedAlbums:    []AlbumSummary{},
+		RemovedAlbums:  []AlbumSummary{},
+		ChangedAlbums:  []AlbumChange{},
+	}
+	before := make(map[string]Album, len(old.Albums))
+	for _, a := range old.Albums {
+		before[albumKey(a)] = a
+	}
+	seen := make(map[string]bool, len(cur.Albums))
+	for _, a := range cur.Albums {
+		key := albumKey(a)
+		seen[key] = true
+		prev, ok := before[key]
+		if !ok {
+			cs.AddedAlbums = append(cs.AddedAlbums, summarize(a))
+			continue
+		}
+		if ch, changed := diffAlbum(prev, a, completePhotos); changed {
+			cs.ChangedAlbums = append(cs.ChangedAlbums, ch)
+			cs.Summary.AddedPhotos += len(ch.AddedPhotos)
+			cs.Summary.RemovedPhotos += len(ch.RemovedPhotos)
+		}
+	}
+	if completeAlbums {
+		for _, a := range old.Albums {
+			if !seen[albumKey(a)] {
+				cs.RemovedAlbums = append(cs.RemovedAlbums, summarize(a))
+			}
+		}
+	}
+	cs.Summary.AddedAlbums = len(cs.AddedAlbums)
+	cs.Summary.RemovedAlbums = len(cs.RemovedAlbums)
+	cs.Summary.ChangedAlbums = len(cs.ChangedAlbums)
+	return cs
+}
+
+// diffAlbum compares fields the new scrape actually knows (zero values are
+// treated as "not scraped") and photo IDs when photos were fetched.
+func diffAlbum(old, cur Album, completePhotos bool) (AlbumChange, bool) {
+	ch := AlbumChange{ID: cur.ID, Title: cur.Title, URL: cur.URL, Fields: map[string]FieldChange{}}
+	str := func(name, o, n string) {
+		if n != "" && o != n {
+			ch.Fields[name] = FieldChange{Old: o, New: n}
+		}
+	}
+	num := func(name string, o, n int) {
+		if n != 0 && o != n {
+			ch.Fields[name] = FieldChange{Old: o, New: n}
+		}
+	}
+	str("title", old.Title, cur.Title)
+	// Raw dates are localized and often relative ("včera"), so the typed ones
+	// are compared; a readable date also says whether the album spans days
+	if cur.DateISO != "" {
+		str("date", old.DateISO, cur.DateISO)
+		if old.DateEndISO != cur.DateEndISO {
+			ch.Fields["date_end"] = FieldChange{Old: old.DateEndISO, New: cur.DateEndISO}
+		}
+	}
+	num("photos_count", old.PhotosCnt, cur.PhotosCnt)
+	num("views_count", old.ViewsCnt, cur.ViewsCnt)
+	if old.LikesCount != nil && cur.LikesCount != nil && *old.LikesCount != *cur.LikesCount {
+		ch.Fields["likes_count"] = FieldChange{Old: *old.LikesCount, New: *cur.LikesCount}
+	}
+	if old.Cover != nil && cur.Cover != nil && old.Cover.URL != cur.Cover.URL {
+		ch.Fields["cover"] = FieldChange{Old: old.Cover.URL, New: cur.Cover.URL}
+	}
+	if len(cur.Photos) > 0 {
+		had := make(map[string]bool, len(old.Photos))
+		for _, p := range old.Photos {
+			had[p.ID] = true
+		}
+		has := make(map[string]bool, len(cur.Photos))
+		for _, p := range cur.Photos {
+			has[p.ID] = true
+			if !had[p.ID] {
+				ch.AddedPhotos = append(ch.AddedPhotos, p.ID)
+			}
+		}
+		if completePhotos {
+			for _, p := range old.Photos {
+				if !has[p.ID] {
+					ch.RemovedPhotos = append(ch.RemovedPhotos, p.ID)
+				}
+			}
+		}
+	}
+	if len(ch.Fields) == 0 {
+		ch.Fields = nil
+	}
+	return ch, ch.Fields != nil || len(ch.AddedPhotos) > 0 || len(ch.RemovedPhotos) > 0
+}
+
+// scrapeIsComplete tells from the request and response whether the scrape saw
+// every album and every photo, so absence can be read as removal.
+func scrapeIsComplete(canon *CanonicalLink, q url.Values, resp *Response) (albums, photos bool) {
+	get := func(k string) string { return strings.TrimSpace(q.Get(k)) }
+	// An album link scrapes exactly one album, whatever album_limit says
+	albums = resp.NextCursor == "" && (get("album_limit") == "0" || canon.AlbumID != "")
+	for _, k := range []string{"since", "until", "title", "min_photos", "exclude", "offset", "cursor", "photo_offset"} {
+		if get(k) != "" && get(k) != "0" {
+			albums = false
+		}
+	}
+	if m := get("media"); m != "" && m != mediaAll {
+		albums = false
+	}
+	withPhotos := true
+	if b, err := strconv.ParseBool(get("include_photos")); err == nil {
+		withPhotos = b
+	}
+	photos = get("photo_limit") == "0" && get("photo_offset") == "" && get("cursor") == "" &&
+		withPhotos && (get("media") == "" || get("media") == mediaAll)
+	return albums, photos
+}
+
+// zoneramaDiffHandler scrapes link like /zonerama, compares it with the catalog's
+// previous snapshot and returns the changeset. The fresh scrape is then stored.
+func zoneramaDiffHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if catalog == nil {
+		writeJSONError(w, http.StatusNotFound, "diff needs the catalog; set ZONERAMA_CATALOG to a database path")
+		return
+	}
+	link := r.URL.Query().Get("link")
+	if link == "" {
+		writeJSONError(w, http.StatusBadRequest, "missing link param: /zonerama/diff?link=https://eu.zonerama.com/<Account>")
+		return
+	}
+	canon, err := resolveZoneramaLink(link)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	prev, since, err := catalog.snapshot(canon)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "reading previous snapshot: "+err.Error())
+		return
+	}
+
+	// Run the regular scrape; it also stores the fresh result in the catalog
+	q := r.URL.Query()
+	cur, err := scrapeProfile(r.Context(), q, true)
+	if err != nil {
+		writeScrapeError(w, err)
+		return
+	}
+	completeAlbums, completePhotos := scrapeIsComplete(canon, q, cur)
+	cs := diffResponses(prev, cur, completeAlbums, completePhotos)
+	cs.Link, cs.Since = canon.URL, since
+	writeJSON(w, r, cs)
+}
+
+// runDiffCLI implements `zonerama diff [-complete-albums] [-complete-photos]
+// old.json new.json`: both files are /zonerama responses; the changeset is
+// printed as JSON. A saved response does not record its parameters, so
+// removals are only reported for what the flags say the new scrape covered,
+// and never when it has a next_cursor.
+func runDiffCLI(args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	completeAlbums := fs.Bool("complete-albums", false, "the new scrape has every album (album_limit=0, no filters or paging)")
+	completePhotos := fs.Bool("complete-photos", false, "the new scrape has every photo of its albums (photo_limit=0, no paging)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: zonerama diff [-complete-albums] [-complete-photos] <old.json> <new.json>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+	var scrapes [2]Response
+	for i, path := range fs.Args() {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if err := json.Unmarshal(b, &scrapes[i]); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			return 1
+		}
+	}
+	// A next page means both lists were cut short
+	paged := scrapes[1].NextCursor != ""
+	cs := diffResponses(&scrapes[0], &scrapes[1], *completeAlbums && !paged, *completePhotos && !paged)
+	cs.Link = scrapes[1].InputLink
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(cs); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+// summaryIDs lists the album IDs of a changeset section, for comparison.
+func summaryIDs(list []AlbumSummary) []string {
+	ids := []string{}
+	for _, a := range list {
+		ids = append(ids, a.ID)
+	}
+	return ids
+}
+
+func TestDiffResponses(t *testing.T) {
+	// clean.html is an earlier save of the profile in snippet1.html, which has
+	// four older albums further down the page. Views moved between the two
+	// saves, so clean holds the snippet1.html tiles of the albums it lists.
+	full := fixtureAlbums(t, "snippet1.html")
+	var clean []Album
+	for _, a := range fixtureAlbums(t, "clean.html") {
+		if i := slices.IndexFunc(full, func(f Album) bool { return f.ID == a.ID }); i >= 0 {
+			clean = append(clean, full[i])
+		}
+	}
+	older := []string{"13726013", "13774004", "13774084", "13796305"}
+
+	// 13903610 is the album of the snippet3.html gallery
+	photos := fixturePhotos(t, "snippet3.html")
+	withPhotos := func(albums []Album, list []Photo) []Album {
+		out := slices.Clone(albums)
+		for i := range out {
+			if out[i].ID == "13903610" {
+				out[i].Photos = list
+			}
+		}
+		return out
+	}
+	renamed := slices.Clone(clean)
+	renamed[0].Title = "Kategorie U14 FK Krnov 2:6 Valašské Meziříčí (opraveno)"
+	renamed[1].Date, renamed[1].DateISO = "31. 8. 2025", "2025-08-31T00:00:00+02:00"
+
+	tests := []struct {
+		name                        string
+		old, cur                    []Album
+		completeAlbums, completeAll bool
+		added, removed, changed     []string
+		addedPhotos, removedPhotos  int
+	}{
+		{name: "unchanged", old: clean, cur: clean, completeAlbums: true, completeAll: true,
+			added: []string{}, removed: []string{}, changed: []string{}},
+		{name: "older albums appear", old: clean, cur: full, completeAlbums: true,
+			added: older, removed: []string{}, changed: []string{}},
+		{name: "complete scrape misses albums", old: full, cur: clean, completeAlbums: true,
+			added: []string{}, removed: older, changed: []string{}},
+		// A limited scrape says nothing about albums it did not reach
+		{name: "partial scrape misses albums", old: full, cur: clean,
+			added: []string{}, removed: []string{}, changed: []string{}},
+		{name: "fields changed", old: clean, cur: renamed,
+			added: []string{}, removed: []string{}, changed: []string{"13796374", "13796675"}},
+		{name: "photos added", old: withPhotos(clean, photos[2:]), cur: withPhotos(clean, photos), completeAll: true,
+			added: []string{}, removed: []string{}, changed: []string{"13903610"}, addedPhotos: 2},
+		{name: "photos removed", old: withPhotos(clean, photos), cur: withPhotos(clean, photos[:len(photos)-3]), completeAll: true,
+			added: []string{}, removed: []string{}, changed: []string{"13903610"}, removedPhotos: 3},
+		{name: "photo_limit cuts the gallery", old: withPhotos(clean, photos), cur: withPhotos(clean, photos[:10]),
+			added: []string{}, removed: []string{}, changed: []string{}},
+		// Without photos in the new scrape, photos are not compared at all
+		{name: "photos not scraped", old: withPhotos(clean, photos), cur: clean, completeAll: true,
+			added: []string{}, removed: []string{}, changed: []string{}},
+	}
+	for _, tt := range tests {
+		cs := diffResponses(&Response{Albums: tt.old}, &Response{Albums: tt.cur}, tt.completeAlbums, tt.completeAll)
+		changed := []string{}
+		for _, ch := range cs.ChangedAlbums {
+			changed = append(changed, ch.ID)
+		}
+		got := []any{summaryIDs(cs.AddedAlbums), summaryIDs(cs.RemovedAlbums), changed, cs.Summary.AddedPhotos, cs.Summary.RemovedPhotos}
+		want := []any{tt.added, tt.removed, tt.changed, tt.addedPhotos, tt.removedPhotos}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got added/removed/changed/photos %v, want %v", tt.name, got, want)
+		}
+		if cs.Summary.AddedAlbums != len(tt.added) || cs.Summary.RemovedAlbums != len(tt.removed) || cs.Summary.ChangedAlbums != len(tt.changed) {
+			t.Errorf("%s: summary %+v does not match the lists", tt.name, cs.Summary)
+		}
+	}
+}
+
+func TestDiffAlbumFields(t *testing.T) {
+	likes := func(n int) *int { return &n }
+	base := fixtureAlbums(t, "clean.html")[0]
+	base.PhotosCnt, base.ViewsCnt, base.LikesCount = 33, 33, likes(1)
+	base.DateISO = "2025-09-01T00:00:00+02:00"
+	base.Cover = &Cover{URL: "https://eu.zonerama.com/PublicAlbumCover/13796374/560x428"}
+
+	tests := []struct {
+		name   string
+		edit   func(a *Album)
+		fields map[string]FieldChange
+	}{
+		{"same", func(a *Album) {}, nil},
+		{"views and likes", func(a *Album) { a.ViewsCnt, a.LikesCount = 40, likes(3) },
+			map[string]FieldChange{"views_count": {Old: 33, New: 40}, "likes_count": {Old: 1, New: 3}}},
+		{"cover", func(a *Album) { a.Cover = &Cover{URL: "https://eu.zonerama.com/PublicAlbumCover/13796374/750x500"} },
+			map[string]FieldChange{"cover": {Old: base.Cover.URL, New: "https://eu.zonerama.com/PublicAlbumCover/13796374/750x500"}}},
+		// The same day written differently is no change
+		{"date wording", func(a *Album) { a.Date = "včera" }, nil},
+		{"date", func(a *Album) { a.DateISO = "2025-08-31T00:00:00+02:00" },
+			map[string]FieldChange{"date": {Old: base.DateISO, New: "2025-08-31T00:00:00+02:00"}}},
+		{"date range", func(a *Album) { a.DateEndISO = "2025-09-02T00:00:00+02:00" },
+			map[string]FieldChange{"date_end": {Old: "", New: "2025-09-02T00:00:00+02:00"}}},
+		// Zero values mean the field was not scraped this time
+		{"not scraped", func(a *Album) {
+			a.Date, a.DateISO, a.DateEndISO, a.PhotosCnt, a.ViewsCnt, a.LikesCount, a.Cover = "", "", "x", 0, 0, nil, nil
+		}, nil},
+	}
+	for _, tt := range tests {
+		cur := base
+		tt.edit(&cur)
+		ch, changed := diffAlbum(base, cur, true)
+		if changed != (tt.fields != nil) || !reflect.DeepEqual(ch.Fields, tt.fields) {
+			t.Errorf("%s: got %v %v, want %v", tt.name, changed, ch.Fields, tt.fields)
+		}
+	}
+}
+
+func TestScrapeIsComplete(t *testing.T) {
+	profile, _ := resolveZoneramaLink("https://eu.zonerama.com/FKKofolaKrnov")
+	album, _ := resolveZoneramaLink("https://eu.zonerama.com/FKKofolaKrnov/Album/13903610")
+	tests := []struct {
+		canon          *CanonicalLink
+		query          string
+		next           string
+		albums, photos bool
+	}{
+		{profile, "album_limit=0&photo_limit=0", "", true, true},
+		{profile, "album_limit=0", "", true, false},
+		{profile, "album_limit=5&photo_limit=0", "", false, true},
+		{profile, "album_limit=0&photo_limit=0", "next", false, true},
+		{profile, "album_limit=0&photo_limit=0&since=2025-09-01", "", false, true},
+		{profile, "album_limit=0&photo_limit=0&media=video", "", false, false},
+		{profile, "album_limit=0&photo_limit=0&include_photos=false", "", true, false},
+		{profile, "album_limit=0&photo_limit=0&include_photos=0", "", true, false},
+		{profile, "album_limit=0&photo_limit=0&include_photos=TRUE", "", true, true},
+		{album, "photo_limit=0", "", true, true},
+		{album, "photo_limit=0&photo_offset=20", "", false, false},
+	}
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.query)
+		albums, photos := scrapeIsComplete(tt.canon, q, &Response{NextCursor: tt.next})
+		if albums != tt.albums || photos != tt.photos {
+			t.Errorf("%s %s (next %q): got %v %v, want %v %v", tt.canon.Kind, tt.query, tt.next, albums, photos, tt.albums, tt.photos)
+		}
+	}
+}
+
+func TestRunDiffCLI(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, resp Response) string {
+		b, _ := json.Marshal(resp)
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	albums := fixtureAlbums(t, "clean.html")
+	oldFile := write("old.json", Response{Albums: albums})
+	newFile := write("new.json", Response{Albums: albums[1:]})
+	pagedFile := write("paged.json", Response{Albums: albums[1:], NextCursor: "abc"})
+
+	run := func(args ...string) (*Changeset, int) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		stdout := os.Stdout
+		os.Stdout = w
+		code := runDiffCLI(args)
+		os.Stdout = stdout
+		w.Close()
+		var cs Changeset
+		json.NewDecoder(r).Decode(&cs)
+		r.Close()
+		return &cs, code
+	}
+	tests := []struct {
+		args    []string
+		removed int
+	}{
+		// Without the flags nothing missing counts as removed
+		{[]string{oldFile, newFile}, 0},
+		{[]string{"-complete-albums", oldFile, newFile}, 1},
+		{[]string{"-complete-albums", oldFile, pagedFile}, 0},
+	}
+	for _, tt := range tests {
+		cs, code := run(tt.args...)
+		if code != 0 || cs.Summary.RemovedAlbums != tt.removed {
+			t.Errorf("%v: exit %d, %d removed, want %d", tt.args, code, cs.Summary.RemovedAlbums, tt.removed)
+		}
+	}
+	if _, code := run(oldFile); code != 2 {
+		t.Errorf("one file: exit %d, want 2", code)
+	}
+}
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"log"
+	"math"
+	"math/bits"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/image/draw"
+)
+
+// Bounds for dupes=true: the distance is in differing bits out of 64.
+const (
+	defaultDupeDistance = 10
+	maxDupeDistance     = 32
+	dupeThumbEdge       = 256
+)
+
+var (
+	dupeMaxPhotos = envInt("ZONERAMA_DUPES_MAX_PHOTOS", 5000)
+	dupeWorkers   = envInt("ZONERAMA_DUPES_WORKERS", 4)
+	dupeTimeout   = envMillis("ZONERAMA_DUPES_TIMEOUT_MS", 5*time.Minute)
+)
+
+// PhotoHashes are 64-bit perceptual hashes of a photo's thumbnail, as hex.
+type PhotoHashes struct {
+	AHash string `json:"ahash"` // average hash
+	DHash string `json:"dhash"` // difference hash
+	PHash string `json:"phash"` // DCT hash
+}
+
+// DuplicateReport is the dupes=true result: groups of near-identical photos.
+type DuplicateReport struct {
+	MaxDistance int              `json:"max_distance"`
+	Hashed      int              `json:"hashed"`           // photos with hashes
+	Cached      int              `json:"cached,omitempty"` // of those, read from the catalog
+	Failed      int              `json:"failed,omitempty"` // thumbnails that could not be fetched or decoded
+	Skipped     int              `json:"skipped,omitempty"`
+	Groups      []DuplicateGroup `json:"groups"`
+}
+
+// DuplicateGroup is a set of photos linked by near-identical hashes. Similarity is
+// the lowest pairwise score in the group; 1 means identical hashes.
+type DuplicateGroup struct {
+	Similarity float64          `json:"similarity"`
+	Albums     int              `json:"albums"` // distinct albums the photos are in
+	Photos     []DuplicatePhoto `json:"photos"`
+}
+
+// DuplicatePhoto is a group member; Similarity and Distance compare it with the
+// group's first photo.
+type DuplicatePhoto struct {
+	ID         string         `json:"id"`
+	AlbumID    string         `json:"album_id,omitempty"`
+	AlbumTitle string         `json:"album_title,omitempty"`
+	PageURL    string         `json:"page_url,omitempty"`
+	Image1500  string         `json:"image_1500,omitempty"`
+	Similarity float64        `json:"similarity"`
+	Distance   *hashDistances `json:"distance,omitempty"`
+}
+
+type hashDistances struct {
+	AHash int `json:"ahash"`
+	DHash int `json:"dhash"`
+	PHash int `json:"phash"`
+}
+
+// imageHashes is the numeric form of PhotoHashes.
+type imageHashes struct {
+	a, d, p uint64
+}
+
+func (h imageHashes) hex() *PhotoHashes {
+	return &PhotoHashes{AHash: fmt.Sprintf("%016x", h.a), DHash: fmt.Sprintf("%016x", h.d), PHash: fmt.Sprintf("%016x", h.p)}
+}
+
+func parseImageHashes(ph *PhotoHashes) (imageHashes, bool) {
+	if ph == nil {
+		return imageHashes{}, false
+	}
+	a, errA := strconv.ParseUint(ph.AHash, 16, 64)
+	d, errD := strconv.ParseUint(ph.DHash, 16, 64)
+	p, errP := strconv.ParseUint(ph.PHash, 16, 64)
+	return imageHashes{a, d, p}, errA == nil && errD == nil && errP == nil
+}
+
+func (h imageHashes) distance(o imageHashes) hashDistances {
+	return hashDistances{
+		AHash: bits.OnesCount64(h.a ^ o.a),
+		DHash: bits.OnesCount64(h.d ^ o.d),
+		PHash: bits.OnesCount64(h.p ^ o.p),
+	}
+}
+
+// similarity averages the three distances into a 0-1 score.
+func (d hashDistances) similarity() float64 {
+	s := 1 - float64(d.AHash+d.DHash+d.PHash)/192
+	return math.Round(s*1000) / 1000
+}
+
+// matches requires both structural hashes to agree: pHash alone merges photos of
+// similar scenes, dHash alone is thrown by recompression.
+func (d hashDistances) matches(maxDistance int) bool {
+	return d.PHash <= maxDistance && d.DHash <= maxDistance
+}
+
+// parseDupeDistance reads dupe_distance=, the largest Hamming distance still
+// counted as a duplicate.
+func parseDupeDistance(s string) (int, error) {
+	if strings.TrimSpace(s) == "" {
+		return defaultDupeDistance, nil
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || n < 0 || n > maxDupeDistance {
+		return 0, fmt.Errorf("invalid dupe_distance %q, use 0-%d", s, maxDupeDistance)
+	}
+	return n, nil
+}
+
+// hashImage computes aHash (8x8 mean), dHash (9x8 horizontal gradient) and
+// pHash (low frequencies of a 32x32 DCT against their median).
+func hashImage(img image.Image) imageHashes {
+	var h imageHashes
+
+	g := grayThumb(img, 8, 8)
+	var sum int
+	for _, v := range g.Pix {
+		sum += int(v)
+	}
+	mean := sum / len(g.Pix)
+	for i, v := range g.Pix {
+		if int(v) > mean {
+			h.a |= 1 << uint(i)
+		}
+	}
+
+	g = grayThumb(img, 9, 8)
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if g.Pix[y*g.Stride+x] > g.Pix[y*g.Stride+x+1] {
+				h.d |= 1 << uint(y*8+x)
+			}
+		}
+	}
+
+	g = grayThumb(img, 32, 32)
+	in := make([]float64, 32*32)
+	for i, v := range g.Pix {
+		in[i] = float64(v)
+	}
+	coef := dct2D(in, 32)
+	low := make([]float64, 0, 64)
+	for y := 0; y < 8; y++ {
+		low = append(low, coef[y*32:y*32+8]...)
+	}
+	// The DC term is the overall brightness; leave it out of the median
+	sorted := append([]float64(nil), low[1:]...)
+	sort.Float64s(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+	for i, v := range low {
+		if v > median {
+			h.p |= 1 << uint(i)
+		}
+	}
+	return h
+}
+
+func grayThumb(img image.Image, w, h int) *image.Gray {
+	g := image.NewGray(image.Rect(0, 0, w, h))
+	draw.BiLinear.Scale(g, g.Bounds(), img, img.Bounds(), draw.Src, nil)
+	return g
+}
+
+var (
+	dctOnce  sync.Once
+	dctTable []float64 // cos((2x+1)uπ/2n) for n = 32
+)
+
+// dct2D is a separable DCT-II of an n x n block (n = 32).
+func dct2D(in []float64, n int) []float64 {
+	dctOnce.Do(func() {
+		dctTable = make([]float64, n*n)
+		for u := 0; u < n; u++ {
+			for x := 0; x < n; x++ {
+				dctTable[u*n+x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / float64(2*n))
+			}
+		}
+	})
+	rows := make([]float64, n*n)
+	for y := 0; y < n; y++ {
+		for u := 0; u < n; u++ {
+			var s float64
+			for x := 0; x < n; x++ {
+				s += in[y*n+x] * dctTable[u*n+x]
+			}
+			rows[y*n+u] = s
+		}
+	}
+	out := make([]float64, n*n)
+	for u := 0; u < n; u++ {
+		for v := 0; v < n; v++ {
+			var s float64
+			for y := 0; y < n; y++ {
+				s += rows[y*n+u] * dctTable[v*n+y]
+			}
+			out[v*n+u] = s
+		}
+	}
+	return out
+}
+
+// thumbURL picks a small rendition to hash: the gallery pattern when known,
+// otherwise the pyramid's smallest level.
+func thumbURL(p Photo) string {
+	if p.ImagePattern != "" && p.Width > 0 && p.Height > 0 {
+		w, h := fitSize(p.Width, p.Height, dupeThumbEdge)
+		return sizedURL(p.ImagePattern, w, h)
+	}
+	if strings.Contains(p.Image1500, "_1500x1000.") {
+		return strings.Replace(p.Image1500, "_1500x1000.", "_750x500.", 1)
+	}
+	return p.Image1500
+}
+
+// hashedPhoto is a photo with its hashes and the album it was seen in.
+type hashedPhoto struct {
+	Photo      Photo
+	AlbumID    string
+	AlbumTitle string
+	hashes     imageHashes
+}
+
+// findDuplicates hashes every photo of resp (reusing hashes stored in the catalog),
+// fills Photo.Hashes, stores new hashes and groups the near-duplicates. Hashing
+// stops when ctx ends or after dupeTimeout; photos not reached count as failed.
+func findDuplicates(ctx context.Context, resp *Response, maxDistance int) *DuplicateReport {
+	ctx, cancel := context.WithTimeout(ctx, dupeTimeout)
+	defer cancel()
+	report := &DuplicateReport{MaxDistance: maxDistance, Groups: []DuplicateGroup{}}
+	type ref struct{ a, p int }
+	var refs []ref
+	var ids []string
+	for ai := range resp.Albums {
+		for pi := range resp.Albums[ai].Photos {
+			p := &resp.Albums[ai].Photos[pi]
+			if p.ID == "" || p.Image1500 == "" {
+				continue
+			}
+			if len(refs) >= dupeMaxPhotos {
+				report.Skipped++
+				continue
+			}
+			refs = append(refs, ref{ai, pi})
+			ids = append(ids, p.ID)
+		}
+	}
+
+	stored := map[string]*PhotoHashes{}
+	if catalog != nil {
+		var err error
+		if stored, err = catalog.photoHashes(ids); err != nil {
+			log.Printf("catalog: loading photo hashes: %v", err)
+			stored = map[string]*PhotoHashes{}
+		}
+	}
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		fresh   = map[string]*PhotoHashes{}
+		work    = make(chan ref)
+		workers = max(dupeWorkers, 1)
+	)
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range work {
+				p := &resp.Albums[r.a].Photos[r.p]
+				img, err := fetchImg(ctx, thumbURL(*p))
+				mu.Lock()
+				if err != nil {
+					log.Printf("dupes: %s: %v", p.ID, err)
+					report.Failed++
+				} else {
+					p.Hashes = hashImage(img).hex()
+					fresh[p.ID] = p.Hashes
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, r := range refs {
+		p := &resp.Albums[r.a].Photos[r.p]
+		if h := stored[p.ID]; h != nil {
+			p.Hashes = h
+			report.Cached++
+			continue
+		}
+		if ctx.Err() != nil {
+			report.Failed++
+			continue
+		}
+		work <- r
+	}
+	close(work)
+	wg.Wait()
+
+	if catalog != nil && len(fresh) > 0 {
+		if err := catalog.storePhotoHashes(fresh); err != nil {
+			log.Printf("catalog: storing photo hashes: %v", err)
+		}
+	}
+
+	var items []hashedPhoto
+	for _, r := range refs {
+		a := &resp.Albums[r.a]
+		p := a.Photos[r.p]
+		if h, ok := parseImageHashes(p.Hashes); ok {
+			items = append(items, hashedPhoto{Photo: p, AlbumID: a.ID, AlbumTitle: a.Title, hashes: h})
+		}
+	}
+	report.Hashed = len(items)
+	report.Groups = groupDuplicates(items, maxDistance)
+	return report
+}
+
+// groupDuplicates links every matching pair and returns the connected groups,
+// those spanning several albums first, then larger and closer groups.
+func groupDuplicates(items []hashedPhoto, maxDistance int) []DuplicateGroup {
+	parent := make([]int, len(items))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range items {
+		for j := i + 1; j < len(items); j++ {
+			if items[i].hashes.distance(items[j].hashes).matches(maxDistance) {
+				parent[find(j)] = find(i)
+			}
+		}
+	}
+
+	members := map[int][]int{}
+	var roots []int
+	for i := range items {
+		r := find(i)
+		if len(members[r]) == 0 {
+			roots = append(roots, r)
+		}
+		members[r] = append(members[r], i)
+	}
+
+	groups := []DuplicateGroup{}
+	for _, r := range roots {
+		m := members[r]
+		if len(m) < 2 {
+			continue
+		}
+		g := DuplicateGroup{Similarity: 1}
+		albums := map[string]bool{}
+		first := items[m[0]].hashes
+		for k, i := range m {
+			it := items[i]
+			albums[it.AlbumID] = true
+			d := first.distance(it.hashes)
+			dp := DuplicatePhoto{ID: it.Photo.ID, AlbumID: it.AlbumID, AlbumTitle: it.AlbumTitle, PageURL: it.Photo.PageURL, Image1500: it.Photo.Image1500, Similarity: d.similarity()}
+			if k > 0 {
+				dp.Distance = &d
+			}
+			g.Photos = append(g.Photos, dp)
+			for _, j := range m[k+1:] {
+				g.Similarity = math.Min(g.Similarity, it.hashes.distance(items[j].hashes).similarity())
+			}
+		}
+		g.Albums = len(albums)
+		groups = append(groups, g)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		a, b := groups[i], groups[j]
+		if (a.Albums > 1) != (b.Albums > 1) {
+			return a.Albums > 1
+		}
+		if len(a.Photos) != len(b.Photos) {
+			return len(a.Photos) > len(b.Photos)
+		}
+		return a.Similarity > b.Similarity
+	})
+	return groups
+}
+
+// photoHashes loads stored hashes for the given photo IDs.
+func (c *catalogStore) photoHashes(ids []string) (map[string]*PhotoHashes, error) {
+	out := make(map[string]*PhotoHashes, len(ids))
+	// Stay well under SQLite's bound-parameter limit
+	for len(ids) > 0 {
+		chunk := ids[:min(len(ids), 500)]
+		ids = ids[len(chunk):]
+		args := make([]any, len(chunk))
+		for i, id := range chunk {
+			args[i] = id
+		}
+		rows, err := c.db.Query(`SELECT photo_id, ahash, dhash, phash FROM photo_hashes WHERE photo_id IN (?`+strings.Repeat(", ?", len(chunk)-1)+`)`, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id string
+			h := &PhotoHashes{}
+			if err := rows.Scan(&id, &h.AHash, &h.DHash, &h.PHash); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			out[id] = h
+		}
+		if err := rows.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func (c *catalogStore) storePhotoHashes(hashes map[string]*PhotoHashes) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for id, h := range hashes {
+		if _, err := tx.Exec(`INSERT INTO photo_hashes (photo_id, ahash, dhash, phash, computed_at) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (photo_id) DO UPDATE SET ahash = excluded.ahash, dhash = excluded.dhash, phash = excluded.phash, computed_at = excluded.computed_at`,
+			id, h.AHash, h.DHash, h.PHash, now); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// accountHashedPhotos reads up to limit stored photos of an account that have
+// hashes, leaving out photos and albums marked removed, and counts the rest.
+func (c *catalogStore) accountHashedPhotos(account string, limit int) ([]hashedPhoto, int, error) {
+	where, args := accountClause("a.account", account)
+	rows, err := c.db.Query(`SELECT p.id, p.page_url, p.image_1500, a.id, a.title, h.ahash, h.dhash, h.phash
+		FROM photo_hashes h JOIN photos p ON p.id = h.photo_id JOIN albums a ON a.id = p.album_id
+		WHERE p.removed_at IS NULL AND a.removed_at IS NULL AND `+where+`
+		ORDER BY a.date_iso, a.id, p.first_seen, p.id`, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	var (
+		out     []hashedPhoto
+		skipped int
+	)
+	for rows.Next() {
+		if len(out) >= limit {
+			skipped++
+			continue
+		}
+		var it hashedPhoto
+		h := &PhotoHashes{}
+		if err := rows.Scan(&it.Photo.ID, &it.Photo.PageURL, &it.Photo.Image1500, &it.AlbumID, &it.AlbumTitle, &h.AHash, &h.DHash, &h.PHash); err != nil {
+			return nil, 0, err
+		}
+		var ok bool
+		if it.hashes, ok = parseImageHashes(h); ok {
+			out = append(out, it)
+		}
+	}
+	return out, skipped, rows.Err()
+}
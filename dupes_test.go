@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"net/http"
+	"testing"
+
+	"golang.org/x/image/draw"
+)
+
+// scene draws a 600x400 test picture: a gradient sky, a sun and hills whose
+// positions depend on seed, so different seeds give different pictures.
+func scene(seed int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 600, 400))
+	sunX, sunY := 120+seed*97%360, 80+seed*53%120
+	for y := 0; y < 400; y++ {
+		for x := 0; x < 600; x++ {
+			c := color.RGBA{uint8(40 + y/3), uint8(90 + y/4), 220, 255}
+			if dx, dy := x-sunX, y-sunY; dx*dx+dy*dy < 45*45 {
+				c = color.RGBA{250, 220, 60, 255}
+			}
+			if y > 260+int(50*math.Sin(float64(x+seed*83)/(60+float64(seed%5)*15))) {
+				c = color.RGBA{30, uint8(110 + (x+seed*7)%60), 40, 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+// variant returns what Zonerama serves for the same photo: a smaller
+// rendition, recompressed as JPEG and slightly brighter.
+func variant(t *testing.T, img image.Image, edge, quality int, brighten uint8) image.Image {
+	t.Helper()
+	b := img.Bounds()
+	w, h := fitSize(b.Dx(), b.Dy(), edge)
+	small := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(small, small.Bounds(), img, b, draw.Src, nil)
+	for i := range small.Pix {
+		if i%4 != 3 {
+			small.Pix[i] = uint8(min(int(small.Pix[i])+int(brighten), 255))
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, small, &jpeg.Options{Quality: quality}); err != nil {
+		t.Fatal(err)
+	}
+	out, err := jpeg.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestHashImage(t *testing.T) {
+	orig := scene(1)
+	h := hashImage(orig)
+	tests := []struct {
+		name  string
+		img   image.Image
+		match bool
+	}{
+		{"same picture", orig, true},
+		{"750px rendition", variant(t, orig, 750, 90, 0), true},
+		{"thumbnail at quality 60", variant(t, orig, 256, 60, 0), true},
+		{"brighter thumbnail", variant(t, orig, 256, 75, 25), true},
+		{"other picture", scene(2), false},
+		{"third picture", variant(t, scene(3), 256, 75, 0), false},
+	}
+	for _, tt := range tests {
+		d := h.distance(hashImage(tt.img))
+		if d.matches(defaultDupeDistance) != tt.match {
+			t.Errorf("%s: distances %+v, want match %v", tt.name, d, tt.match)
+		}
+	}
+	if d := h.distance(h); d != (hashDistances{}) || d.similarity() != 1 {
+		t.Errorf("identical hashes: distance %+v, similarity %v", d, d.similarity())
+	}
+}
+
+func TestImageHashesHex(t *testing.T) {
+	h := hashImage(scene(4))
+	back, ok := parseImageHashes(h.hex())
+	if !ok || back != h {
+		t.Errorf("round trip: got %+v %v, want %+v", back, ok, h)
+	}
+	for _, ph := range []*PhotoHashes{nil, {AHash: "zz", DHash: "0", PHash: "0"}, {AHash: "0", DHash: "0"}} {
+		if _, ok := parseImageHashes(ph); ok {
+			t.Errorf("%+v: parsed, want failure", ph)
+		}
+	}
+}
+
+func TestHashDistances(t *testing.T) {
+	tests := []struct {
+		d          hashDistances
+		similarity float64
+		match      bool
+	}{
+		{hashDistances{}, 1, true},
+		{hashDistances{AHash: 64, DHash: 0, PHash: 0}, 0.667, true}, // aHash does not decide
+		{hashDistances{AHash: 3, DHash: 10, PHash: 10}, 0.880, true},
+		{hashDistances{AHash: 3, DHash: 11, PHash: 2}, 0.917, false},
+		{hashDistances{AHash: 3, DHash: 2, PHash: 11}, 0.917, false},
+		{hashDistances{AHash: 64, DHash: 64, PHash: 64}, 0, false},
+	}
+	for _, tt := range tests {
+		if s := tt.d.similarity(); s != tt.similarity {
+			t.Errorf("%+v: similarity %v, want %v", tt.d, s, tt.similarity)
+		}
+		if m := tt.d.matches(defaultDupeDistance); m != tt.match {
+			t.Errorf("%+v: match %v, want %v", tt.d, m, tt.match)
+		}
+	}
+}
+
+func TestParseDupeDistance(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+		ok   bool
+	}{
+		{"", defaultDupeDistance, true},
+		{" 4 ", 4, true},
+		{"0", 0, true},
+		{"32", 32, true},
+		{"33", 0, false},
+		{"-1", 0, false},
+		{"near", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseDupeDistance(tt.in)
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Errorf("%q: got %d, %v", tt.in, got, err)
+		}
+	}
+}
+
+func TestGroupDuplicates(t *testing.T) {
+	// Photos of the snippet3.html gallery: three renditions of one picture
+	// across two albums, two of another and one unrelated
+	photos := fixturePhotos(t, "snippet3.html")
+	a, b, c := hashImage(scene(1)), hashImage(scene(2)), hashImage(scene(3))
+	item := func(i int, album string, h imageHashes) hashedPhoto {
+		return hashedPhoto{Photo: photos[i], AlbumID: album, hashes: h}
+	}
+	items := []hashedPhoto{
+		item(0, "13903610", b),
+		item(1, "13903610", a),
+		item(2, "13903610", hashImage(variant(t, scene(2), 256, 60, 0))),
+		item(3, "13903610", c),
+		item(4, "13903610", hashImage(variant(t, scene(1), 256, 75, 10))),
+		item(5, "13903599", hashImage(variant(t, scene(1), 750, 90, 0))),
+	}
+	groups := groupDuplicates(items, defaultDupeDistance)
+	var got [][]string
+	for _, g := range groups {
+		var ids []string
+		for _, p := range g.Photos {
+			ids = append(ids, p.ID)
+		}
+		got = append(got, ids)
+	}
+	// The group spanning both albums comes first; unique photos are left out
+	want := [][]string{{photos[1].ID, photos[4].ID, photos[5].ID}, {photos[0].ID, photos[2].ID}}
+	if len(got) != len(want) {
+		t.Fatalf("got groups %v, want %v", got, want)
+	}
+	for i := range want {
+		if len(got[i]) != len(want[i]) {
+			t.Fatalf("got groups %v, want %v", got, want)
+		}
+		for j := range want[i] {
+			if got[i][j] != want[i][j] {
+				t.Fatalf("got groups %v, want %v", got, want)
+			}
+		}
+	}
+	if groups[0].Albums != 2 || groups[1].Albums != 1 {
+		t.Errorf("album counts %d, %d, want 2, 1", groups[0].Albums, groups[1].Albums)
+	}
+	for _, g := range groups {
+		if g.Photos[0].Distance != nil || g.Photos[1].Distance == nil || g.Similarity <= 0.8 || g.Similarity > 1 {
+			t.Errorf("group %+v: want a distance on every photo but the first and a high similarity", g)
+		}
+	}
+	if len(groupDuplicates(items[3:4], defaultDupeDistance)) != 0 {
+		t.Error("a single photo formed a group")
+	}
+}
+
+func TestAccountHashedPhotosSkipsRemoved(t *testing.T) {
+	c := testCatalog(t)
+	photos := []Photo{{ID: "1"}, {ID: "2"}, {ID: "3"}}
+	albums := []Album{
+		{ID: "10", Title: "Kept", URL: "https://eu.zonerama.com/FKKofolaKrnov/Album/10", Photos: photos[:2]},
+		{ID: "11", Title: "Gone", URL: "https://eu.zonerama.com/FKKofolaKrnov/Album/11", Photos: photos[2:]},
+	}
+	if err := c.upsert(profileScrape(t, albums), false, false); err != nil {
+		t.Fatal(err)
+	}
+	h := hashImage(scene(1)).hex()
+	if err := c.storePhotoHashes(map[string]*PhotoHashes{"1": h, "2": h, "3": h}); err != nil {
+		t.Fatal(err)
+	}
+	// Album 11 disappears from the tab, photo 2 from album 10
+	albums = albums[:1]
+	albums[0].Photos = photos[:1]
+	if err := c.upsert(profileScrape(t, albums), true, true); err != nil {
+		t.Fatal(err)
+	}
+	items, skipped, err := c.accountHashedPhotos("FKKofolaKrnov", 10)
+	if err != nil || len(items) != 1 || items[0].Photo.ID != "1" || skipped != 0 {
+		t.Errorf("got %+v, %d skipped, %v, want photo 1 only", items, skipped, err)
+	}
+}
+
+func TestAccountHashedPhotosCap(t *testing.T) {
+	c := testCatalog(t)
+	photos := []Photo{{ID: "1"}, {ID: "2"}, {ID: "3"}}
+	albums := []Album{{ID: "10", Title: "A", URL: "https://eu.zonerama.com/FKKofolaKrnov/Album/10", Photos: photos}}
+	if err := c.upsert(profileScrape(t, albums), false, false); err != nil {
+		t.Fatal(err)
+	}
+	h := hashImage(scene(1)).hex()
+	if err := c.storePhotoHashes(map[string]*PhotoHashes{"1": h, "2": h, "3": h}); err != nil {
+		t.Fatal(err)
+	}
+	items, skipped, err := c.accountHashedPhotos("FKKofolaKrnov", 2)
+	if err != nil || len(items) != 2 || skipped != 1 {
+		t.Errorf("got %d photos, %d skipped, %v, want 2 and 1", len(items), skipped, err)
+	}
+}
+
+func TestFindDuplicatesTimeout(t *testing.T) {
+	ft := &imgTransport{}
+	oldClient, oldTimeout, oldCatalog := imgHTTPClient, dupeTimeout, catalog
+	imgHTTPClient, dupeTimeout, catalog = &http.Client{Transport: ft}, 0, nil
+	t.Cleanup(func() { imgHTTPClient, dupeTimeout, catalog = oldClient, oldTimeout, oldCatalog })
+
+	resp := &Response{Albums: []Album{{ID: "10", Photos: []Photo{
+		{ID: "1", Image1500: "https://eu.zonerama.com/photos/1_1500x1000.jpg"},
+		{ID: "2", Image1500: "https://eu.zonerama.com/photos/2_1500x1000.jpg"},
+	}}}}
+	report := findDuplicates(context.Background(), resp, defaultDupeDistance)
+	if report.Failed != 2 || report.Hashed != 0 || ft.fetched.Load() != 0 {
+		t.Errorf("got %+v after %d fetches, want both photos failed unfetched", report, ft.fetched.Load())
+	}
+}
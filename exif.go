@@ -0,0 +1,171 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/geziyor/geziyor"
+	"github.com/geziyor/geziyor/client"
+)
+
+// Exif is the camera metadata Zonerama shows in the photo info panel.
+// Values are kept as displayed (e.g. "1/1000 s", "262 mm"), except ISO and the capture time.
+type Exif struct {
+	Camera        string            `json:"camera,omitempty"`
+	Lens          string            `json:"lens,omitempty"`
+	FocalLength   string            `json:"focal_length,omitempty"`
+	FocalLength35 string            `json:"focal_length_35mm,omitempty"`
+	Exposure      string            `json:"exposure,omitempty"`
+	Aperture      string            `json:"aperture,omitempty"`
+	ISO           int               `json:"iso,omitempty"`
+	ExposureBias  string            `json:"exposure_bias,omitempty"`
+	CapturedAt    string            `json:"captured_at,omitempty"` // local time without zone, 2006-01-02T15:04:05
+	Raw           map[string]string `json:"raw,omitempty"`         // every label/value pair of the panel
+}
+
+// Panel labels as served in Czech and English, lowercased and without the trailing colon.
+var exifLabels = map[string]string{
+	"fotoaparát":                    "camera",
+	"camera":                        "camera",
+	"objektiv":                      "lens",
+	"lens":                          "lens",
+	"ohnisková vzdálenost":          "focal",
+	"focal length":                  "focal",
+	"ohnisková vzdálenost (eq35mm)": "focal35",
+	"focal length (eq35mm)":         "focal35",
+	"doba expozice":                 "exposure",
+	"exposure time":                 "exposure",
+	"exposure":                      "exposure",
+	"clona":                         "aperture",
+	"aperture":                      "aperture",
+	"f-number":                      "aperture",
+	"iso":                           "iso",
+	"kompenzace expozice":           "bias",
+	"exposure compensation":         "bias",
+	"exposure bias":                 "bias",
+	"vytvořeno":                     "created",
+	"created":                       "created",
+	"date taken":                    "created",
+}
+
+// parseInfoTable reads the label/value rows of the info panel (".param table tr").
+func parseInfoTable(s *goquery.Selection) map[string]string {
+	rows := make(map[string]string)
+	sels := currentSelectors()
+	s.Find(sels.Page.InfoRows).Each(func(i int, tr *goquery.Selection) {
+		tds := tr.Find(sels.Page.InfoCells)
+		if tds.Length() < 2 {
+			return
+		}
+		label := strings.TrimSpace(tds.Eq(0).Text())
+		label = strings.TrimSpace(strings.TrimSuffix(label, ":"))
+		value := strings.Join(strings.Fields(tds.Eq(1).Text()), " ")
+		if label != "" && value != "" {
+			rows[label] = value
+		}
+	})
+	return rows
+}
+
+// exifFromInfoTable maps the panel rows onto Exif. Returns nil when the panel
+// has no camera data (e.g. the owner disabled it).
+func exifFromInfoTable(rows map[string]string) *Exif {
+	e := &Exif{Raw: make(map[string]string)}
+	found := false
+	for label, value := range rows {
+		e.Raw[label] = value
+		key, ok := exifLabels[strings.ToLower(label)]
+		if !ok {
+			continue
+		}
+		found = true
+		switch key {
+		case "camera":
+			e.Camera = value
+		case "lens":
+			e.Lens = value
+		case "focal":
+			e.FocalLength = value
+		case "focal35":
+			e.FocalLength35 = value
+		case "exposure":
+			e.Exposure = value
+		case "aperture":
+			e.Aperture = value
+		case "iso":
+			e.ISO, _ = strconv.Atoi(strings.Fields(value + " ")[0])
+		case "bias":
+			e.ExposureBias = value
+		case "created":
+			e.CapturedAt = normalizeExifTime(value)
+		}
+	}
+	if !found {
+		return nil
+	}
+	return e
+}
+
+// normalizeExifTime turns the panel's "20.09.2025 11:16:51" into "2025-09-20T11:16:51".
+func normalizeExifTime(s string) string {
+	for _, layout := range []string{"02.01.2006 15:04:05", "2.1.2006 15:04:05", "01/02/2006 15:04:05", "1/2/2006 3:04:05 PM", "2006-01-02 15:04:05", "2006:01:02 15:04:05"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.Format("2006-01-02T15:04:05")
+		}
+	}
+	return s
+}
+
+// exifCollector fetches EXIF for album photos (exif=true) through the photo slide
+// fragment, one request per photo, and optionally falls back to the JPEG bytes.
+type exifCollector struct {
+	mu   sync.Mutex
+	jpeg bool
+	byID map[string]*Exif
+}
+
+func newExifCollector(jpeg bool) *exifCollector {
+	return &exifCollector{jpeg: jpeg, byID: make(map[string]*Exif)}
+}
+
+// fetch queues the slide request for one photo; base resolves the relative slide URL.
+func (c *exifCollector) fetch(g *geziyor.Geziyor, polite *crawlPoliteness, base *client.Response, p Photo) {
+	g.Get(base.JoinURL("/Part/PhotoOnSlide?ID="+p.ID), polite.retrying(func(g *geziyor.Geziyor, cr *client.Response) {
+		if !responseAllowed(cr) {
+			return
+		}
+		d := &PhotoDetail{Photo: p}
+		if cr.HTMLDoc != nil {
+			applyPhotoSlide(cr.HTMLDoc.Selection, d)
+		}
+		ex := d.Exif
+		if ex == nil && c.jpeg {
+			var err error
+			if ex, err = fetchJPEGExif(polite.ctx, photoImageURL(d.Sizes, p.Image1500)); err != nil {
+				log.Printf("exif: reading JPEG for photo %s: %v", p.ID, err)
+			}
+		}
+		if ex != nil {
+			c.mu.Lock()
+			c.byID[p.ID] = ex
+			c.mu.Unlock()
+		}
+	}))
+}
+
+// apply attaches collected EXIF to the photos once the crawl has finished.
+func (c *exifCollector) apply(albums []Album) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range albums {
+		for j := range albums[i].Photos {
+			if ex, ok := c.byID[albums[i].Photos[j].ID]; ok {
+				albums[i].Photos[j].Exif = ex
+			}
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// The info panel of snippet4.html, as /zonerama-photo reports it.
+var snippet4Exif = Exif{
+	Camera:        "Canon EOS 250D",
+	Lens:          "EF70-300mm f/4-5.6 IS II USM",
+	FocalLength:   "262 mm",
+	FocalLength35: "419 mm",
+	Exposure:      "1/1000 s",
+	Aperture:      "5.6",
+	ISO:           320,
+	ExposureBias:  "0",
+	CapturedAt:    "2025-09-20T11:16:51",
+}
+
+func TestExifFromInfoTable(t *testing.T) {
+	panel := func(rows string) *goquery.Selection {
+		doc, err := goquery.NewDocumentFromReader(bytes.NewReader([]byte(`<div class="param"><table>` + rows + `</table></div>`)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return doc.Selection
+	}
+	tests := []struct {
+		name  string
+		scope *goquery.Selection
+		want  *Exif
+		raw   int // panel rows
+	}{
+		{"snippet4", loadFixture(t, "snippet4.html").Selection, &snippet4Exif, 14},
+		{"english", panel(`<tr><td>Camera:</td><td>NIKON Z 6</td></tr>
+			<tr><td>Exposure time:</td><td>1/250 s</td></tr>
+			<tr><td>ISO:</td><td>1600 </td></tr>
+			<tr><td>Date taken:</td><td>9/20/2025 1:16:51 PM</td></tr>`),
+			&Exif{Camera: "NIKON Z 6", Exposure: "1/250 s", ISO: 1600, CapturedAt: "2025-09-20T13:16:51"}, 4},
+		{"no camera data", panel(`<tr><td>Soubor:</td><td>IMG_1.jpg</td></tr><tr><td>Velikost:</td><td>2 MB</td></tr>`), nil, 2},
+		{"rows without a value", panel(`<tr><td>Fotoaparát:</td><td></td></tr><tr><td colspan="2">Canon</td></tr>`), nil, 0},
+	}
+	for _, tt := range tests {
+		rows := parseInfoTable(tt.scope)
+		if len(rows) != tt.raw {
+			t.Errorf("%s: %d rows, want %d: %v", tt.name, len(rows), tt.raw, rows)
+		}
+		got := exifFromInfoTable(rows)
+		if tt.want == nil {
+			if got != nil {
+				t.Errorf("%s: got %+v, want nil", tt.name, got)
+			}
+			continue
+		}
+		if got == nil {
+			t.Errorf("%s: got nil", tt.name)
+			continue
+		}
+		if len(got.Raw) != tt.raw {
+			t.Errorf("%s: raw has %d rows, want %d", tt.name, len(got.Raw), tt.raw)
+		}
+		got.Raw = nil
+		if !reflect.DeepEqual(*got, *tt.want) {
+			t.Errorf("%s:\n got %+v\nwant %+v", tt.name, *got, *tt.want)
+		}
+	}
+}
+
+// tiffTag is one IFD entry; val is already encoded in the file's byte order.
+type tiffTag struct {
+	tag, typ uint16
+	count    uint32
+	val      []byte
+}
+
+func tiffASCII(tag uint16, s string) tiffTag {
+	return tiffTag{tag, 2, uint32(len(s) + 1), append([]byte(s), 0)}
+}
+
+func tiffShort(bo binary.AppendByteOrder, tag, v uint16) tiffTag {
+	return tiffTag{tag, 3, 1, bo.AppendUint16(nil, v)}
+}
+
+func tiffRational(bo binary.AppendByteOrder, tag uint16, signed bool, num, den int32) tiffTag {
+	typ := uint16(5)
+	if signed {
+		typ = 10
+	}
+	return tiffTag{tag, typ, 1, bo.AppendUint32(bo.AppendUint32(nil, uint32(num)), uint32(den))}
+}
+
+// buildTIFF lays out IFD0 with a pointer to the Exif IFD, then the values that
+// do not fit into an entry.
+func buildTIFF(bo binary.AppendByteOrder, ifd0, exif []tiffTag) []byte {
+	ifd0 = append(ifd0, tiffTag{tag: tagExifIFD, typ: 4, count: 1})
+	size := func(tags []tiffTag) int { return 2 + 12*len(tags) + 4 }
+	exifOff := 8 + size(ifd0)
+	dataOff := exifOff + size(exif)
+	ifd0[len(ifd0)-1].val = bo.AppendUint32(nil, uint32(exifOff))
+
+	var head, data []byte
+	if bo == binary.AppendByteOrder(binary.LittleEndian) {
+		head = []byte("II")
+	} else {
+		head = []byte("MM")
+	}
+	head = bo.AppendUint16(head, 42)
+	head = bo.AppendUint32(head, 8)
+	for _, tags := range [][]tiffTag{ifd0, exif} {
+		head = bo.AppendUint16(head, uint16(len(tags)))
+		for _, tg := range tags {
+			head = bo.AppendUint16(head, tg.tag)
+			head = bo.AppendUint16(head, tg.typ)
+			head = bo.AppendUint32(head, tg.count)
+			if len(tg.val) <= 4 {
+				head = append(head, append(tg.val, make([]byte, 4-len(tg.val))...)...)
+				continue
+			}
+			head = bo.AppendUint32(head, uint32(dataOff+len(data)))
+			data = append(data, tg.val...)
+		}
+		head = bo.AppendUint32(head, 0)
+	}
+	return append(head, data...)
+}
+
+// buildJPEG wraps an EXIF block in an APP1 segment after a JFIF header.
+func buildJPEG(tiff []byte) []byte {
+	b := []byte{0xFF, 0xD8}
+	jfif := []byte("JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00")
+	b = append(b, 0xFF, 0xE0)
+	b = binary.BigEndian.AppendUint16(b, uint16(2+len(jfif)))
+	b = append(b, jfif...)
+	if tiff != nil {
+		seg := append([]byte("Exif\x00\x00"), tiff...)
+		b = append(b, 0xFF, 0xE1)
+		b = binary.BigEndian.AppendUint16(b, uint16(2+len(seg)))
+		b = append(b, seg...)
+	}
+	return append(b, 0xFF, 0xDA, 0x00, 0x02, 0xFF, 0xD9)
+}
+
+// snippet4TIFF is the EXIF block of the snippet4.html photo, as the camera writes it.
+func snippet4TIFF(bo binary.AppendByteOrder) []byte {
+	return buildTIFF(bo,
+		[]tiffTag{tiffASCII(tagMake, "Canon"), tiffASCII(tagModel, "Canon EOS 250D"), tiffASCII(tagDateTime, "2025:09:22 08:00:00")},
+		[]tiffTag{
+			tiffRational(bo, tagExposureTime, false, 1, 1000),
+			tiffRational(bo, tagFNumber, false, 56, 10),
+			tiffShort(bo, tagISO, 320),
+			tiffASCII(tagDateTimeOriginal, "2025:09:20 11:16:51"),
+			tiffRational(bo, tagExposureBias, true, 0, 1),
+			tiffRational(bo, tagFocalLength, false, 262, 1),
+			tiffShort(bo, tagFocalLength35, 419),
+			tiffASCII(tagLensModel, "EF70-300mm f/4-5.6 IS II USM"),
+		})
+}
+
+func TestDecodeJPEGExif(t *testing.T) {
+	le, be := binary.LittleEndian, binary.BigEndian
+	tests := []struct {
+		name    string
+		data    []byte
+		want    *Exif
+		wantErr bool
+	}{
+		// Decoded from the image bytes, the photo matches its info panel
+		{"little endian", buildJPEG(snippet4TIFF(le)), &snippet4Exif, false},
+		{"big endian", buildJPEG(snippet4TIFF(be)), &snippet4Exif, false},
+		{"model without make prefix", buildJPEG(buildTIFF(le,
+			[]tiffTag{tiffASCII(tagMake, "NIKON CORPORATION"), tiffASCII(tagModel, "Z 6")},
+			[]tiffTag{tiffRational(le, tagExposureTime, false, 2, 1), tiffRational(le, tagExposureBias, true, -2, 3)})),
+			&Exif{Camera: "NIKON CORPORATION Z 6", Exposure: "2 s", ExposureBias: "-0.67"}, false},
+		{"capture time from DateTime", buildJPEG(buildTIFF(be, []tiffTag{tiffASCII(tagDateTime, "2025:09:22 08:00:00")}, nil)),
+			&Exif{CapturedAt: "2025-09-22T08:00:00"}, false},
+		{"stripped", buildJPEG(nil), nil, false},
+		{"no camera tags", buildJPEG(buildTIFF(le, nil, []tiffTag{tiffShort(le, tagFocalLength35, 50)})), nil, false},
+		{"not a JPEG", []byte("\x89PNG\r\n\x1a\n"), nil, true},
+		{"truncated segment", buildJPEG(snippet4TIFF(le))[:40], nil, true},
+		{"bad byte order", buildJPEG([]byte("XX\x00\x2a\x00\x00\x00\x08")), nil, true},
+	}
+	for _, tt := range tests {
+		got, err := decodeJPEGExif(tt.data)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if tt.want == nil || got == nil {
+			if got != tt.want {
+				t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+			}
+			continue
+		}
+		got.Raw = nil
+		if !reflect.DeepEqual(*got, *tt.want) {
+			t.Errorf("%s:\n got %+v\nwant %+v", tt.name, *got, *tt.want)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// fieldSet is a parsed fields= selection: each key maps to its selected
+// sub-fields, or to nil when the whole value is kept.
+type fieldSet map[string]fieldSet
+
+// parseFields reads a comma-separated list of dotted JSON paths, e.g.
+// "albums.id,albums.title,albums.photos.image_1500". Empty means everything.
+func parseFields(s string) (fieldSet, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	root := fieldSet{}
+	for _, path := range strings.Split(s, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		node := root
+		parts := strings.Split(path, ".")
+		for i, name := range parts {
+			if name == "" {
+				return nil, fmt.Errorf("invalid field %q", path)
+			}
+			child, seen := node[name]
+			if i == len(parts)-1 {
+				// A shorter path wins: "albums" keeps albums whole
+				node[name] = nil
+				break
+			}
+			if seen && child == nil {
+				break
+			}
+			if child == nil {
+				child = fieldSet{}
+				node[name] = child
+			}
+			node = child
+		}
+	}
+	return root, nil
+}
+
+// project keeps only the selected fields of a decoded JSON value. Arrays are
+// projected element by element.
+func project(v any, f fieldSet) any {
+	if f == nil {
+		return v
+	}
+	switch t := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(f))
+		for name, sub := range f {
+			if child, ok := t[name]; ok {
+				out[name] = project(child, sub)
+			}
+		}
+		return out
+	case []any:
+		for i := range t {
+			t[i] = project(t[i], f)
+		}
+		return t
+	}
+	return v
+}
+
+// writeJSON encodes v the way every endpoint does (indented), trimmed to the
+// fields= selection of r. next_cursor is always kept so paging keeps working.
+func writeJSON(w http.ResponseWriter, r *http.Request, v any) {
+	fields, err := parseFields(r.URL.Query().Get("fields"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if fields == nil {
+		_ = enc.Encode(v)
+		return
+	}
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(v); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	var generic any
+	dec := json.NewDecoder(&buf)
+	dec.UseNumber()
+	if err := dec.Decode(&generic); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if _, ok := fields["next_cursor"]; !ok {
+		fields["next_cursor"] = nil
+	}
+	_ = enc.Encode(project(generic, fields))
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseFields(t *testing.T) {
+	tests := []struct {
+		in   string
+		want fieldSet
+		err  bool
+	}{
+		{"", nil, false},
+		{" , ", fieldSet{}, false},
+		{"albums.id,albums.title", fieldSet{"albums": {"id": nil, "title": nil}}, false},
+		// A shorter path keeps the whole value, in either order
+		{"albums,albums.id", fieldSet{"albums": nil}, false},
+		{"albums.id,albums", fieldSet{"albums": nil}, false},
+		{"albums.photos.image_1500, input_link", fieldSet{"albums": {"photos": {"image_1500": nil}}, "input_link": nil}, false},
+		{"albums..id", nil, true},
+		{"albums.", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseFields(tt.in)
+		if (err != nil) != tt.err || (!tt.err && !reflect.DeepEqual(got, tt.want)) {
+			t.Errorf("%q: got %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestWriteJSONFields(t *testing.T) {
+	albums := fixtureAlbums(t, "clean.html")[:2]
+	albums[0].Photos = fixturePhotos(t, "snippet3.html")[:2]
+	albums[1].Photos = []Photo{}
+	resp := &Response{InputLink: "https://eu.zonerama.com/FKKofolaKrnov/1", Albums: albums, NextCursor: "abc"}
+
+	w := httptest.NewRecorder()
+	writeJSON(w, httptest.NewRequest("GET", "/zonerama?fields=albums.id,albums.photos.id", nil), resp)
+	var got map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decoding %s: %v", w.Body.String(), err)
+	}
+	want := map[string]any{
+		"next_cursor": "abc", // always kept
+		"albums": []any{
+			map[string]any{"id": albums[0].ID, "photos": []any{
+				map[string]any{"id": albums[0].Photos[0].ID},
+				map[string]any{"id": albums[0].Photos[1].ID},
+			}},
+			map[string]any{"id": albums[1].ID, "photos": []any{}},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+
+	w = httptest.NewRecorder()
+	writeJSON(w, httptest.NewRequest("GET", "/zonerama?fields=albums..id", nil), resp)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid fields: got %d", w.Code)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// albumFilter selects profile tiles before their album pages are fetched:
+// since/until (inclusive dates), title (substring, or /regex/), min_photos and
+// exclude (album IDs, album links or title substrings, comma separated).
+type albumFilter struct {
+	since, until int // yyyymmdd, 0 = unset
+	title        *regexp.Regexp
+	minPhotos    int
+	excludeIDs   map[string]bool
+	excludeTitle []string // lowercased substrings
+}
+
+// albumFilterFromRequest reads the filter params; it returns nil when none is set.
+func albumFilterFromRequest(q url.Values) (*albumFilter, error) {
+	f := &albumFilter{excludeIDs: make(map[string]bool)}
+	set := false
+	for _, p := range []struct {
+		name string
+		dst  *int
+	}{{"since", &f.since}, {"until", &f.until}} {
+		s := strings.TrimSpace(q.Get(p.name))
+		if s == "" {
+			continue
+		}
+		t, _, ok := parseZoneramaDate(s, "en", time.UTC, time.Now())
+		if !ok {
+			return nil, fmt.Errorf("invalid %s date %q, use YYYY-MM-DD", p.name, s)
+		}
+		*p.dst = civilDate(t)
+		set = true
+	}
+	if f.since != 0 && f.until != 0 && f.since > f.until {
+		return nil, errors.New("since must not be after until")
+	}
+	if s := q.Get("title"); s != "" {
+		expr := "(?i)" + regexp.QuoteMeta(s)
+		if len(s) > 2 && strings.HasPrefix(s, "/") && strings.HasSuffix(s, "/") {
+			expr = "(?i)" + s[1:len(s)-1]
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid title pattern: %v", err)
+		}
+		f.title = re
+		set = true
+	}
+	if s := q.Get("min_photos"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid min_photos %q", s)
+		}
+		f.minPhotos = n
+		set = set || n > 0
+	}
+	for _, item := range strings.Split(q.Get("exclude"), ",") {
+		item = strings.TrimSpace(item)
+		switch {
+		case item == "":
+			continue
+		case numericRe.MatchString(item):
+			f.excludeIDs[item] = true
+		case strings.Contains(item, "zonerama.com"):
+			c, err := resolveZoneramaLink(item)
+			if err != nil || c.AlbumID == "" {
+				return nil, fmt.Errorf("exclude: %q is not an album link", item)
+			}
+			f.excludeIDs[c.AlbumID] = true
+		default:
+			f.excludeTitle = append(f.excludeTitle, strings.ToLower(item))
+		}
+		set = true
+	}
+	if !set {
+		return nil, nil
+	}
+	return f, nil
+}
+
+// civilDate turns t into a comparable yyyymmdd in its own zone.
+func civilDate(t time.Time) int {
+	return t.Year()*10000 + int(t.Month())*100 + t.Day()
+}
+
+// match reports whether a tile passes. Tiles without a parseable date fail
+// date filters, and tiles without a photo count fail min_photos.
+func (f *albumFilter) match(id, title string, date time.Time, hasDate bool, photos int) bool {
+	if f == nil {
+		return true
+	}
+	if f.since != 0 || f.until != 0 {
+		if !hasDate {
+			return false
+		}
+		// A range matches when its start date does
+		d := civilDate(date)
+		if (f.since != 0 && d < f.since) || (f.until != 0 && d > f.until) {
+			return false
+		}
+	}
+	if f.title != nil && !f.title.MatchString(title) {
+		return false
+	}
+	if photos < f.minPhotos {
+		return false
+	}
+	if f.excludeIDs[id] {
+		return false
+	}
+	lower := strings.ToLower(title)
+	for _, s := range f.excludeTitle {
+		if strings.Contains(lower, s) {
+			return false
+		}
+	}
+	return true
+}
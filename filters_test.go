@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestAlbumFilterFromRequest(t *testing.T) {
+	tests := []struct {
+		query string
+		isNil bool
+		err   bool
+	}{
+		{"", true, false},
+		{"min_photos=0", true, false},
+		{"since=2025-08-01&until=2025-08-31", false, false},
+		{"since=someday", false, true},
+		{"since=2025-09-01&until=2025-08-01", false, true},
+		{"title=/[/", false, true},
+		{"min_photos=-1", false, true},
+		{"exclude=https://eu.zonerama.com/Account", false, true},
+		{"exclude=13796374,https://eu.zonerama.com/Account/Album/13796675,turnaj", false, false},
+	}
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.query)
+		f, err := albumFilterFromRequest(q)
+		if (err != nil) != tt.err || (!tt.err && (f == nil) != tt.isNil) {
+			t.Errorf("%q: got filter %v, error %v", tt.query, f, err)
+		}
+	}
+}
+
+func TestAlbumFilterMatch(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2025, 8, d, 18, 0, 0, 0, time.FixedZone("CEST", 7200)) }
+	tests := []struct {
+		query   string
+		id      string
+		title   string
+		date    time.Time
+		hasDate bool
+		photos  int
+		want    bool
+	}{
+		{"since=2025-08-10", "1", "Zápas", day(10), true, 5, true},
+		{"since=2025-08-10", "1", "Zápas", day(9), true, 5, false},
+		{"until=2025-08-10", "1", "Zápas", day(10), true, 5, true},
+		{"until=2025-08-10", "1", "Zápas", day(11), true, 5, false},
+		// Tiles without a date fail date filters
+		{"since=2025-08-10", "1", "Zápas", time.Time{}, false, 5, false},
+		{"title=u14", "1", "Kategorie U14 FK Krnov", day(1), true, 5, true},
+		{"title=u15", "1", "Kategorie U14 FK Krnov", day(1), true, 5, false},
+		{"title=/^kategorie u1[45]/", "1", "Kategorie U14 FK Krnov", day(1), true, 5, true},
+		// Plain titles are not patterns
+		{"title=U1.", "1", "Kategorie U14", day(1), true, 5, false},
+		{"min_photos=10", "1", "Zápas", day(1), true, 9, false},
+		{"min_photos=10", "1", "Zápas", day(1), true, 10, true},
+		{"exclude=13796374", "13796374", "Zápas", day(1), true, 5, false},
+		{"exclude=https://eu.zonerama.com/Account/Album/13796675", "13796675", "Zápas", day(1), true, 5, false},
+		{"exclude=Turnaj", "1", "Velký turnaj", day(1), true, 5, false},
+		{"exclude=Turnaj", "1", "Zápas", day(1), true, 5, true},
+	}
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.query)
+		f, err := albumFilterFromRequest(q)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.query, err)
+		}
+		if got := f.match(tt.id, tt.title, tt.date, tt.hasDate, tt.photos); got != tt.want {
+			t.Errorf("%q on %s %q: got %v, want %v", tt.query, tt.id, tt.title, got, tt.want)
+		}
+	}
+	var none *albumFilter
+	if !none.match("1", "", time.Time{}, false, 0) {
+		t.Error("nil filter rejected a tile")
+	}
+}
+
+func TestAlbumFilterFixture(t *testing.T) {
+	_, loc := pageDateContext(loadFixture(t, "main.html"))
+	q, _ := url.ParseQuery("since=2025-09-01&min_photos=20")
+	f, err := albumFilterFromRequest(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept := 0
+	albums := fixtureAlbums(t, "snippet1.html")
+	for _, a := range albums {
+		d, _, ok := parseZoneramaDate(a.Date, "cs", loc, time.Now())
+		if f.match(a.ID, a.Title, d, ok, a.PhotosCnt) {
+			kept++
+			if civilDate(d) < 20250901 || a.PhotosCnt < 20 {
+				t.Errorf("%s (%s, %d photos) passed the filter", a.ID, a.Date, a.PhotosCnt)
+			}
+		}
+	}
+	if kept == 0 || kept == len(albums) {
+		t.Errorf("filter kept %d of %d tiles, want some", kept, len(albums))
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// loadFixture parses a page saved in other/.
+func loadFixture(t *testing.T, name string) *goquery.Document {
+	t.Helper()
+	f, err := os.Open(filepath.Join("other", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	doc, err := goquery.NewDocumentFromReader(f)
+	if err != nil {
+		t.Fatalf("parsing %s: %v", name, err)
+	}
+	return doc
+}
+
+// fixtureAlbums reads the album tiles of a saved profile page with parseTiles,
+// as parseProfile does, resolving relative links against eu.zonerama.com.
+func fixtureAlbums(t *testing.T, name string) []Album {
+	t.Helper()
+	sels := currentSelectors()
+	page := "https://eu.zonerama.com/Fixture/1"
+	join := func(u string) string { return "https://eu.zonerama.com" + u }
+	var albums []Album
+	for _, tile := range parseTiles(sels, loadFixture(t, name).Selection, page, join, newParseTrace(sels)) {
+		albums = append(albums, Album{ID: tile.ID, URL: tile.URL, Title: tile.Title, Cover: tile.Cover, Date: tile.Date, PhotosCnt: tile.PhotosCnt, ViewsCnt: tile.ViewsCnt})
+	}
+	if len(albums) == 0 {
+		t.Fatalf("%s: no album tiles", name)
+	}
+	return albums
+}
+
+// fixturePhotos reads the photo IDs of a saved album gallery.
+func fixturePhotos(t *testing.T, name string) []Photo {
+	t.Helper()
+	items, rule := firstMatch(currentSelectors().Album.Photos, loadFixture(t, name).Selection)
+	var photos []Photo
+	items.Each(func(i int, s *goquery.Selection) {
+		photos = append(photos, Photo{ID: rule.read(s)})
+	})
+	if len(photos) == 0 {
+		t.Fatalf("%s: no gallery items", name)
+	}
+	return photos
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/chromedp/cdproto/dom"
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+	"github.com/geziyor/geziyor"
+	"github.com/geziyor/geziyor/client"
+	"github.com/geziyor/geziyor/middleware"
+)
+
+// Hosts we are willing to crawl. ZONERAMA_ALLOWED_HOSTS (comma-separated)
+// replaces the default list, e.g. when Zonerama adds a regional subdomain.
+var allowedHosts = loadAllowedHosts()
+
+func loadAllowedHosts() map[string]bool {
+	hosts := make(map[string]bool)
+	for _, h := range strings.Split(envString("ZONERAMA_ALLOWED_HOSTS", "zonerama.com,www.zonerama.com,eu.zonerama.com,us.zonerama.com"), ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			hosts[h] = true
+		}
+	}
+	return hosts
+}
+
+// validateZoneramaURL parses raw and checks it is safe to hand to the crawler:
+// http(s) only, no userinfo, no IP literals, default ports only and an allowlisted host.
+func validateZoneramaURL(raw string) (*url.URL, error) {
+	u, host, err := parseLinkURL(raw)
+	if err != nil {
+		return nil, err
+	}
+	if !allowedHosts[host] {
+		return nil, fmt.Errorf("link must point to zonerama.com (host %q is not allowed)", host)
+	}
+	return u, nil
+}
+
+// parseLinkURL does the host-independent checks and returns the normalized hostname.
+func parseLinkURL(raw string) (*url.URL, string, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, "", errors.New("invalid link URL")
+	}
+	if u.User != nil {
+		return nil, "", errors.New("link must not contain credentials")
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if net.ParseIP(host) != nil {
+		return nil, "", errors.New("link must not point to an IP address")
+	}
+	if p := u.Port(); p != "" && !(u.Scheme == "http" && p == "80") && !(u.Scheme == "https" && p == "443") {
+		return nil, "", errors.New("link must not use a non-default port")
+	}
+	return u, host, nil
+}
+
+// checkZoneramaRedirect is the http.Client CheckRedirect for crawls: it stops
+// redirects that leave the allowlist, and keeps Go's default limit of 10 hops.
+func checkZoneramaRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	if _, err := validateZoneramaURL(req.URL.String()); err != nil {
+		return fmt.Errorf("blocked redirect to %s: %w", req.URL.Redacted(), err)
+	}
+	return nil
+}
+
+// crawlTransport, when set, replaces the HTTP transport of every crawler. Tests
+// use it to answer for zonerama.com without touching the network.
+var crawlTransport http.RoundTripper
+
+// newCrawler builds a crawler with the host guard in place: plain requests get
+// the redirect check (and crawlTransport), rendered ones the guarded Chrome
+// actions below.
+func newCrawler(opt *geziyor.Options) *geziyor.Geziyor {
+	opt.PreActions = renderPreActions
+	opt.ResponseMiddlewares = append([]middleware.ResponseProcessor{renderedPages{}}, opt.ResponseMiddlewares...)
+	gz := geziyor.NewGeziyor(opt)
+	gz.Client.CheckRedirect = checkZoneramaRedirect
+	if crawlTransport != nil {
+		gz.Client.Transport = crawlTransport
+	}
+	return gz
+}
+
+// Chrome follows redirects and script navigations on its own, so rendered
+// requests replace geziyor's default actions. renderPreActions pause every
+// document request (the page, each redirect hop, frames) in the Fetch domain
+// and fail the ones leaving the allowlist before Chrome sends them; the
+// navigation itself comes from the request's Actions, see newCrawlRequest.
+// Scripts, styles and images are not intercepted.
+var renderPreActions = []chromedp.Action{
+	network.Enable(),
+	chromedp.ActionFunc(func(ctx context.Context) error {
+		chromedp.ListenTarget(ctx, func(ev any) {
+			e, ok := ev.(*fetch.EventRequestPaused)
+			if !ok {
+				return
+			}
+			// Handlers must not block the event loop, and the reply is a command
+			go func() {
+				var err error
+				if _, verr := validateZoneramaURL(e.Request.URL); verr != nil {
+					log.Printf("hostguard: blocking rendered navigation: %v", verr)
+					err = fetch.FailRequest(e.RequestID, network.ErrorReasonBlockedByClient).Do(ctx)
+				} else {
+					err = fetch.ContinueRequest(e.RequestID).Do(ctx)
+				}
+				if err != nil && ctx.Err() == nil {
+					log.Printf("hostguard: answering paused request: %v", err)
+				}
+			}()
+		})
+		return nil
+	}),
+	fetch.Enable().WithPatterns([]*fetch.RequestPattern{{URLPattern: "*", ResourceType: network.ResourceTypeDocument}}),
+}
+
+// metaRenderedPage holds the *renderedPage a rendered request fills in.
+const metaRenderedPage = "rendered_page"
+
+// renderedPage is what the actions of a rendered request captured: the main
+// document's response and the page's HTML once it is ready.
+type renderedPage struct {
+	mu   sync.Mutex // the response listener runs on chromedp's goroutine
+	res  *network.Response
+	body string
+}
+
+// newCrawlRequest builds a GET for a crawler made by newCrawler. Rendered
+// requests carry the navigation that geziyor's replaced default actions did.
+func newCrawlRequest(u string, rendered bool) (*client.Request, error) {
+	req, err := client.NewRequest("GET", u, nil)
+	if err != nil || !rendered {
+		return req, err
+	}
+	page := &renderedPage{}
+	req.Rendered = true
+	req.Meta[metaRenderedPage] = page
+	req.Actions = []chromedp.Action{
+		// Headers are read late, after the request middlewares set them
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			return network.SetExtraHTTPHeaders(network.Headers(client.ConvertHeaderToMap(req.Header))).Do(ctx)
+		}),
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			chromedp.ListenTarget(ctx, func(ev any) {
+				if e, ok := ev.(*network.EventResponseReceived); ok && e.Type == network.ResourceTypeDocument {
+					page.mu.Lock()
+					if page.res == nil {
+						page.res = e.Response
+					}
+					page.mu.Unlock()
+				}
+			})
+			return nil
+		}),
+		chromedp.Navigate(u),
+		chromedp.WaitReady(":root"),
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			node, err := dom.GetDocument().Do(ctx)
+			if err != nil {
+				return err
+			}
+			page.body, err = dom.GetOuterHTML().WithNodeID(node.NodeID).Do(ctx)
+			return err
+		}),
+	}
+	return req, nil
+}
+
+// getCrawl queues a GET built by newCrawlRequest.
+func getCrawl(g *geziyor.Geziyor, u string, rendered bool, cb func(*geziyor.Geziyor, *client.Response)) {
+	req, err := newCrawlRequest(u, rendered)
+	if err != nil {
+		log.Printf("crawl: request %s: %v", u, err)
+		return
+	}
+	g.Do(req, cb)
+}
+
+// renderedPages moves what a rendered request captured into its response, the
+// way geziyor's default actions would have. It runs first among the response
+// middlewares, after geziyor's HTML parsing saw an empty body.
+type renderedPages struct{}
+
+// ProcessResponse implements middleware.ResponseProcessor.
+func (renderedPages) ProcessResponse(cr *client.Response) {
+	page, ok := cr.Request.Meta[metaRenderedPage].(*renderedPage)
+	if !ok {
+		return
+	}
+	page.mu.Lock()
+	defer page.mu.Unlock()
+	cr.Body = []byte(page.body)
+	if page.res != nil {
+		cr.StatusCode = int(page.res.Status)
+		cr.Proto = page.res.Protocol
+		cr.Header = client.ConvertMapToHeader(page.res.Headers)
+		if u, err := url.Parse(page.res.URL); err == nil {
+			cr.Request.URL = u
+		}
+	}
+	if doc, err := goquery.NewDocumentFromReader(bytes.NewReader(cr.Body)); err == nil {
+		cr.HTMLDoc = doc
+	}
+}
+
+// responseAllowed reports whether a response still comes from an allowed host.
+// Rendered navigations are already stopped by renderPreActions; this is the
+// last check before a page is parsed.
+func responseAllowed(cr *client.Response) bool {
+	if cr == nil || cr.Request == nil || cr.Request.URL == nil {
+		return false
+	}
+	if _, err := validateZoneramaURL(cr.Request.URL.String()); err != nil {
+		log.Printf("hostguard: dropping response from %s: %v", cr.Request.URL.Redacted(), err)
+		return false
+	}
+	return true
+}
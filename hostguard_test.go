@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/geziyor/geziyor/client"
+)
+
+func TestValidateZoneramaURL(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string // error substring, "" when allowed
+	}{
+		{"https://eu.zonerama.com/Account/Album/1", ""},
+		{"http://zonerama.com/Account", ""},
+		{"  https://EU.Zonerama.com./Account  ", ""},
+		{"https://eu.zonerama.com:443/Account", ""},
+		{"ftp://eu.zonerama.com/Account", "invalid link URL"},
+		{"eu.zonerama.com/Account", "invalid link URL"},
+		{"https://user:pw@eu.zonerama.com/Account", "credentials"},
+		{"https://127.0.0.1/Account", "IP address"},
+		{"https://[::1]/Account", "IP address"},
+		{"https://eu.zonerama.com:8080/Account", "non-default port"},
+		{"http://eu.zonerama.com:443/Account", "non-default port"},
+		{"https://zonerama.com.evil.example/Account", "not allowed"},
+		{"https://evilzonerama.com/Account", "not allowed"},
+		{"https://metadata.google.internal/", "not allowed"},
+	}
+	for _, tt := range tests {
+		_, err := validateZoneramaURL(tt.raw)
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%q: unexpected error %v", tt.raw, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%q: got error %v, want %q", tt.raw, err, tt.want)
+		}
+	}
+}
+
+func TestCheckZoneramaRedirect(t *testing.T) {
+	req := func(raw string) *http.Request {
+		u, _ := url.Parse(raw)
+		return &http.Request{URL: u}
+	}
+	via := []*http.Request{req("https://eu.zonerama.com/Account")}
+	if err := checkZoneramaRedirect(req("https://eu.zonerama.com/Account/1"), via); err != nil {
+		t.Errorf("redirect within zonerama.com: %v", err)
+	}
+	for _, raw := range []string{"http://169.254.169.254/latest/meta-data", "https://example.com/", "https://eu.zonerama.com:8443/"} {
+		if err := checkZoneramaRedirect(req(raw), via); err == nil || !strings.Contains(err.Error(), "blocked redirect") {
+			t.Errorf("redirect to %s: got %v", raw, err)
+		}
+	}
+	long := make([]*http.Request, 10)
+	if err := checkZoneramaRedirect(req("https://eu.zonerama.com/Account"), long); err == nil {
+		t.Error("11th redirect was followed")
+	}
+}
+
+func TestResponseAllowed(t *testing.T) {
+	resp := func(raw string) *client.Response {
+		r, _ := client.NewRequest("GET", raw, nil)
+		return &client.Response{Request: r}
+	}
+	if !responseAllowed(resp("https://eu.zonerama.com/Account")) {
+		t.Error("zonerama.com response was dropped")
+	}
+	if responseAllowed(resp("https://example.com/")) {
+		t.Error("off-allowlist response was kept")
+	}
+	if responseAllowed(nil) || responseAllowed(&client.Response{}) {
+		t.Error("response without a request was kept")
+	}
+}
+
+func TestNewCrawlRequest(t *testing.T) {
+	req, err := newCrawlRequest("https://eu.zonerama.com/Account", false)
+	if err != nil || req.Rendered || len(req.Actions) != 0 || req.Meta[metaRenderedPage] != nil {
+		t.Fatalf("plain request: %+v, %v", req, err)
+	}
+	req, err = newCrawlRequest("https://eu.zonerama.com/Account", true)
+	if err != nil || !req.Rendered || len(req.Actions) == 0 {
+		t.Fatalf("rendered request: %+v, %v", req, err)
+	}
+	if _, ok := req.Meta[metaRenderedPage].(*renderedPage); !ok {
+		t.Error("rendered request has nowhere to put the page")
+	}
+}
+
+func TestRenderedPages(t *testing.T) {
+	req, _ := newCrawlRequest("https://eu.zonerama.com/Account", true)
+	page := req.Meta[metaRenderedPage].(*renderedPage)
+	page.body = `<html><body><h1>Account</h1></body></html>`
+	page.res = &network.Response{
+		URL:      "https://eu.zonerama.com/Account/1",
+		Status:   200,
+		Protocol: "h2",
+		Headers:  network.Headers{"Content-Type": "text/html; charset=utf-8"},
+	}
+	cr := &client.Response{Response: &http.Response{Request: req.Request}, Request: req}
+	renderedPages{}.ProcessResponse(cr)
+	if cr.StatusCode != 200 || cr.Header.Get("Content-Type") == "" || cr.Request.URL.Path != "/Account/1" {
+		t.Errorf("got status %d, header %v, URL %s", cr.StatusCode, cr.Header, cr.Request.URL)
+	}
+	if cr.HTMLDoc == nil || cr.HTMLDoc.Find("h1").Text() != "Account" {
+		t.Error("rendered page was not parsed")
+	}
+
+	// Plain responses are left alone
+	plain, _ := newCrawlRequest("https://eu.zonerama.com/Account", false)
+	cr = &client.Response{Response: &http.Response{StatusCode: 404}, Request: plain, Body: []byte("x")}
+	renderedPages{}.ProcessResponse(cr)
+	if cr.StatusCode != 404 || string(cr.Body) != "x" || cr.HTMLDoc != nil {
+		t.Errorf("plain response changed: %+v", cr)
+	}
+}
@@ -0,0 +1,508 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HugoSmits86/nativewebp"
+	"golang.org/x/image/draw"
+)
+
+// The renditions Zonerama keeps for every photo, as long x short edge boxes.
+// /img/ fetches the smallest one that still covers the requested size.
+var imgPyramid = [][2]int{{750, 500}, {1500, 1000}, {3000, 2000}}
+
+// Bounds for /img/ parameters and upstream downloads.
+const (
+	maxImgUpstreamBytes = 32 << 20
+	defaultImgQuality   = 82
+)
+
+var (
+	imgMaxEdge     = envInt("ZONERAMA_IMG_MAX_EDGE", 3000)
+	imgConcurrency = envInt("ZONERAMA_IMG_CONCURRENCY", runtime.NumCPU())
+	imgCacheDir    = envString("ZONERAMA_IMG_CACHE_DIR", filepath.Join(os.TempDir(), "zonerama-img"))
+	imgCacheBytes  = int64(envInt("ZONERAMA_IMG_CACHE_MB", 512)) << 20
+
+	imgHTTPClient = &http.Client{Timeout: 30 * time.Second, CheckRedirect: checkZoneramaRedirect}
+	imgSlots      = make(chan struct{}, max(imgConcurrency, 1))
+	imgIDRe       = regexp.MustCompile(`^\d+$`)
+
+	imgCache   = newImgCache(imgCacheDir, imgCacheBytes)
+	imgFlights = &imgFlightGroup{calls: make(map[string]*imgFlight)}
+)
+
+// imgRequest is a parsed /img/{photoID} request.
+type imgRequest struct {
+	ID      string
+	Width   int    // 0 = follow the aspect ratio
+	Height  int    // 0 = follow the aspect ratio
+	Fit     string // contain, cover or fill
+	Format  string // jpeg, webp or png
+	Quality int    // JPEG only
+}
+
+// parseImgRequest reads the photo ID from the path and w, h, fit, format and q.
+func parseImgRequest(r *http.Request) (*imgRequest, error) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/img/"), "/")
+	if !imgIDRe.MatchString(id) {
+		return nil, errors.New("photo ID must be numeric, e.g. /img/123456789")
+	}
+	q := r.URL.Query()
+	req := &imgRequest{ID: id, Fit: "contain", Format: "jpeg", Quality: defaultImgQuality}
+	for _, p := range []struct {
+		name string
+		dst  *int
+		max  int
+	}{{"w", &req.Width, imgMaxEdge}, {"h", &req.Height, imgMaxEdge}, {"q", &req.Quality, 100}} {
+		s := strings.TrimSpace(q.Get(p.name))
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > p.max {
+			return nil, fmt.Errorf("invalid %s %q, use 1-%d", p.name, s, p.max)
+		}
+		*p.dst = n
+	}
+	if s := strings.ToLower(strings.TrimSpace(q.Get("fit"))); s != "" {
+		if s != "contain" && s != "cover" && s != "fill" {
+			return nil, fmt.Errorf("invalid fit %q, use contain, cover or fill", s)
+		}
+		req.Fit = s
+	}
+	switch s := strings.ToLower(strings.TrimSpace(q.Get("format"))); s {
+	case "":
+	case "jpeg", "jpg":
+		req.Format = "jpeg"
+	case "webp", "png":
+		req.Format = s
+	default:
+		return nil, fmt.Errorf("invalid format %q, use jpeg, webp or png", s)
+	}
+	if req.Format != "jpeg" {
+		req.Quality = 0
+	}
+	if req.Width == 0 && req.Height == 0 {
+		req.Width = 1500
+		req.Height = 1500
+	}
+	return req, nil
+}
+
+// key names the rendition in the cache; it doubles as the ETag, since a photo
+// ID always points at the same picture.
+func (req *imgRequest) key() string {
+	sum := sha256.Sum256(fmt.Appendf(nil, "%s|%d|%d|%s|%s|%d", req.ID, req.Width, req.Height, req.Fit, req.Format, req.Quality))
+	return hex.EncodeToString(sum[:16])
+}
+
+func (req *imgRequest) contentType() string {
+	return "image/" + req.Format
+}
+
+// sourceEdge is the long edge the source should have so no upscaling is needed.
+// Zonerama photos are mostly 3:2, which is all cover can assume before download.
+func (req *imgRequest) sourceEdge() int {
+	e := max(req.Width, req.Height)
+	if req.Fit == "cover" && req.Width > 0 && req.Height > 0 {
+		e = max(e, min(req.Width, req.Height)*3/2)
+	}
+	return e
+}
+
+// imgHandler serves GET /img/{photoID}: a resized copy of a Zonerama photo,
+// cached on disk. Cache hits skip the per-client rate limit, so pages can
+// embed many images; misses count against it.
+func imgHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeJSONError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	req, err := parseImgRequest(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	key := req.key()
+	etag := `"` + key + `"`
+	h := w.Header()
+	h.Set("Access-Control-Allow-Origin", "*")
+	h.Set("Cache-Control", "public, max-age=31536000, immutable")
+	h.Set("ETag", etag)
+	h.Set("Content-Type", req.contentType())
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" && (inm == "*" || strings.Contains(inm, etag)) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	name, ok := imgCache.get(key)
+	if !ok {
+		if allowed, wait := clientLimits.allow(clientIP(r), time.Now()); !allowed {
+			h.Del("Cache-Control")
+			h.Del("ETag")
+			h.Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeJSONError(w, http.StatusTooManyRequests, "too many requests from this client")
+			return
+		}
+		name, err = imgFlights.do(r.Context(), key, func() (string, error) {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+			defer cancel()
+			return renderImg(ctx, req)
+		})
+		if err != nil {
+			if r.Context().Err() != nil {
+				return
+			}
+			h.Del("Cache-Control")
+			h.Del("ETag")
+			status := http.StatusBadGateway
+			var ue *imgUpstreamError
+			if errors.As(err, &ue) && ue.status == http.StatusNotFound {
+				status = http.StatusNotFound
+			}
+			log.Printf("img: %s: %v", req.ID, err)
+			writeJSONError(w, status, err.Error())
+			return
+		}
+		h.Set("X-Cache", "MISS")
+	} else {
+		h.Set("X-Cache", "HIT")
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		// Evicted between lookup and open; the next request renders it again
+		h.Del("Cache-Control")
+		h.Del("ETag")
+		writeJSONError(w, http.StatusServiceUnavailable, "image cache busy, retry")
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	http.ServeContent(w, r, "", fi.ModTime(), f)
+}
+
+// renderImg downloads the source rendition, resizes and encodes it, and stores
+// the result in the cache. It returns the cached file's path.
+func renderImg(ctx context.Context, req *imgRequest) (string, error) {
+	select {
+	case imgSlots <- struct{}{}:
+		defer func() { <-imgSlots }()
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	src, err := fetchImgSource(ctx, req.ID, req.sourceEdge())
+	if err != nil {
+		return "", err
+	}
+	dst := resizeImg(src, req.Width, req.Height, req.Fit)
+
+	var buf bytes.Buffer
+	switch req.Format {
+	case "png":
+		err = png.Encode(&buf, dst)
+	case "webp":
+		err = nativewebp.Encode(&buf, dst, nil)
+	default:
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: req.Quality})
+	}
+	if err != nil {
+		return "", fmt.Errorf("encoding %s: %w", req.Format, err)
+	}
+	return imgCache.put(req.key(), buf.Bytes())
+}
+
+// imgUpstreamError is a non-200 answer from Zonerama.
+type imgUpstreamError struct {
+	url    string
+	status int
+}
+
+func (e *imgUpstreamError) Error() string {
+	return fmt.Sprintf("fetching %s: status %d", e.url, e.status)
+}
+
+// fetchImgSource decodes the smallest pyramid level whose long edge reaches edge,
+// falling back to smaller levels when a large one is missing.
+func fetchImgSource(ctx context.Context, id string, edge int) (image.Image, error) {
+	level := len(imgPyramid) - 1
+	for i, l := range imgPyramid {
+		if l[0] >= edge {
+			level = i
+			break
+		}
+	}
+	var err error
+	for ; level >= 0; level-- {
+		var img image.Image
+		l := imgPyramid[level]
+		img, err = fetchImg(ctx, fmt.Sprintf("https://%s/photos/%s_%dx%d.jpg", defaultZoneramaHost, id, l[0], l[1]))
+		if err == nil {
+			return img, nil
+		}
+		var ue *imgUpstreamError
+		if !errors.As(err, &ue) || ue.status != http.StatusNotFound {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+func fetchImg(ctx context.Context, imageURL string) (image.Image, error) {
+	u, err := validateZoneramaURL(imageURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := politeHosts.wait(ctx, u.Host); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := imgHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &imgUpstreamError{url: u.Redacted(), status: resp.StatusCode}
+	}
+	img, _, err := image.Decode(io.LimitReader(resp.Body, maxImgUpstreamBytes))
+	if err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", u.Redacted(), err)
+	}
+	return img, nil
+}
+
+// resizeImg scales src into a width x height box. contain keeps the aspect ratio
+// inside the box, cover fills the box and crops the centre, fill stretches.
+// A zero width or height follows the aspect ratio. Images are never upscaled.
+func resizeImg(src image.Image, width, height int, fit string) image.Image {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	if sw == 0 || sh == 0 {
+		return src
+	}
+	if width == 0 || height == 0 {
+		fit = "contain"
+	}
+	scale := func(w, h int) float64 {
+		switch {
+		case w == 0:
+			return float64(h) / float64(sh)
+		case h == 0:
+			return float64(w) / float64(sw)
+		}
+		return math.Min(float64(w)/float64(sw), float64(h)/float64(sh))
+	}
+	crop := b
+	var dw, dh int
+	switch fit {
+	case "fill":
+		dw, dh = min(width, sw), min(height, sh)
+	case "cover":
+		f := math.Max(float64(width)/float64(sw), float64(height)/float64(sh))
+		if f > 1 {
+			// Keep the box's aspect ratio but shrink it to what the source can fill
+			width = int(math.Round(float64(width) / f))
+			height = int(math.Round(float64(height) / f))
+			f = 1
+		}
+		cw := min(sw, int(math.Round(float64(width)/f)))
+		ch := min(sh, int(math.Round(float64(height)/f)))
+		x0, y0 := b.Min.X+(sw-cw)/2, b.Min.Y+(sh-ch)/2
+		crop = image.Rect(x0, y0, x0+cw, y0+ch)
+		dw, dh = width, height
+	default:
+		f := math.Min(scale(width, height), 1)
+		dw, dh = int(math.Round(float64(sw)*f)), int(math.Round(float64(sh)*f))
+	}
+	dw, dh = max(dw, 1), max(dh, 1)
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, crop, draw.Src, nil)
+	return dst
+}
+
+// imgCacheStore keeps rendered images on local disk and evicts the least recently
+// used ones once the total size passes max bytes. It is a cache in front of
+// Zonerama, not an objectStore: every instance keeps its own, even with S3.
+type imgCacheStore struct {
+	dir   string
+	max   int64
+	mu    sync.Mutex
+	lru   *list.List // of *imgCacheEntry, most recently used first
+	items map[string]*list.Element
+	size  int64
+}
+
+type imgCacheEntry struct {
+	key  string
+	size int64
+}
+
+// newImgCache indexes files already in dir, oldest modification time least recent.
+func newImgCache(dir string, maxBytes int64) *imgCacheStore {
+	c := &imgCacheStore{dir: dir, max: maxBytes, lru: list.New(), items: make(map[string]*list.Element)}
+	type found struct {
+		key  string
+		size int64
+		mod  time.Time
+	}
+	var files []found
+	_ = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		if fi, err := d.Info(); err == nil {
+			files = append(files, found{key: d.Name(), size: fi.Size(), mod: fi.ModTime()})
+		}
+		return nil
+	})
+	sort.Slice(files, func(i, j int) bool { return files[i].mod.Before(files[j].mod) })
+	for _, f := range files {
+		c.items[f.key] = c.lru.PushFront(&imgCacheEntry{key: f.key, size: f.size})
+		c.size += f.size
+	}
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+	return c
+}
+
+// path shards files by the first two hex digits of the key.
+func (c *imgCacheStore) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key)
+}
+
+// get returns the file for key and marks it recently used. The modification
+// time is bumped too, so the order survives a restart.
+func (c *imgCacheStore) get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return "", false
+	}
+	c.lru.MoveToFront(e)
+	p := c.path(key)
+	now := time.Now()
+	_ = os.Chtimes(p, now, now)
+	return p, true
+}
+
+// put writes data under key and evicts until the cache fits again.
+func (c *imgCacheStore) put(key string, data []byte) (string, error) {
+	p := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".img-*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.size -= e.Value.(*imgCacheEntry).size
+		c.lru.Remove(e)
+	}
+	c.items[key] = c.lru.PushFront(&imgCacheEntry{key: key, size: int64(len(data))})
+	c.size += int64(len(data))
+	c.evict()
+	return p, nil
+}
+
+// evict drops least recently used files, always keeping the newest one. c.mu must be held.
+func (c *imgCacheStore) evict() {
+	for c.size > c.max && c.lru.Len() > 1 {
+		e := c.lru.Back()
+		ent := e.Value.(*imgCacheEntry)
+		c.lru.Remove(e)
+		delete(c.items, ent.key)
+		c.size -= ent.size
+		if err := os.Remove(c.path(ent.key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("img: evicting %s: %v", ent.key, err)
+		}
+	}
+}
+
+// imgFlightGroup makes concurrent requests for the same rendition share one render.
+type imgFlightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*imgFlight
+}
+
+type imgFlight struct {
+	done chan struct{}
+	path string
+	err  error
+}
+
+// do runs fn once per key at a time; the render is detached from any single
+// caller's context so one client hanging up does not fail the others.
+func (g *imgFlightGroup) do(ctx context.Context, key string, fn func() (string, error)) (string, error) {
+	g.mu.Lock()
+	f, ok := g.calls[key]
+	if !ok {
+		f = &imgFlight{done: make(chan struct{})}
+		g.calls[key] = f
+		go func() {
+			f.path, f.err = fn()
+			g.mu.Lock()
+			delete(g.calls, key)
+			g.mu.Unlock()
+			close(f.done)
+		}()
+	}
+	g.mu.Unlock()
+	select {
+	case <-f.done:
+		return f.path, f.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
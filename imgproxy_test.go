@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseImgRequest(t *testing.T) {
+	tests := []struct {
+		target string
+		want   imgRequest
+		err    string
+	}{
+		{"/img/123", imgRequest{ID: "123", Width: 1500, Height: 1500, Fit: "contain", Format: "jpeg", Quality: defaultImgQuality}, ""},
+		{"/img/123/?w=400&fit=COVER&format=jpg&q=60", imgRequest{ID: "123", Width: 400, Fit: "cover", Format: "jpeg", Quality: 60}, ""},
+		// Quality only applies to JPEG
+		{"/img/123?h=300&format=webp&q=60", imgRequest{ID: "123", Height: 300, Fit: "contain", Format: "webp"}, ""},
+		{"/img/abc", imgRequest{}, "must be numeric"},
+		{"/img/123?w=0", imgRequest{}, "invalid w"},
+		{"/img/123?h=99999", imgRequest{}, "invalid h"},
+		{"/img/123?q=101", imgRequest{}, "invalid q"},
+		{"/img/123?fit=stretch", imgRequest{}, "invalid fit"},
+		{"/img/123?format=gif", imgRequest{}, "invalid format"},
+	}
+	for _, tt := range tests {
+		got, err := parseImgRequest(httptest.NewRequest("GET", tt.target, nil))
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: got error %v, want %q", tt.target, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || *got != tt.want {
+			t.Errorf("%s: got %+v, %v, want %+v", tt.target, got, err, tt.want)
+		}
+	}
+}
+
+func TestImgSourceEdge(t *testing.T) {
+	tests := []struct {
+		req  imgRequest
+		want int
+	}{
+		{imgRequest{Width: 400, Height: 300, Fit: "contain"}, 400},
+		{imgRequest{Height: 700, Fit: "contain"}, 700},
+		// A 3:2 source must be wider than the box to cover its height
+		{imgRequest{Width: 400, Height: 400, Fit: "cover"}, 600},
+		{imgRequest{Width: 900, Height: 300, Fit: "cover"}, 900},
+	}
+	for _, tt := range tests {
+		if got := tt.req.sourceEdge(); got != tt.want {
+			t.Errorf("%+v: got %d, want %d", tt.req, got, tt.want)
+		}
+	}
+	a := imgRequest{ID: "1", Width: 400, Fit: "contain", Format: "jpeg", Quality: 80}
+	b := a
+	b.Quality = 81
+	if a.key() == b.key() {
+		t.Error("different renditions share a cache key")
+	}
+}
+
+func TestResizeImg(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 600, 400))
+	tests := []struct {
+		w, h  int
+		fit   string
+		wantW int
+		wantH int
+	}{
+		{300, 300, "contain", 300, 200},
+		{0, 100, "contain", 150, 100},
+		{300, 300, "cover", 300, 300},
+		{300, 300, "fill", 300, 300},
+		// Never upscaled
+		{1200, 1200, "contain", 600, 400},
+		{1200, 1200, "fill", 600, 400},
+		// cover keeps the box's shape at the largest size the source can fill
+		{800, 800, "cover", 400, 400},
+		// A missing edge makes cover behave like contain
+		{300, 0, "cover", 300, 200},
+	}
+	for _, tt := range tests {
+		b := resizeImg(src, tt.w, tt.h, tt.fit).Bounds()
+		if b.Dx() != tt.wantW || b.Dy() != tt.wantH {
+			t.Errorf("%dx%d %s: got %dx%d, want %dx%d", tt.w, tt.h, tt.fit, b.Dx(), b.Dy(), tt.wantW, tt.wantH)
+		}
+	}
+}
+
+func TestImgCacheEvicts(t *testing.T) {
+	dir := t.TempDir()
+	c := newImgCache(dir, 10)
+	key := func(c byte) string { return strings.Repeat(string(c), 32) }
+	for _, k := range []string{key('a'), key('b')} {
+		if _, err := c.put(k, []byte("12345")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.get(key('a')) // b is now the least recently used
+	if _, err := c.put(key('c'), []byte("12345")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.get(key('b')); ok {
+		t.Error("least recently used entry was kept")
+	}
+	if _, err := os.Stat(c.path(key('b'))); !os.IsNotExist(err) {
+		t.Errorf("evicted file still on disk: %v", err)
+	}
+	for _, k := range []string{key('a'), key('c')} {
+		if _, ok := c.get(k); !ok {
+			t.Errorf("%s was evicted", k[:1])
+		}
+	}
+
+	// A restart picks up what is on disk, skipping temp files
+	os.WriteFile(filepath.Join(dir, key('a')[:2], ".img-partial"), []byte("x"), 0o644)
+	c = newImgCache(dir, 10)
+	if c.size != 10 || c.lru.Len() != 2 {
+		t.Errorf("reloaded %d entries, %d bytes", c.lru.Len(), c.size)
+	}
+}
+
+func TestImgFlightGroup(t *testing.T) {
+	g := &imgFlightGroup{calls: make(map[string]*imgFlight)}
+	var runs atomic.Int32
+	release := make(chan struct{})
+	fn := func() (string, error) {
+		runs.Add(1)
+		<-release
+		return "path", nil
+	}
+
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if p, err := g.do(context.Background(), "k", fn); p != "path" || err != nil {
+				t.Errorf("got %q, %v", p, err)
+			}
+		}()
+	}
+	// A caller that gives up does not cancel the shared render
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := g.do(ctx, "k", fn); err != context.Canceled {
+		t.Errorf("cancelled caller: got %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if n := runs.Load(); n != 1 {
+		t.Errorf("rendered %d times, want 1", n)
+	}
+}
+
+// imgTransport serves a 750x500 JPEG for the smallest rendition and 404 for the rest.
+type imgTransport struct {
+	fetched atomic.Int32
+}
+
+func (f *imgTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	f.fetched.Add(1)
+	rec := httptest.NewRecorder()
+	if !strings.HasSuffix(r.URL.Path, "_750x500.jpg") {
+		rec.WriteHeader(http.StatusNotFound)
+	} else {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 750, 500)), nil); err != nil {
+			return nil, err
+		}
+		rec.Header().Set("Content-Type", "image/jpeg")
+		rec.Write(buf.Bytes())
+	}
+	res := rec.Result()
+	res.Request = r
+	return res, nil
+}
+
+func TestImgHandler(t *testing.T) {
+	ft := &imgTransport{}
+	oldClient, oldCache := imgHTTPClient, imgCache
+	imgHTTPClient = &http.Client{Transport: ft, CheckRedirect: checkZoneramaRedirect}
+	imgCache = newImgCache(t.TempDir(), 1<<20)
+	t.Cleanup(func() { imgHTTPClient, imgCache = oldClient, oldCache })
+
+	get := func(target string, header ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", target, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		imgHandler(w, r)
+		return w
+	}
+
+	// 1000 px needs the 1500 rendition; it is missing, so /img/ falls back to 750
+	w := get("/img/42?w=1000&format=png")
+	if w.Code != http.StatusOK || w.Header().Get("X-Cache") != "MISS" || w.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("first request: got %d %v: %s", w.Code, w.Header(), w.Body.String())
+	}
+	img, _, err := image.Decode(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 750 || b.Dy() != 500 {
+		t.Errorf("got %dx%d, want the 750x500 source unscaled", b.Dx(), b.Dy())
+	}
+	if n := ft.fetched.Load(); n != 2 {
+		t.Errorf("fetched %d renditions, want 2", n)
+	}
+
+	etag := w.Header().Get("ETag")
+	w = get("/img/42?w=1000&format=png")
+	if w.Code != http.StatusOK || w.Header().Get("X-Cache") != "HIT" || ft.fetched.Load() != 2 {
+		t.Errorf("second request: got %d, X-Cache %q, %d fetches", w.Code, w.Header().Get("X-Cache"), ft.fetched.Load())
+	}
+	if w = get("/img/42?w=1000&format=png", "If-None-Match", etag); w.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match: got %d", w.Code)
+	}
+
+	// Asking for 750 px goes straight to the smallest rendition
+	ft.fetched.Store(0)
+	if w = get("/img/42?w=300"); w.Code != http.StatusOK || ft.fetched.Load() != 1 {
+		t.Errorf("small request: got %d after %d fetches", w.Code, ft.fetched.Load())
+	}
+
+	if w = get("/img/42?w=0"); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid width: got %d", w.Code)
+	}
+	r := httptest.NewRequest("POST", "/img/42", nil)
+	w = httptest.NewRecorder()
+	imgHandler(w, r)
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") == "" {
+		t.Errorf("POST: got %d", w.Code)
+	}
+}
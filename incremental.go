@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// mode= values for /zonerama. Incremental compares the profile tiles with the
+// catalog and only renders album pages that are new or whose photo count changed.
+const (
+	modeFull        = "full"
+	modeIncremental = "incremental"
+)
+
+func parseModeParam(s string) (string, error) {
+	switch s = strings.ToLower(strings.TrimSpace(s)); s {
+	case "":
+		return modeFull, nil
+	case modeFull, modeIncremental:
+		return s, nil
+	}
+	return "", fmt.Errorf("invalid mode %q, use full or incremental", s)
+}
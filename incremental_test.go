@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestParseModeParam(t *testing.T) {
+	for in, want := range map[string]string{"": modeFull, "full": modeFull, " Incremental ": modeIncremental} {
+		if got, err := parseModeParam(in); err != nil || got != want {
+			t.Errorf("%q: got %q, %v", in, got, err)
+		}
+	}
+	if _, err := parseModeParam("delta"); err == nil {
+		t.Error("mode=delta was accepted")
+	}
+}
+
+func TestIncrementalScrape(t *testing.T) {
+	crawlTransport = &fixtureTransport{page: "snippet1.html", served: make(chan struct{})}
+	oldCatalog := catalog
+	t.Cleanup(func() { crawlTransport, catalog = nil, oldCatalog })
+
+	q := url.Values{
+		"link":           {"https://eu.zonerama.com/FKKofolaKrnov/1470757"},
+		"mode":           {"incremental"},
+		"album_limit":    {"0"},
+		"rendered":       {"false"},
+		"include_photos": {"false"},
+	}
+	catalog = nil
+	var se *scrapeError
+	if _, err := scrapeProfile(context.Background(), q, false); !errors.As(err, &se) || se.status != http.StatusBadRequest {
+		t.Fatalf("without a catalog: got %v", err)
+	}
+
+	// The catalog knows the clean.html albums, with their current photo counts
+	catalog = testCatalog(t)
+	full := fixtureAlbums(t, "snippet1.html")
+	var known []Album
+	for _, a := range fixtureAlbums(t, "clean.html") {
+		for _, f := range full {
+			if f.ID == a.ID {
+				known = append(known, f)
+			}
+		}
+	}
+	known[0].PhotosCnt++ // one album has changed since
+	if err := catalog.upsert(profileScrape(t, known), true, true); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := scrapeProfile(context.Background(), q, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unchanged, fetched := 0, map[string]bool{}
+	for _, a := range resp.Albums {
+		if a.Unchanged {
+			unchanged++
+		} else {
+			fetched[a.ID] = true
+		}
+	}
+	want := map[string]bool{known[0].ID: true, "13726013": true, "13774004": true, "13774084": true, "13796305": true}
+	if unchanged != len(known)-1 || len(fetched) != len(want) {
+		t.Fatalf("got %d unchanged albums and %v, want %d and %v", unchanged, fetched, len(known)-1, want)
+	}
+	for id := range want {
+		if !fetched[id] {
+			t.Errorf("album %s was not taken as new or changed", id)
+		}
+	}
+}
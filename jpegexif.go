@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// EXIF lives in the APP1 segment near the start of the file, so we only read this much.
+const jpegExifReadLimit = 256 << 10
+
+// exifHTTPClient fetches image bytes; redirects are held to the host allowlist.
+var exifHTTPClient = &http.Client{Timeout: 30 * time.Second, CheckRedirect: checkZoneramaRedirect}
+
+// fetchJPEGExif downloads the head of an image and decodes its EXIF block.
+// Zonerama's resized renditions may have EXIF stripped, in which case it returns nil.
+func fetchJPEGExif(ctx context.Context, imageURL string) (*Exif, error) {
+	u, err := validateZoneramaURL(imageURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := politeHosts.wait(ctx, u.Host); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", jpegExifReadLimit-1))
+	resp, err := exifHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("fetching %s: status %d", u.Redacted(), resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, jpegExifReadLimit))
+	if err != nil {
+		return nil, err
+	}
+	return decodeJPEGExif(data)
+}
+
+// decodeJPEGExif walks the JPEG markers up to the first APP1 "Exif" segment.
+func decodeJPEGExif(data []byte) (*Exif, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errors.New("not a JPEG")
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return nil, errors.New("corrupt JPEG marker")
+		}
+		marker := data[i+1]
+		if marker == 0xDA || marker == 0xD9 { // start of scan / end of image: no EXIF ahead
+			return nil, nil
+		}
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		if size < 2 || i+2+size > len(data) {
+			return nil, errors.New("truncated JPEG segment")
+		}
+		seg := data[i+4 : i+2+size]
+		if marker == 0xE1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+			return decodeTIFFExif(seg[6:])
+		}
+		i += 2 + size
+	}
+	return nil, nil
+}
+
+// TIFF tags we map onto Exif.
+const (
+	tagMake             = 0x010F
+	tagModel            = 0x0110
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagExposureTime     = 0x829A
+	tagFNumber          = 0x829D
+	tagISO              = 0x8827
+	tagDateTimeOriginal = 0x9003
+	tagExposureBias     = 0x9204
+	tagFocalLength      = 0x920A
+	tagFocalLength35    = 0xA405
+	tagLensModel        = 0xA434
+)
+
+type tiffReader struct {
+	b  []byte
+	bo binary.ByteOrder
+}
+
+// decodeTIFFExif reads IFD0 and the Exif sub-IFD of a TIFF-structured EXIF block.
+func decodeTIFFExif(b []byte) (*Exif, error) {
+	if len(b) < 8 {
+		return nil, errors.New("short TIFF header")
+	}
+	t := &tiffReader{b: b}
+	switch string(b[:2]) {
+	case "II":
+		t.bo = binary.LittleEndian
+	case "MM":
+		t.bo = binary.BigEndian
+	default:
+		return nil, errors.New("bad TIFF byte order")
+	}
+	tags := make(map[uint16]string)
+	exifOff := t.readIFD(int(t.bo.Uint32(b[4:])), tags)
+	if exifOff > 0 {
+		t.readIFD(exifOff, tags)
+	}
+	e := &Exif{Raw: make(map[string]string)}
+	mk, model := tags[tagMake], tags[tagModel]
+	if model != "" && mk != "" && !strings.HasPrefix(strings.ToLower(model), strings.ToLower(mk)) {
+		model = mk + " " + model
+	}
+	e.Camera = model
+	e.Lens = tags[tagLensModel]
+	e.Exposure = tags[tagExposureTime]
+	e.Aperture = tags[tagFNumber]
+	e.ExposureBias = tags[tagExposureBias]
+	fmt.Sscanf(tags[tagISO], "%d", &e.ISO)
+	if v := tags[tagFocalLength]; v != "" {
+		e.FocalLength = v + " mm"
+	}
+	if v := tags[tagFocalLength35]; v != "" && v != "0" {
+		e.FocalLength35 = v + " mm"
+	}
+	captured := tags[tagDateTimeOriginal]
+	if captured == "" {
+		captured = tags[tagDateTime]
+	}
+	if captured != "" {
+		e.CapturedAt = normalizeExifTime(captured)
+	}
+	for tag, v := range tags {
+		e.Raw[fmt.Sprintf("0x%04X", tag)] = v
+	}
+	if e.Camera == "" && e.Exposure == "" && e.ISO == 0 && e.CapturedAt == "" {
+		return nil, nil
+	}
+	return e, nil
+}
+
+// readIFD decodes the tags we care about into out and returns the Exif sub-IFD offset, if any.
+func (t *tiffReader) readIFD(off int, out map[uint16]string) int {
+	if off <= 0 || off+2 > len(t.b) {
+		return 0
+	}
+	n := int(t.bo.Uint16(t.b[off:]))
+	exifOff := 0
+	for i := 0; i < n; i++ {
+		e := off + 2 + i*12
+		if e+12 > len(t.b) {
+			break
+		}
+		tag := t.bo.Uint16(t.b[e:])
+		typ := t.bo.Uint16(t.b[e+2:])
+		count := int(t.bo.Uint32(t.b[e+4:]))
+		valOff := e + 8
+		switch tag {
+		case tagExifIFD:
+			exifOff = int(t.bo.Uint32(t.b[valOff:]))
+		case tagMake, tagModel, tagDateTime, tagDateTimeOriginal, tagLensModel:
+			if typ == 2 {
+				out[tag] = t.ascii(valOff, count)
+			}
+		case tagISO, tagFocalLength35:
+			if typ == 3 && count >= 1 {
+				out[tag] = fmt.Sprint(t.bo.Uint16(t.b[valOff:]))
+			}
+		case tagExposureTime, tagFNumber, tagFocalLength, tagExposureBias:
+			if (typ == 5 || typ == 10) && count >= 1 {
+				out[tag] = t.rational(tag, typ, int(t.bo.Uint32(t.b[valOff:])))
+			}
+		}
+	}
+	return exifOff
+}
+
+func (t *tiffReader) ascii(valOff, count int) string {
+	start := valOff
+	if count > 4 {
+		start = int(t.bo.Uint32(t.b[valOff:]))
+	}
+	if start < 0 || start+count > len(t.b) {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(string(t.b[start:start+count]), "\x00"))
+}
+
+// rational formats a RATIONAL/SRATIONAL the way the Zonerama panel does.
+func (t *tiffReader) rational(tag, typ uint16, off int) string {
+	if off < 0 || off+8 > len(t.b) {
+		return ""
+	}
+	num, den := float64(t.bo.Uint32(t.b[off:])), float64(t.bo.Uint32(t.b[off+4:]))
+	if typ == 10 {
+		num, den = float64(int32(t.bo.Uint32(t.b[off:]))), float64(int32(t.bo.Uint32(t.b[off+4:])))
+	}
+	if den == 0 {
+		return ""
+	}
+	v := num / den
+	switch tag {
+	case tagExposureTime:
+		if v > 0 && v < 1 {
+			return fmt.Sprintf("1/%d s", int(math.Round(1/v)))
+		}
+		return fmt.Sprintf("%g s", v)
+	case tagFNumber:
+		return fmt.Sprintf("%.1f", v)
+	case tagExposureBias:
+		return fmt.Sprintf("%g", math.Round(v*100)/100)
+	}
+	return fmt.Sprintf("%g", math.Round(v*10)/10)
+}
+
+// photoImageURL picks the image to read EXIF from: the largest pyramid level if known.
+func photoImageURL(sizes []PhotoSize, fallback string) string {
+	best, bestW := fallback, 0
+	for _, s := range sizes {
+		if s.Width > bestW && s.URL != "" {
+			best, bestW = s.URL, s.Width
+		}
+	}
+	return best
+}
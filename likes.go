@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/geziyor/geziyor"
+	"github.com/geziyor/geziyor/client"
+)
+
+// Object types used by /Part/Likers?id=<id>&type=<n> and data-like-objecttype.
+const (
+	likeTypeProfile = 0
+	likeTypeAlbum   = 1
+	likeTypePhoto   = 2
+)
+
+// likeCounter reads the counter of the like button for the given object type, if present.
+func likeCounter(s *goquery.Selection, objType int) (int, bool) {
+	c := s.Find(strings.ReplaceAll(currentSelectors().Page.LikeCounter, "{type}", strconv.Itoa(objType))).First()
+	if c.Length() == 0 {
+		return 0, false
+	}
+	n := 0
+	if _, err := fmt.Sscanf(strings.TrimSpace(c.Text()), "%d", &n); err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// photoLikes reads the like counter of a photo page.
+func photoLikes(s *goquery.Selection) (int, bool) {
+	v, _ := firstValue(currentSelectors().Photo.Likes, s)
+	n := 0
+	if _, err := fmt.Sscanf(v, "%d", &n); err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// parseLikers extracts account names from a /Part/Likers fragment. Each liker is a
+// link to their profile; the name comes from the link's title, image alt or path.
+func parseLikers(s *goquery.Selection) []string {
+	var names []string
+	seen := make(map[string]bool)
+	sels := currentSelectors()
+	sels.Photo.Likers.find(s).Each(func(i int, a *goquery.Selection) {
+		href := sels.Photo.Likers.read(a)
+		if href == "" || strings.HasPrefix(href, "javascript:") || href == "#" {
+			return
+		}
+		name, _ := firstValue(sels.Photo.LikerName, a)
+		if name == "" {
+			path := href
+			if i := strings.IndexAny(path, "?#"); i >= 0 {
+				path = path[:i]
+			}
+			parts := strings.Split(strings.Trim(path, "/"), "/")
+			name = parts[len(parts)-1]
+		}
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	})
+	return names
+}
+
+// likesCollector gathers like counts (likes=true) and liker names (likers=true)
+// for albums and photos while a crawl runs, and applies them afterwards.
+type likesCollector struct {
+	mu     sync.Mutex
+	likers bool
+	counts map[string]int      // keyed by likeKey
+	names  map[string][]string // keyed by likeKey
+}
+
+func newLikesCollector(likers bool) *likesCollector {
+	return &likesCollector{likers: likers, counts: make(map[string]int), names: make(map[string][]string)}
+}
+
+func likeKey(objType int, id string) string {
+	return fmt.Sprintf("%d:%s", objType, id)
+}
+
+// setCount records a counter read directly from a page.
+func (c *likesCollector) setCount(objType int, id string, n int) {
+	c.mu.Lock()
+	c.counts[likeKey(objType, id)] = n
+	c.mu.Unlock()
+}
+
+// fetchLikers requests the likers fragment for one object; base resolves the
+// relative URL. The fragment only names accounts, counts come from the pages.
+func (c *likesCollector) fetchLikers(g *geziyor.Geziyor, polite *crawlPoliteness, base *client.Response, objType int, id string) {
+	u := base.JoinURL(fmt.Sprintf("/Part/Likers?id=%s&type=%d", id, objType))
+	g.Get(u, polite.retrying(func(g *geziyor.Geziyor, cr *client.Response) {
+		if !responseAllowed(cr) || cr.HTMLDoc == nil {
+			return
+		}
+		names := parseLikers(cr.HTMLDoc.Selection)
+		c.mu.Lock()
+		c.names[likeKey(objType, id)] = names
+		c.mu.Unlock()
+	}))
+}
+
+// fetchPhotoCount requests a photo page for its like counter.
+func (c *likesCollector) fetchPhotoCount(g *geziyor.Geziyor, polite *crawlPoliteness, base *client.Response, p Photo) {
+	u := p.PageURL
+	if u == "" {
+		return
+	}
+	g.Get(base.JoinURL(u), polite.retrying(func(g *geziyor.Geziyor, cr *client.Response) {
+		if !responseAllowed(cr) || cr.HTMLDoc == nil {
+			return
+		}
+		if n, ok := photoLikes(cr.HTMLDoc.Selection); ok {
+			c.setCount(likeTypePhoto, p.ID, n)
+		}
+	}))
+}
+
+// crawlAlbum records the album counter and queues the pages an album needs:
+// each photo's page, since the album page does not show per-photo counts, and
+// with likers=true the likers fragments of the album and its photos.
+func (c *likesCollector) crawlAlbum(g *geziyor.Geziyor, polite *crawlPoliteness, cr *client.Response, album Album) {
+	if n, ok := likeCounter(cr.HTMLDoc.Selection, likeTypeAlbum); ok && album.ID != "" {
+		c.setCount(likeTypeAlbum, album.ID, n)
+	}
+	if c.likers && album.ID != "" {
+		c.fetchLikers(g, polite, cr, likeTypeAlbum, album.ID)
+	}
+	for _, p := range album.Photos {
+		c.fetchPhotoCount(g, polite, cr, p)
+		if c.likers {
+			c.fetchLikers(g, polite, cr, likeTypePhoto, p.ID)
+		}
+	}
+}
+
+// lookup returns the count, nil when it could not be read, and (with
+// likers=true) the names for one object.
+func (c *likesCollector) lookup(objType int, id string) (*int, []string) {
+	key := likeKey(objType, id)
+	var count *int
+	if n, ok := c.counts[key]; ok {
+		count = &n
+	}
+	var names []string
+	if c.likers {
+		names = c.names[key]
+	}
+	return count, names
+}
+
+// apply attaches the collected likes once the crawl has finished.
+func (c *likesCollector) apply(albums []Album) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range albums {
+		a := &albums[i]
+		a.LikesCount, a.Likers = c.lookup(likeTypeAlbum, a.ID)
+		for j := range a.Photos {
+			p := &a.Photos[j]
+			p.LikesCount, p.Likers = c.lookup(likeTypePhoto, p.ID)
+		}
+	}
+}
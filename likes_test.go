@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/geziyor/geziyor"
+	"github.com/geziyor/geziyor/client"
+)
+
+func TestPhotoLikes(t *testing.T) {
+	f, err := os.Open("other/photos.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	doc, err := goquery.NewDocumentFromReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, ok := photoLikes(doc.Selection); n != 0 || !ok {
+		t.Errorf("fixture: got %d, %v", n, ok)
+	}
+	doc, _ = goquery.NewDocumentFromReader(strings.NewReader(`<div id="photo-like"><span data-id="like-counter"> 12 </span></div>`))
+	if n, ok := photoLikes(doc.Selection); n != 12 || !ok {
+		t.Errorf("counter: got %d, %v", n, ok)
+	}
+	doc, _ = goquery.NewDocumentFromReader(strings.NewReader(`<p>no counter</p>`))
+	if _, ok := photoLikes(doc.Selection); ok {
+		t.Error("read a count from a page without one")
+	}
+}
+
+// likesTransport serves an album page, photo pages with 7 likes and a likers
+// fragment naming two accounts, and records the paths asked for.
+type likesTransport struct {
+	mu    sync.Mutex
+	paths []string
+}
+
+func (f *likesTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	f.mu.Lock()
+	f.paths = append(f.paths, r.URL.Path)
+	f.mu.Unlock()
+	body := `<div data-object="like" data-like-objecttype="1"><span data-id="like-counter">3</span></div>`
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/Photo/"):
+		body = `<div id="photo-like"><span data-id="like-counter">7</span></div>`
+	case r.URL.Path == "/Part/Likers":
+		body = `<a href="/Alice" title="Alice"></a><a href="/Bob" title="Bob"></a>`
+	}
+	rec := httptest.NewRecorder()
+	rec.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rec.WriteString(body)
+	res := rec.Result()
+	res.Request = r
+	return res, nil
+}
+
+func TestLikesCollectorCrawlAlbum(t *testing.T) {
+	oldHosts := politeHosts
+	politeHosts = newHostLimiter(0, 0)
+	t.Cleanup(func() { crawlTransport, politeHosts = nil, oldHosts })
+
+	album := Album{ID: "10", Photos: []Photo{{ID: "1", PageURL: "https://eu.zonerama.com/Photo/10/1"}}}
+	for _, likers := range []bool{false, true} {
+		ft := &likesTransport{}
+		crawlTransport = ft
+		likes := newLikesCollector(likers)
+		polite := politenessFromQuery(context.Background(), url.Values{})
+		polite.cfg.Delay = 0
+		cw := newCrawl(polite, &scrapeParams{})
+		newCrawler(cw.options("https://eu.zonerama.com/Fixture/Album/10", func(g *geziyor.Geziyor, cr *client.Response) {
+			likes.crawlAlbum(g, polite, cr, album)
+		})).Start()
+
+		albums := []Album{album}
+		likes.apply(albums)
+		a, p := albums[0], albums[0].Photos[0]
+		// Counts come from the pages, not from the number of likers listed
+		if a.LikesCount == nil || *a.LikesCount != 3 || p.LikesCount == nil || *p.LikesCount != 7 {
+			t.Errorf("likers=%v: got album %v, photo %v likes", likers, a.LikesCount, p.LikesCount)
+		}
+		var wantNames []string
+		if likers {
+			wantNames = []string{"Alice", "Bob"}
+		}
+		if !reflect.DeepEqual(a.Likers, wantNames) || !reflect.DeepEqual(p.Likers, wantNames) {
+			t.Errorf("likers=%v: got album %v, photo %v likers", likers, a.Likers, p.Likers)
+		}
+		fetchedLikers := false
+		for _, path := range ft.paths {
+			fetchedLikers = fetchedLikers || path == "/Part/Likers"
+		}
+		if fetchedLikers != likers {
+			t.Errorf("likers=%v: requested %v", likers, ft.paths)
+		}
+	}
+}
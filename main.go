@@ -1,18 +1,15 @@
 package main
 
 import (
-	"crypto/sha1"
-	"encoding/hex"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
-	"path/filepath"
 	"regexp"
 	"sort"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -20,80 +17,86 @@ import (
 	"github.com/PuerkitoBio/goquery"
 	"github.com/geziyor/geziyor"
 	"github.com/geziyor/geziyor/client"
+	"github.com/geziyor/geziyor/middleware"
 )
 
 // Data models for JSON response
 
 type Photo struct {
 	ID        string `json:"id"`
+	Type      string `json:"type"` // photo or video
 	PageURL   string `json:"page_url,omitempty"`
 	Image1500 string `json:"image_1500"`
+	// From the album gallery: real dimensions and a {width}/{height} image pattern
+	Width        int          `json:"width,omitempty"`
+	Height       int          `json:"height,omitempty"`
+	Orientation  string       `json:"orientation,omitempty"` // landscape, portrait or square
+	Bytes        int64        `json:"bytes,omitempty"`
+	ImagePattern string       `json:"image_pattern,omitempty"`
+	Sizes        []PhotoSize  `json:"sizes,omitempty"` // only with sizes=, or the pyramid on /zonerama-photo
+	Video        *Video       `json:"video,omitempty"`
+	Exif         *Exif        `json:"exif,omitempty"`
+	LikesCount   *int         `json:"likes_count,omitempty"`
+	Likers       []string     `json:"likers,omitempty"`
+	Hashes       *PhotoHashes `json:"hashes,omitempty"` // only with dupes=true
 }
 
-// zoneramaAlbumHandler parses a single album only when the link contains "/Album/".
+// scrapeError is a scrape failure and the HTTP status it answers with.
+type scrapeError struct {
+	status int
+	msg    string
+}
+
+func (e *scrapeError) Error() string { return e.msg }
+
+// writeScrapeError answers with the status of a *scrapeError, 500 for anything else.
+func writeScrapeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var se *scrapeError
+	if errors.As(err, &se) {
+		status = se.status
+	}
+	writeJSONError(w, status, err.Error())
+}
+
+// zoneramaAlbumHandler serves /zonerama-album, see scrapeAlbum.
 func zoneramaAlbumHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
+	resp, err := scrapeAlbum(r.Context(), r.URL.Query(), true)
+	if err != nil {
+		writeScrapeError(w, err)
+		return
+	}
+	writeJSON(w, r, resp)
+}
 
-	link := r.URL.Query().Get("link")
+// scrapeAlbum parses a single album; the link must resolve to an album or photo.
+// With store set the result goes to the catalog, see scrapeProfile.
+func scrapeAlbum(ctx context.Context, q url.Values, store bool) (*Response, error) {
+	link := q.Get("link")
 	if link == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "missing link param: /zonerama-album?link=https://eu.zonerama.com/<Account>/Album/<AlbumId>"})
-		return
+		return nil, &scrapeError{http.StatusBadRequest, "missing link param: /zonerama-album?link=https://eu.zonerama.com/<Account>/Album/<AlbumId>"}
 	}
-	u, err := url.Parse(link)
-	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
-		w.WriteHeader(http.StatusBadRequest)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid link URL"})
-		return
+	canon, err := resolveZoneramaLink(link)
+	if err != nil {
+		return nil, &scrapeError{http.StatusBadRequest, err.Error()}
 	}
-	if !strings.Contains(u.Host, "zonerama.com") {
-		w.WriteHeader(http.StatusBadRequest)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "link must point to zonerama.com"})
-		return
-	}
-	if !strings.Contains(u.Path, "/Album/") {
-		w.WriteHeader(http.StatusBadRequest)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "zonerama-album expects an album link containing /Album/"})
-		return
+	if canon.Kind != "album" && canon.Kind != "photo" {
+		return nil, &scrapeError{http.StatusBadRequest, "zonerama-album expects an album (or photo) link, e.g. https://eu.zonerama.com/<Account>/Album/<AlbumId>"}
 	}
+	startURL := canon.CrawlURL()
 
-	// Limits
-	photoLimit := 10 // default 10; 0 = no limit
-	if s := r.URL.Query().Get("photo_limit"); s != "" {
-		fmt.Sscanf(s, "%d", &photoLimit)
-	}
-	debugSave := false
-	if s := r.URL.Query().Get("debug"); s != "" {
-		if b, err := strconv.ParseBool(s); err == nil {
-			debugSave = b
-		}
+	params, err := parseScrapeParams(q, canon.URL)
+	if err != nil {
+		return nil, &scrapeError{http.StatusBadRequest, err.Error()}
 	}
+	exifs, likes := params.collectors()
+	morePhotos := false
 
-	// Rendering toggle: default true, can be disabled with rendered=false or no-render/no_render=true
-	useRendered := true
-	if s := r.URL.Query().Get("rendered"); s != "" {
-		if b, err := strconv.ParseBool(s); err == nil {
-			useRendered = b
-		}
-	}
-	if s := r.URL.Query().Get("no-render"); s != "" {
-		if b, err := strconv.ParseBool(s); err == nil && b {
-			useRendered = false
-		}
-	}
-	if s := r.URL.Query().Get("no_render"); s != "" {
-		if b, err := strconv.ParseBool(s); err == nil && b {
-			useRendered = false
-		}
-	}
-	doGet := func(g *geziyor.Geziyor, u string, cb func(*geziyor.Geziyor, *client.Response)) {
-		if useRendered {
-			g.GetRendered(u, cb)
-		} else {
-			g.Get(u, cb)
-		}
-	}
+	// Politeness toward zonerama.com (rate limit, delay, backoff, robots.txt)
+	polite := politenessFromQuery(ctx, q)
+	cw := newCrawl(polite, params)
 
 	// Response accumulator
 	var (
@@ -101,160 +104,222 @@ func zoneramaAlbumHandler(w http.ResponseWriter, r *http.Request) {
 		mu   sync.Mutex
 	)
 	resp.InputLink = link
+	resp.Canonical = canon
+	sels := currentSelectors()
+	trace := newParseTrace(sels)
 
 	// Regexes
 	photoIDRe := regexp.MustCompile(`(?i)^\d+$`)
-	rePhotoIDFromHref := regexp.MustCompile(`/Photo/\d+/(\d+)`)
-	rePhotoIDFromImg := regexp.MustCompile(`/photos/(\d+)_`)
-
-	// Debug helpers
-	sanitizeRe := regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
-	saveDebug := func(stage string, cr *client.Response) {
-		if !debugSave || cr == nil || cr.Request == nil {
-			return
-		}
-		_ = os.MkdirAll("debuging", 0o755)
-		u := cr.Request.URL.String()
-		h := sha1.Sum([]byte(u))
-		short := hex.EncodeToString(h[:6])
-		name := fmt.Sprintf("%s_%s_%s.html", stage, short, sanitizeRe.ReplaceAllString(u, "_"))
-		path := filepath.Join("debuging", name)
-		_ = os.WriteFile(path, cr.Body, 0o644)
-	}
 
 	// Parse a single album
 	parseAlbum := func(g *geziyor.Geziyor, cr *client.Response) {
-		saveDebug("album", cr)
+		if !responseAllowed(cr) {
+			return
+		}
+		cw.save("album", cr)
 		doc := cr.HTMLDoc
 		if doc == nil {
 			return
 		}
 		album := Album{URL: cr.Request.URL.String()}
-		if sel := doc.Find("meta[property='znrm:album']"); sel.Length() > 0 {
-			if v, ok := sel.Attr("content"); ok {
-				album.ID = strings.TrimSpace(v)
-			}
-		}
-		album.Title = strings.TrimSpace(doc.Find(".row-name-album h2 span").First().Text())
-		dt := strings.TrimSpace(doc.Find(".row-name-album .album-info .hide-on-phone").First().Text())
-		if dt != "" {
-			dt = strings.TrimSpace(strings.TrimPrefix(dt, "|"))
-		}
-		album.Date = dt
-		if pc := strings.TrimSpace(doc.Find(".row-name-album [data-id='header-album-photos']").First().Text()); pc != "" {
+		album.ID = sels.Album.ID.value(doc.Selection)
+		album.Title = sels.Album.Title.value(doc.Selection)
+		album.Date = strings.TrimSpace(strings.TrimPrefix(sels.Album.Date.value(doc.Selection), "|"))
+		album.Cover = coverFromAlbumPage(doc)
+		lang, loc := pageDateContext(doc)
+		setAlbumDate(&album, lang, loc)
+		if pc := sels.Album.PhotosCount.value(doc.Selection); pc != "" {
 			fmt.Sscanf(pc, "%d", &album.PhotosCnt)
 		}
-		// Photos
+		// Photos: the first gallery item strategy that matches, see Selectors
 		count := 0
-		photoSel := doc.Find("[data-type='photo'][data-id]")
-		if photoSel.Length() == 0 {
-			photoSel = doc.Find(".gallery-inner [data-id]")
-		}
+		photoSel, rule := firstMatch(sels.Album.Photos, doc.Selection)
+		strategy := rule.Name
+		exts := videoExts(doc)
 		photoSel.Each(func(i int, s *goquery.Selection) {
-			if photoLimit > 0 && count >= photoLimit {
+			if params.MaxPhotos > 0 && count >= params.MaxPhotos {
 				return
 			}
-			pid := strings.TrimSpace(s.AttrOr("data-id", ""))
+			pid := rule.read(s)
 			if pid == "" || !photoIDRe.MatchString(pid) {
 				return
 			}
-			p := Photo{ID: pid}
-			if a := s.Find("a.gallery-link"); a.Length() > 0 {
-				p.PageURL = a.AttrOr("href", "")
+			typ := itemType(s)
+			if !mediaAllowed(params.Media, typ) {
+				return
+			}
+			p := Photo{ID: pid, Type: typ}
+			if a := sels.Album.PhotoLink.find(s); a.Length() > 0 {
+				p.PageURL = sels.Album.PhotoLink.read(a.First())
 				if strings.HasPrefix(p.PageURL, "/") {
 					p.PageURL = cr.JoinURL(p.PageURL)
 				}
 			}
 			p.Image1500 = fmt.Sprintf("https://%s/photos/%s_1500x1000.jpg", cr.Request.URL.Host, pid)
+			applyGalleryItem(s, &p, params.SizeEdges)
+			if typ == "video" {
+				applyVideoItem(s, &p, exts, cr.JoinURL)
+			}
 			album.Photos = append(album.Photos, p)
 			count++
 		})
-		if count == 0 {
-			doc.Find("a[href*='/Photo/']").Each(func(i int, a *goquery.Selection) {
-				if photoLimit > 0 && count >= photoLimit {
-					return
-				}
-				href := strings.TrimSpace(a.AttrOr("href", ""))
-				if href == "" {
-					return
-				}
-				m := rePhotoIDFromHref.FindStringSubmatch(href)
-				if len(m) < 2 {
+		// Fallbacks cannot tell videos apart, so they only run when photos are wanted
+		for _, fb := range sels.Album.PhotoFallbacks {
+			if count > 0 || params.Media == mediaVideos {
+				break
+			}
+			fb.find(doc.Selection).Each(func(i int, el *goquery.Selection) {
+				if params.MaxPhotos > 0 && count >= params.MaxPhotos {
 					return
 				}
-				pid := m[1]
-				if !photoIDRe.MatchString(pid) {
+				raw := fb.raw(el)
+				pid := fb.match(raw)
+				if pid == "" || !photoIDRe.MatchString(pid) {
 					return
 				}
-				p := Photo{ID: pid, PageURL: href}
-				if strings.HasPrefix(p.PageURL, "/") {
-					p.PageURL = cr.JoinURL(p.PageURL)
+				p := Photo{ID: pid, Type: "photo"}
+				// A link to the photo page doubles as its page_url
+				if fb.Attr == "href" {
+					p.PageURL = raw
+					if strings.HasPrefix(p.PageURL, "/") {
+						p.PageURL = cr.JoinURL(p.PageURL)
+					}
 				}
 				p.Image1500 = fmt.Sprintf("https://%s/photos/%s_1500x1000.jpg", cr.Request.URL.Host, pid)
 				album.Photos = append(album.Photos, p)
 				count++
+				strategy = fb.Name
 			})
 		}
-		if count == 0 {
-			doc.Find("img[src*='/photos/']").Each(func(i int, img *goquery.Selection) {
-				if photoLimit > 0 && count >= photoLimit {
-					return
-				}
-				src := strings.TrimSpace(img.AttrOr("src", ""))
-				m := rePhotoIDFromImg.FindStringSubmatch(src)
-				if len(m) < 2 {
-					return
-				}
-				pid := m[1]
-				p := Photo{ID: pid}
-				p.Image1500 = fmt.Sprintf("https://%s/photos/%s_1500x1000.jpg", cr.Request.URL.Host, pid)
-				album.Photos = append(album.Photos, p)
-				count++
-			})
+		// An empty album is not a parser failure
+		if strategy != strategyNone || album.PhotosCnt > 0 {
+			trace.record(stepAlbumPhotos, strategy, album.URL)
+		}
+		var more bool
+		album.Photos, more = pagePhotos(album.Photos, params.PhotoOffset, params.PhotoLimit)
+		if !params.IncludePhotos {
+			album.Photos, more = []Photo{}, false
+		}
+		if exifs != nil {
+			for _, p := range album.Photos {
+				exifs.fetch(g, polite, cr, p)
+			}
+		}
+		if likes != nil {
+			likes.crawlAlbum(g, polite, cr, album)
 		}
 		mu.Lock()
 		resp.Albums = append(resp.Albums, album)
+		morePhotos = morePhotos || more
 		mu.Unlock()
 	}
 
-	gz := geziyor.NewGeziyor(&geziyor.Options{
-		StartRequestsFunc: func(g *geziyor.Geziyor) {
-			doGet(g, link, parseAlbum)
-		},
-		ParseFunc:         parseAlbum,
-		RetryTimes:        2,
-		Timeout:           30 * time.Second,
-		LogDisabled:       true,
-		RobotsTxtDisabled: true,
-	})
+	gz := newCrawler(cw.options(startURL, parseAlbum))
 	gz.Start()
+	if exifs != nil {
+		exifs.apply(resp.Albums)
+	}
+	if likes != nil {
+		likes.apply(resp.Albums)
+	}
 
-	enc := json.NewEncoder(w)
-	enc.SetIndent("", "  ")
-	_ = enc.Encode(resp)
+	if morePhotos {
+		resp.NextCursor = pageCursor{Link: canon.URL, Sort: params.Sort, Photos: params.PhotoOffset + params.PhotoLimit}.encode()
+	}
+
+	resp.Parser, resp.Warnings = trace.report()
+	if store {
+		parserTotals.add(resp.Parser.Strategies)
+		storeResponse(&resp, q)
+	}
+	if params.Dupes {
+		resp.Duplicates = findDuplicates(ctx, &resp, params.DupeDistance)
+	}
+	if params.Export != "" {
+		if resp.Export, err = exportJSON(ctx, canon, resp); err != nil {
+			log.Printf("storage: exporting %s: %v", link, err)
+			return nil, &scrapeError{http.StatusBadGateway, "export failed: " + err.Error()}
+		}
+	}
+	return &resp, nil
 }
 
 type Album struct {
-	ID        string  `json:"id"`
-	Title     string  `json:"title"`
-	URL       string  `json:"url"`
-	Date      string  `json:"date,omitempty"`
-	PhotosCnt int     `json:"photos_count,omitempty"`
-	ViewsCnt  int     `json:"views_count,omitempty"`
-	Photos    []Photo `json:"photos"`
+	ID         string   `json:"id"`
+	Title      string   `json:"title"`
+	URL        string   `json:"url"`
+	Date       string   `json:"date,omitempty"`     // as shown on the page, e.g. "20. 9. 2025"
+	DateISO    string   `json:"date_iso,omitempty"` // RFC 3339, midnight in the page's time zone
+	DateEndISO string   `json:"date_end_iso,omitempty"`
+	PhotosCnt  int      `json:"photos_count,omitempty"`
+	ViewsCnt   int      `json:"views_count,omitempty"`
+	LikesCount *int     `json:"likes_count,omitempty"`
+	Likers     []string `json:"likers,omitempty"`
+	Cover      *Cover   `json:"cover,omitempty"`
+	Photos     []Photo  `json:"photos"`
+	// mode=incremental: built from the profile tile because the catalog already has it with this photo count
+	Unchanged bool `json:"unchanged,omitempty"`
 }
 
 type Response struct {
-	InputLink string  `json:"input_link"`
-	Albums    []Album `json:"albums"`
+	InputLink string         `json:"input_link"`
+	Canonical *CanonicalLink `json:"canonical,omitempty"`
+	Albums    []Album        `json:"albums"`
+	// Pass as cursor= to fetch the next page; omitted on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+	// Near-duplicate photos, only with dupes=true
+	Duplicates *DuplicateReport `json:"duplicates,omitempty"`
+	// Which selector strategies matched, and a warning per fallback used
+	Parser   *ParserInfo `json:"parser,omitempty"`
+	Warnings []string    `json:"warnings,omitempty"`
+	// Where export=json stored this response
+	Export *Export `json:"export,omitempty"`
 }
 
 func main() {
-	http.HandleFunc("/zonerama", zoneramaHandler)
-	http.HandleFunc("/zonerama-album", zoneramaAlbumHandler)
+	// zonerama diff old.json new.json compares two saved /zonerama responses offline
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		os.Exit(runDiffCLI(os.Args[2:]))
+	}
+	// zonerama selectors [file] prints the built-in selectors or validates a file
+	if len(os.Args) > 1 && os.Args[1] == "selectors" {
+		os.Exit(runSelectorsCLI(os.Args[2:]))
+	}
+	// Crawl endpoints: per-IP rate limit, then auth, then a server-wide crawl slot
+	http.HandleFunc("/zonerama", limitClientRate(requireAPIKey(admitCrawl(zoneramaHandler))))
+	http.HandleFunc("/zonerama-album", limitClientRate(requireAPIKey(admitCrawl(zoneramaAlbumHandler))))
+	http.HandleFunc("/zonerama-photo", limitClientRate(requireAPIKey(admitCrawl(zoneramaPhotoHandler))))
+	http.HandleFunc("/zonerama/diff", limitClientRate(requireAPIKey(admitCrawl(zoneramaDiffHandler))))
+	// Catalog reads never touch Zonerama, so they skip crawl admission
+	http.HandleFunc("/catalog/", limitClientRate(requireAPIKey(catalogHandler)))
+	// Watches only register schedules; their runs take crawl slots in the background
+	http.HandleFunc("/watches", limitClientRate(requireAPIKey(watchesHandler)))
+	http.HandleFunc("/watches/", limitClientRate(requireAPIKey(watchesHandler)))
+	http.HandleFunc("/webhooks", limitClientRate(requireAPIKey(webhooksHandler)))
+	http.HandleFunc("/webhooks/", limitClientRate(requireAPIKey(webhooksHandler)))
 	http.HandleFunc("/", docsHandler)
-	// Serve saved debug HTML files
-	http.Handle("/debuging/", http.StripPrefix("/debuging/", http.FileServer(http.Dir("debuging"))))
+	// Serve saved debug HTML and exports from the configured storage
+	http.HandleFunc("/debuging/", limitClientRate(requireAPIKey(filesHandler)))
+	http.HandleFunc("/files/", limitClientRate(requireAPIKey(filesHandler)))
+	http.HandleFunc("/img/", requireAPIKey(imgHandler))
+	// Scrapes the canary album on demand, so it goes through auth and the client limit
+	http.HandleFunc("/health/parser", limitClientRate(requireAPIKey(parserHealthHandler)))
+	http.HandleFunc("/selectors", limitClientRate(requireAPIKey(selectorsHandler)))
+	if apiKeys.enabled() {
+		log.Printf("API key auth enabled (%d keys)", len(apiKeys.keys))
+	}
+	log.Printf("Storage: %s", storage.Name())
+	startSelectors()
+	if path := envString("ZONERAMA_CATALOG", ""); path != "" {
+		c, err := openCatalog(path)
+		if err != nil {
+			log.Fatalf("opening catalog %s: %v", path, err)
+		}
+		catalog = c
+		log.Printf("Catalog enabled at %s", path)
+		watchRunner = startWatchScheduler(c)
+		resumeDeliveries(c)
+	}
 	addr := ":7053"
 	log.Printf("Starting server on %s...", addr)
 	if err := http.ListenAndServe(addr, nil); err != nil {
@@ -293,18 +358,31 @@ func docsHandler(w http.ResponseWriter, r *http.Request) {
       <li><strong>album_limit</strong> (optional): Integer to limit number of albums processed from a profile. Default: <code>5</code>. <code>0</code> means no limit.</li>
       <li><strong>photo_limit</strong> (optional): Integer to limit number of photos scraped per album. Default: <code>10</code>. <code>0</code> means no limit.</li>
       <li><strong>debug</strong> (optional): <code>true|false</code>. If <code>true</code>, saves fetched HTML files into <code>debuging/</code> and serves them at <code>/debuging/</code>.</li>
+      <li><strong>exif</strong> (optional): <code>true|false</code>. Fills <code>exif</code> (camera, lens, exposure, ISO, capture time) on each photo; one extra request per photo. <code>exif_jpeg=true</code> falls back to reading the image bytes.</li>
+      <li><strong>likes</strong> (optional): <code>true|false</code>. Fills <code>likes_count</code> on albums and photos; one extra request per photo.</li>
+      <li><strong>likers</strong> (optional): <code>true|false</code>. Also lists the liking account names in <code>likers</code> (implies <code>likes</code>).</li>
+      <li><strong>since</strong>, <strong>until</strong> (optional): Dates like <code>2025-08-01</code>. Only albums dated within the range are fetched.</li>
+      <li><strong>title</strong> (optional): Title substring, or <code>/regex/</code>. <strong>min_photos</strong> (optional): minimum photo count. <strong>exclude</strong> (optional): comma-separated album IDs, links or title substrings.</li>
+      <li><strong>sort</strong> (optional): <code>date|-date|title|views|photos|profile_order</code>, default <code>-date</code>. <strong>offset</strong>, <strong>photo_offset</strong>, <strong>cursor</strong> (optional): paging; follow <code>next_cursor</code> from the response.</li>
+      <li><strong>include_photos</strong> (optional): <code>false</code> returns albums from profile tiles only, without fetching album pages. <strong>fields</strong> (optional): comma-separated JSON paths to keep, e.g. <code>albums.id,albums.title</code>.</li>
+      <li><strong>dupes</strong> (optional): <code>true|false</code>. Hashes every photo's thumbnail (aHash, dHash, pHash) and reports groups of near-duplicates across albums in <code>duplicates</code>, with similarity scores. <code>dupe_distance</code> (default 10) is the largest Hamming distance counted as a match.</li>
+      <li><strong>mode</strong> (optional): <code>incremental</code> only fetches album pages that are new to the catalog or whose photo count changed. Unchanged albums come from their tiles with <code>"unchanged": true</code>. Requires <code>ZONERAMA_CATALOG</code>.</li>
+      <li><strong>sizes</strong> (optional): Long-edge lengths, e.g. <code>750,1500,3000</code>. Adds a <code>sizes</code> list of renditions to each photo.</li>
+      <li><strong>media</strong> (optional): <code>photos|videos|all</code>, default <code>all</code>. Each item has a <code>type</code>; videos add a <code>video</code> object.</li>
     </ul>
     <h3>Example</h3>
     <p><code>/zonerama?link=https://eu.zonerama.com/SomeAccount/12345&amp;album_limit=5&amp;photo_limit=50</code></p>
     <h3>Response</h3>
     <pre>{
   "input_link": "...",
+  "canonical": { "kind": "album", "url": "...", "account": "...", "album_id": "..." },
   "albums": [
     {
       "id": "...",
       "title": "...",
       "url": "...",
       "date": "...",
+      "date_iso": "...",
       "photos_count": 42,
       "photos": [
         { "id": "...", "page_url": "...", "image_1500": "..." }
@@ -315,7 +393,7 @@ func docsHandler(w http.ResponseWriter, r *http.Request) {
   </div>
   <div class="endpoint">
     <h2>GET /zonerama-album</h2>
-    <p>Scrape a single album by URL (link must contain <code>/Album/</code>).</p>
+    <p>Scrape a single album by URL (album, embed or photo link; photo links scrape their album).</p>
     <h3>Query parameters</h3>
     <ul>
       <li><strong>link</strong> (required): A Zonerama album URL. Example: <code>https://eu.zonerama.com/&lt;Account&gt;/Album/&lt;AlbumId&gt;</code>.</li>
@@ -327,81 +405,127 @@ func docsHandler(w http.ResponseWriter, r *http.Request) {
     <p><code>/zonerama-album?link=https://eu.zonerama.com/Fcbizoni/Album/13878599&amp;photo_limit=25</code></p>
   </div>
   <p>Server listens on <code>:7053</code>. CORS is enabled allowing all origins (<code>Access-Control-Allow-Origin: *</code>).</p>
+  <div class="endpoint">
+    <h2>GET /zonerama-photo</h2>
+    <p>Fetch a single photo: title, description, album back-reference, dimensions and size pyramid, like count, EXIF (when shown) and neighbouring photo IDs.</p>
+    <h3>Query parameters</h3>
+    <ul>
+      <li><strong>link</strong> (required): A Zonerama photo URL. Example: <code>https://eu.zonerama.com/Photo/&lt;AlbumId&gt;/&lt;PhotoId&gt;</code>.</li>
+      <li><strong>rendered</strong>, <strong>debug</strong> (optional): as above.</li>
+    </ul>
+  </div>
+  <div class="endpoint">
+    <h2>GET /zonerama/diff</h2>
+    <p>Scrape a link like <code>/zonerama</code> (same parameters) and compare it with the snapshot stored in the catalog: added, removed and changed albums (title, typed date, photo, view and like counts, cover) and added or removed photo IDs. Removals are only reported for complete scrapes (<code>album_limit=0</code>, <code>photo_limit=0</code>, no filters or paging). Requires <code>ZONERAMA_CATALOG</code>.</p>
+    <p>Offline: <code>zonerama diff [-complete-albums] [-complete-photos] old.json new.json</code> compares two saved <code>/zonerama</code> responses; removals are only reported for what the flags say the new file covers.</p>
+  </div>
+  <div class="endpoint">
+    <h2>POST /watches</h2>
+    <p>Register a link to re-scrape in the background: <code>{"link": "...", "interval": "6h", "params": {"album_limit": "0"}}</code>. <code>GET /watches/{id}</code> returns the watch and its latest result, <code>DELETE /watches/{id}</code> removes it. Runs are jittered and share the crawl slots with client requests. Requires <code>ZONERAMA_CATALOG</code>.</p>
+  </div>
+  <div class="endpoint">
+    <h2>POST /webhooks</h2>
+    <p>Get <code>album.new</code> and <code>watch.completed</code> events from watches POSTed to your URL: <code>{"url": "...", "events": ["album.new"], "watch_id": "..."}</code>. Bodies are signed (<code>X-Zonerama-Signature</code>, HMAC-SHA256 of <code>&lt;timestamp&gt;.&lt;body&gt;</code>), failures are retried with backoff, and <code>/webhooks/{id}/deliveries</code> shows the delivery log. <code>POST /webhooks/{id}/test</code> sends a ping. With API keys, each key only sees its own webhooks.</p>
+  </div>
+  <div class="endpoint">
+    <h2>GET /files/&lt;key&gt;</h2>
+    <p><code>export=json</code> on <code>/zonerama</code> and <code>/zonerama-album</code> writes the response to storage and returns <code>export.url</code>. With <code>ZONERAMA_STORAGE=local</code> (default) files are served here. With <code>ZONERAMA_STORAGE=s3</code> they live in an S3-compatible bucket and URLs are presigned.</p>
+  </div>
+  <div class="endpoint">
+    <h2>GET /img/{photoID}</h2>
+    <p>Serve a photo without hotlinking Zonerama: <code>/img/&lt;PhotoId&gt;?w=400&amp;h=300&amp;fit=cover&amp;format=webp</code>. <code>fit</code> is <code>contain</code> (default), <code>cover</code> or <code>fill</code>. <code>format</code> is <code>jpeg</code> (default), <code>webp</code> or <code>png</code>. The nearest pyramid size is resized in Go, cached on disk (LRU) and served with long-lived <code>Cache-Control</code> and an <code>ETag</code>.</p>
+  </div>
+  <div class="endpoint">
+    <h2>GET /health/parser</h2>
+    <p>Scrape the canary album (<code>ZONERAMA_CANARY_ALBUM</code>) and check the markers the parser relies on: album ID, title, date, photo count, gallery items. Answers <code>503</code> with <code>"status": "failing"</code> when one is missing. Scrape responses also report the matched selector strategies in <code>parser</code>, and add <code>warnings</code> when a fallback was used.</p>
+  </div>
+  <div class="endpoint">
+    <h2>GET /selectors</h2>
+    <p>Show the active parser selectors, where they came from and the last reload error. Set <code>ZONERAMA_SELECTORS</code> to a YAML or JSON file to override the built-in ones; it is reloaded on change or <code>SIGHUP</code>. <code>zonerama selectors</code> prints the defaults.</p>
+  </div>
+  <div class="endpoint">
+    <h2>GET /catalog/albums</h2>
+    <p>With <code>ZONERAMA_CATALOG</code> set, every scrape is stored in SQLite. <code>/catalog/albums?account=&lt;Account&gt;</code>, <code>/catalog/photos?album=&lt;AlbumId&gt;</code>, <code>/catalog/duplicates?account=&lt;Account&gt;</code> and <code>/catalog/accounts</code> serve from the store without contacting Zonerama.</p>
+  </div>
+  <p>When API keys are configured, send one as <code>X-API-Key</code>, <code>Authorization: Bearer</code> or <code>api_key</code>. Exceeded quotas return <code>429</code> with <code>X-RateLimit-*</code> headers.</p>
+  <p>Requests are rate limited per client IP (<code>429</code>) and the number of simultaneous crawls is capped; when the wait queue is full the server answers <code>503</code> with <code>Retry-After</code>.</p>
+  <p>Both endpoints accept <code>rps</code>, <code>delay_ms</code> and <code>robots</code> to make the crawl more polite than the server defaults. Throttled responses (<code>429</code>/<code>503</code>) are retried with exponential backoff.</p>
   <p>JS rendering is enabled by default (requires Chrome installed). When <code>debug=true</code>, fetched pages are saved beneath <code>debuging/</code> and can be viewed at <code>/debuging/</code>.</p>
 </body>
 </html>`)
 }
 
+// zoneramaHandler serves /zonerama, see scrapeProfile.
 func zoneramaHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-
-	link := r.URL.Query().Get("link")
-	if link == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "missing link param: /zonerama?link=https://eu.zonerama.com/<Account>/<TabId> or Profile link"})
+	resp, err := scrapeProfile(r.Context(), r.URL.Query(), true)
+	if err != nil {
+		writeScrapeError(w, err)
 		return
 	}
+	writeJSON(w, r, resp)
+}
 
-	u, err := url.Parse(link)
-	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
-		w.WriteHeader(http.StatusBadRequest)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid link URL"})
-		return
+// scrapeProfile crawls a profile, tab, album or photo link with the /zonerama
+// parameters in q. It stops early when ctx is done. With store set the result
+// is saved in the catalog and its parser strategies count toward the totals on
+// /health/parser; the canary scrapes without.
+func scrapeProfile(ctx context.Context, q url.Values, store bool) (*Response, error) {
+	link := q.Get("link")
+	if link == "" {
+		return nil, &scrapeError{http.StatusBadRequest, "missing link param: /zonerama?link=https://eu.zonerama.com/<Account>/<TabId> or Profile link"}
 	}
-	// Basic guard to keep scope on zonerama
-	if !strings.Contains(u.Host, "zonerama.com") {
-		w.WriteHeader(http.StatusBadRequest)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "link must point to zonerama.com"})
-		return
+
+	// Canonicalize the link; this also keeps scope on allowlisted zonerama hosts
+	canon, err := resolveZoneramaLink(link)
+	if err != nil {
+		return nil, &scrapeError{http.StatusBadRequest, err.Error()}
 	}
+	// Photo links start from their album
+	startURL := canon.CrawlURL()
 	albumLimit := 5 // default 5; 0 = no limit
-	if s := r.URL.Query().Get("album_limit"); s != "" {
+	if s := q.Get("album_limit"); s != "" {
 		fmt.Sscanf(s, "%d", &albumLimit)
 	}
-	// Optional: limit number of photos per album
-	photoLimit := 10 // default 10; 0 = no limit
-	if s := r.URL.Query().Get("photo_limit"); s != "" {
-		fmt.Sscanf(s, "%d", &photoLimit)
+	params, err := parseScrapeParams(q, canon.URL)
+	if err != nil {
+		return nil, &scrapeError{http.StatusBadRequest, err.Error()}
 	}
-	// JS-rendered requests are used by default (no 'rendered' query param)
-	// Optional: enable debug HTML saving
-	debugSave := false
-	if s := r.URL.Query().Get("debug"); s != "" {
-		if b, err := strconv.ParseBool(s); err == nil {
-			debugSave = b
-		}
-	}
-
-	// Rendering toggle: default true; can disable via rendered=false or no-render/no_render=true
-	useRendered := true
-	if s := r.URL.Query().Get("rendered"); s != "" {
-		if b, err := strconv.ParseBool(s); err == nil {
-			useRendered = b
-		}
+	exifs, likes := params.collectors()
+	var (
+		morePhotos  bool
+		profileSeen bool
+		tilesLeft   int
+		nextAlbum   int
+	)
+	// Optional: mode=incremental only renders album pages the catalog lacks or whose photo count changed
+	mode, err := parseModeParam(q.Get("mode"))
+	if err != nil {
+		return nil, &scrapeError{http.StatusBadRequest, err.Error()}
 	}
-	if s := r.URL.Query().Get("no-render"); s != "" {
-		if b, err := strconv.ParseBool(s); err == nil && b {
-			useRendered = false
+	var storedCounts map[string]int
+	if mode == modeIncremental {
+		if catalog == nil {
+			return nil, &scrapeError{http.StatusBadRequest, "mode=incremental needs the catalog; set ZONERAMA_CATALOG to a database path"}
 		}
-	}
-	if s := r.URL.Query().Get("no_render"); s != "" {
-		if b, err := strconv.ParseBool(s); err == nil && b {
-			useRendered = false
+		if storedCounts, err = catalog.albumPhotoCounts(catalogAccount(canon)); err != nil {
+			log.Printf("catalog: loading photo counts for %s: %v", canon.URL, err)
+			return nil, &scrapeError{http.StatusInternalServerError, "catalog query failed"}
 		}
 	}
-	// Helper to choose between rendered and non-rendered fetch
-	doGet := func(g *geziyor.Geziyor, url string, cb func(*geziyor.Geziyor, *client.Response)) {
-		if useRendered {
-			g.GetRendered(url, cb)
-		} else {
-			g.Get(url, cb)
-		}
+	// Optional: tile filters, applied before album pages are fetched
+	filter, err := albumFilterFromRequest(q)
+	if err != nil {
+		return nil, &scrapeError{http.StatusBadRequest, err.Error()}
 	}
+	// Politeness toward zonerama.com (rate limit, delay, backoff, robots.txt)
+	polite := politenessFromQuery(ctx, q)
+	cw := newCrawl(polite, params)
 
 	// Concurrency for JS-rendered album requests
 	concurrency := 8 // default
-	if s := r.URL.Query().Get("concurrency"); s != "" {
+	if s := q.Get("concurrency"); s != "" {
 		fmt.Sscanf(s, "%d", &concurrency)
 	}
 	if concurrency < 1 {
@@ -410,24 +534,23 @@ func zoneramaHandler(w http.ResponseWriter, r *http.Request) {
 	if albumLimit > 0 && concurrency > albumLimit {
 		concurrency = albumLimit
 	}
-	// Semaphore to cap concurrent album fetches
-	sem := make(chan struct{}, concurrency)
+	// Caps concurrent album fetches; slots are freed however a fetch ends
+	slots := newAlbumSlots(ctx, concurrency)
 
 	// Shared state for the crawl
 	var (
 		resp Response
 		mu   sync.Mutex
-		wg   sync.WaitGroup
 		seen = make(map[string]bool) // dedupe album URLs
 	)
 	resp.InputLink = link
+	resp.Canonical = canon
+	sels := currentSelectors()
+	trace := newParseTrace(sels)
 
 	// Compile regexes once
 	// In a raw string literal (backticks), use a single backslash for \d
 	photoIDRe := regexp.MustCompile(`(?i)^\d+$`)
-	// Fallback patterns to extract photo IDs
-	rePhotoIDFromHref := regexp.MustCompile(`/Photo/\d+/(\d+)`)
-	rePhotoIDFromImg := regexp.MustCompile(`/photos/(\d+)_`)
 
 	// Albums accumulator
 	addAlbum := func(a Album) {
@@ -437,31 +560,14 @@ func zoneramaHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Prelim info gathered from profile tiles (date, counts) keyed by album URL
-	type prelimInfo struct {
-		Date      string
-		PhotosCnt int
-		ViewsCnt  int
-	}
-	prelim := make(map[string]prelimInfo)
-
-	// Debug helpers
-	sanitizeRe := regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
-	saveDebug := func(stage string, cr *client.Response) {
-		if !debugSave || cr == nil || cr.Request == nil {
-			return
-		}
-		_ = os.MkdirAll("debuging", 0o755)
-		u := cr.Request.URL.String()
-		h := sha1.Sum([]byte(u))
-		short := hex.EncodeToString(h[:6])
-		name := fmt.Sprintf("%s_%s_%s.html", stage, short, sanitizeRe.ReplaceAllString(u, "_"))
-		path := filepath.Join("debuging", name)
-		_ = os.WriteFile(path, cr.Body, 0o644)
-	}
+	prelim := make(map[string]albumTile)
 
 	// Crawl an Album page and collect photos
 	parseAlbum := func(g *geziyor.Geziyor, cr *client.Response) {
-		saveDebug("album", cr)
+		if !responseAllowed(cr) {
+			return
+		}
+		cw.save("album", cr)
 		doc := cr.HTMLDoc
 		if doc == nil {
 			return
@@ -470,108 +576,84 @@ func zoneramaHandler(w http.ResponseWriter, r *http.Request) {
 		album := Album{URL: cr.Request.URL.String()}
 
 		// ID from meta
-		if sel := doc.Find("meta[property='znrm:album']"); sel.Length() > 0 {
-			if v, ok := sel.Attr("content"); ok {
-				album.ID = strings.TrimSpace(v)
-			}
-		}
+		album.ID = sels.Album.ID.value(doc.Selection)
 		// Title from header
-		album.Title = strings.TrimSpace(doc.Find(".row-name-album h2 span").First().Text())
+		album.Title = sels.Album.Title.value(doc.Selection)
 		// Date (normalize to drop leading '|' if present)
-		dateText := strings.TrimSpace(doc.Find(".row-name-album .album-info .hide-on-phone").First().Text())
-		if dateText != "" {
-			dateText = strings.TrimSpace(strings.TrimPrefix(dateText, "|"))
-		}
-		album.Date = dateText
+		album.Date = strings.TrimSpace(strings.TrimPrefix(sels.Album.Date.value(doc.Selection), "|"))
+		album.Cover = coverFromAlbumPage(doc)
 		// Photos count
-		if pc := strings.TrimSpace(doc.Find(".row-name-album [data-id='header-album-photos']").First().Text()); pc != "" {
+		if pc := sels.Album.PhotosCount.value(doc.Selection); pc != "" {
 			fmt.Sscanf(pc, "%d", &album.PhotosCnt)
 		}
 
-		// Photos list: support broader selectors
+		// Photos list: the first gallery item strategy that matches, see Selectors
 		count := 0
-		photoSel := doc.Find("[data-type='photo'][data-id]")
-		if photoSel.Length() == 0 {
-			// fallback: look inside .gallery-inner for any element with data-id
-			photoSel = doc.Find(".gallery-inner [data-id]")
-		}
+		photoSel, rule := firstMatch(sels.Album.Photos, doc.Selection)
+		strategy := rule.Name
+		exts := videoExts(doc)
 		log.Printf("parseAlbum: found %d photo candidates at %s", photoSel.Length(), cr.Request.URL.String())
 		photoSel.Each(func(i int, s *goquery.Selection) {
-			if photoLimit > 0 && count >= photoLimit {
+			if params.MaxPhotos > 0 && count >= params.MaxPhotos {
 				return
 			}
-			pid := strings.TrimSpace(s.AttrOr("data-id", ""))
+			pid := rule.read(s)
 			if pid == "" || !photoIDRe.MatchString(pid) {
 				return
 			}
-			p := Photo{ID: pid}
+			typ := itemType(s)
+			if !mediaAllowed(params.Media, typ) {
+				return
+			}
+			p := Photo{ID: pid, Type: typ}
 			// Optional page URL
-			if a := s.Find("a.gallery-link"); a.Length() > 0 {
-				p.PageURL = a.AttrOr("href", "")
+			if a := sels.Album.PhotoLink.find(s); a.Length() > 0 {
+				p.PageURL = sels.Album.PhotoLink.read(a.First())
 				if strings.HasPrefix(p.PageURL, "/") {
 					p.PageURL = cr.JoinURL(p.PageURL)
 				}
 			}
 			p.Image1500 = fmt.Sprintf("https://%s/photos/%s_1500x1000.jpg", cr.Request.URL.Host, pid)
+			applyGalleryItem(s, &p, params.SizeEdges)
+			if typ == "video" {
+				applyVideoItem(s, &p, exts, cr.JoinURL)
+			}
 			album.Photos = append(album.Photos, p)
 			count++
 		})
 
-		// If none matched, try fallback: anchors with /Photo/<album>/<photo>
-		if count == 0 {
-			fallbackCount := 0
-			doc.Find("a[href*='/Photo/']").Each(func(i int, a *goquery.Selection) {
-				if photoLimit > 0 && count >= photoLimit {
-					return
-				}
-				href := strings.TrimSpace(a.AttrOr("href", ""))
-				if href == "" {
-					return
-				}
-				m := rePhotoIDFromHref.FindStringSubmatch(href)
-				if len(m) < 2 {
+		// If none matched, try the ID-extracting fallbacks (anchors, then images).
+		// Fallbacks cannot tell videos apart, so they only run when photos are wanted
+		for _, fb := range sels.Album.PhotoFallbacks {
+			if count > 0 || params.Media == mediaVideos {
+				break
+			}
+			fb.find(doc.Selection).Each(func(i int, el *goquery.Selection) {
+				if params.MaxPhotos > 0 && count >= params.MaxPhotos {
 					return
 				}
-				pid := m[1]
-				if !photoIDRe.MatchString(pid) {
+				raw := fb.raw(el)
+				pid := fb.match(raw)
+				if pid == "" || !photoIDRe.MatchString(pid) {
 					return
 				}
-				p := Photo{ID: pid, PageURL: href}
-				if strings.HasPrefix(p.PageURL, "/") {
-					p.PageURL = cr.JoinURL(p.PageURL)
+				p := Photo{ID: pid, Type: "photo"}
+				// A link to the photo page doubles as its page_url
+				if fb.Attr == "href" {
+					p.PageURL = raw
+					if strings.HasPrefix(p.PageURL, "/") {
+						p.PageURL = cr.JoinURL(p.PageURL)
+					}
 				}
 				p.Image1500 = fmt.Sprintf("https://%s/photos/%s_1500x1000.jpg", cr.Request.URL.Host, pid)
 				album.Photos = append(album.Photos, p)
 				count++
-				fallbackCount++
+				strategy = fb.Name
 			})
-			if fallbackCount > 0 {
-				log.Printf("parseAlbum: fallback from anchors found %d photos at %s", fallbackCount, cr.Request.URL.String())
-			}
 		}
-
-		// If still none, try images with /photos/<id>_
-		if count == 0 {
-			fallbackCount := 0
-			doc.Find("img[src*='/photos/']").Each(func(i int, img *goquery.Selection) {
-				if photoLimit > 0 && count >= photoLimit {
-					return
-				}
-				src := strings.TrimSpace(img.AttrOr("src", ""))
-				m := rePhotoIDFromImg.FindStringSubmatch(src)
-				if len(m) < 2 {
-					return
-				}
-				pid := m[1]
-				p := Photo{ID: pid}
-				p.Image1500 = fmt.Sprintf("https://%s/photos/%s_1500x1000.jpg", cr.Request.URL.Host, pid)
-				album.Photos = append(album.Photos, p)
-				count++
-				fallbackCount++
-			})
-			if fallbackCount > 0 {
-				log.Printf("parseAlbum: fallback from images found %d photos at %s", fallbackCount, cr.Request.URL.String())
-			}
+		// An empty album is not a parser failure
+		if strategy != strategyNone || album.PhotosCnt > 0 {
+			trace.record(stepAlbumPhotos, strategy, album.URL)
 		}
 
 		// Merge prelim info (from profile tiles) if available
@@ -580,6 +662,13 @@ func zoneramaHandler(w http.ResponseWriter, r *http.Request) {
 			if strings.TrimSpace(album.Date) == "" && strings.TrimSpace(pi.Date) != "" {
 				album.Date = strings.TrimSpace(pi.Date)
 			}
+			// The tile cover is what the profile shows
+			if pi.Cover != nil {
+				album.Cover = pi.Cover
+			}
+			if album.Title == "" && pi.Title != "" {
+				album.Title = pi.Title
+			}
 			if album.PhotosCnt == 0 && pi.PhotosCnt > 0 {
 				album.PhotosCnt = pi.PhotosCnt
 			}
@@ -588,192 +677,191 @@ func zoneramaHandler(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 		mu.Unlock()
+		lang, loc := pageDateContext(doc)
+		setAlbumDate(&album, lang, loc)
 
+		var more bool
+		album.Photos, more = pagePhotos(album.Photos, params.PhotoOffset, params.PhotoLimit)
+		if !params.IncludePhotos {
+			album.Photos, more = []Photo{}, false
+		}
+		if exifs != nil {
+			for _, p := range album.Photos {
+				exifs.fetch(g, polite, cr, p)
+			}
+		}
+		if likes != nil {
+			likes.crawlAlbum(g, polite, cr, album)
+		}
+		mu.Lock()
+		morePhotos = morePhotos || more
+		mu.Unlock()
 		addAlbum(album)
 	}
 	parseProfile := func(g *geziyor.Geziyor, cr *client.Response) {
-		saveDebug("profile", cr)
+		cw.save("profile", cr)
 		doc := cr.HTMLDoc
 		if doc == nil {
 			return
 		}
-		// Albums tiles: try multiple selectors
 		count := 0
-		albumTiles := doc.Find("li.list-alb")
-		if albumTiles.Length() == 0 {
-			albumTiles = doc.Find("[data-type='album'], li[class*='list-alb']")
-		}
-		log.Printf("parseProfile: found %d album candidates at %s", albumTiles.Length(), cr.Request.URL.String())
-		// First collect entries with prelim info
-		type entry struct {
-			URL  string
-			Info prelimInfo
-		}
-		var entries []entry
-		albumTiles.Each(func(i int, s *goquery.Selection) {
-			albumURL := strings.TrimSpace(s.AttrOr("data-url", ""))
-			if albumURL == "" {
-				// fallback to anchor
-				albumURL = strings.TrimSpace(s.Find("a.thumbnail").AttrOr("href", ""))
-				if albumURL == "" {
-					// broader fallback: first anchor in tile
-					albumURL = strings.TrimSpace(s.Find("a").AttrOr("href", ""))
-				}
-			}
-			if albumURL == "" {
-				return
-			}
-			if strings.HasPrefix(albumURL, "/") {
-				albumURL = cr.JoinURL(albumURL)
-			}
-			// Extract date, photos count and views count from the tile's <p> block
-			dateText := ""
-			photosCount := 0
-			viewsCount := 0
-			if p := s.Find("p").First(); p.Length() > 0 {
-				full := strings.TrimSpace(p.Text())
-				if full != "" {
-					parts := strings.Split(full, "|")
-					if len(parts) > 0 {
-						dateText = strings.TrimSpace(parts[0])
-					}
-				}
-				spans := p.Find("span")
-				if spans.Length() > 0 {
-					fmt.Sscanf(strings.TrimSpace(spans.Eq(0).Text()), "%d", &photosCount)
-				}
-				if spans.Length() > 1 {
-					fmt.Sscanf(strings.TrimSpace(spans.Eq(1).Text()), "%d", &viewsCount)
+		lang, loc := pageDateContext(doc)
+		now := time.Now()
+		skipped := 0
+		// Collect the tiles the filters keep
+		var entries []albumTile
+		for _, t := range parseTiles(sels, doc.Selection, cr.Request.URL.String(), cr.JoinURL, trace) {
+			if filter != nil {
+				d, _, ok := parseZoneramaDate(t.Date, lang, loc, now)
+				if !filter.match(t.ID, t.Title, d, ok, t.PhotosCnt) {
+					skipped++
+					continue
 				}
 			}
-			entries = append(entries, entry{URL: albumURL, Info: prelimInfo{Date: dateText, PhotosCnt: photosCount, ViewsCnt: viewsCount}})
-		})
-		// Sort entries by date descending (newest first)
+			entries = append(entries, t)
+		}
+		if skipped > 0 {
+			log.Printf("parseProfile: filters skipped %d albums at %s", skipped, cr.Request.URL.String())
+		}
+		// Sort entries by the requested order
 		parseDate := func(s string) (time.Time, bool) {
-			s = strings.TrimSpace(s)
-			if s == "" {
-				return time.Time{}, false
-			}
-			layouts := []string{"2. 1. 2006", "2. 1.2006", "2.1.2006", "02.01.2006"}
-			for _, layout := range layouts {
-				if t, err := time.Parse(layout, s); err == nil {
-					return t, true
-				}
-			}
-			return time.Time{}, false
+			t, _, ok := parseZoneramaDate(s, lang, loc, now)
+			return t, ok
+		}
+		tileKey := func(e albumTile) albumSortKey {
+			t, ok := parseDate(e.Date)
+			return albumSortKey{Title: e.Title, Date: t, HasDate: ok, Views: e.ViewsCnt, Photos: e.PhotosCnt, Order: e.Order, URL: e.URL}
 		}
 		sort.SliceStable(entries, func(i, j int) bool {
-			ti, oi := parseDate(entries[i].Info.Date)
-			tj, oj := parseDate(entries[j].Info.Date)
-			if oi && oj {
-				return ti.After(tj)
-			}
-			if oi {
-				return true
-			}
-			if oj {
-				return false
-			}
-			return entries[i].URL < entries[j].URL
+			return lessAlbums(params.Sort, tileKey(entries[i]), tileKey(entries[j]))
 		})
-		// Enqueue requests in sorted order, honoring albumLimit
-		for _, e := range entries {
+		// Enqueue requests in sorted order, starting at the album offset and honoring albumLimit
+		mu.Lock()
+		profileSeen = true
+		mu.Unlock()
+		tileAlbum := func(e albumTile) Album {
+			a := Album{ID: e.ID, Title: e.Title, URL: e.URL, Cover: e.Cover, Date: e.Date, PhotosCnt: e.PhotosCnt, ViewsCnt: e.ViewsCnt, Photos: []Photo{}}
+			setAlbumDate(&a, lang, loc)
+			return a
+		}
+		unchanged := 0
+		for i, e := range entries {
+			if i < params.AlbumOffset {
+				continue
+			}
 			if albumLimit > 0 && count >= albumLimit {
+				mu.Lock()
+				tilesLeft = len(entries) - i
+				nextAlbum = i
+				mu.Unlock()
 				break
 			}
 			// Save prelim info for this album URL
 			mu.Lock()
-			prelim[e.URL] = e.Info
+			prelim[e.URL] = e
 			if seen[e.URL] {
 				mu.Unlock()
 				continue
 			}
 			seen[e.URL] = true
 			mu.Unlock()
+			// Incremental: a stored album with the same photo count is taken from its tile
+			// and does not count toward album_limit
+			if mode == modeIncremental && e.ID != "" && e.PhotosCnt > 0 {
+				if n, ok := storedCounts[e.ID]; ok && n == e.PhotosCnt {
+					a := tileAlbum(e)
+					a.Unchanged = true
+					addAlbum(a)
+					unchanged++
+					continue
+				}
+			}
 			count++
-			// Acquire a slot before starting the JS-rendered request
-			sem <- struct{}{}
-			wg.Add(1)
-			g.GetRendered(e.URL, func(g2 *geziyor.Geziyor, r2 *client.Response) {
-				defer func() { <-sem; wg.Done() }()
-				parseAlbum(g2, r2)
-			})
+			// Tiles only: the album is built from what the profile shows
+			if !params.IncludePhotos {
+				addAlbum(tileAlbum(e))
+				continue
+			}
+			// The JS-rendered request waits for a slot in its own goroutine
+			slots.get(g, e.URL, polite.retrying(parseAlbum))
+		}
+		if unchanged > 0 {
+			log.Printf("parseProfile: incremental kept %d unchanged albums from tiles at %s", unchanged, cr.Request.URL.String())
 		}
 	}
 
 	// Router: decide whether current page is a profile or an album and call appropriate parser
 	parseRouter := func(g *geziyor.Geziyor, cr *client.Response) {
-		saveDebug("router", cr)
+		if !responseAllowed(cr) {
+			return
+		}
+		cw.save("router", cr)
 		doc := cr.HTMLDoc
 		if doc == nil {
 			return
 		}
 		// Heuristics: prefer PROFILE when profile markers exist; ALBUM only with strong markers
-		if doc.Find("li.list-alb, #profile-albums").Length() > 0 {
+		if doc.Find(sels.Router.Profile).Length() > 0 {
 			log.Printf("router: classified as PROFILE -> %s", cr.Request.URL.String())
+			trace.record(stepRouter, "profile-markers", cr.Request.URL.String())
 			parseProfile(g, cr)
 			return
 		}
-		if doc.Find("meta[property='znrm:album']").Length() > 0 || doc.Find(".row-name-album").Length() > 0 {
+		if doc.Find(sels.Router.Album).Length() > 0 {
 			log.Printf("router: classified as ALBUM -> %s", cr.Request.URL.String())
+			trace.record(stepRouter, "album-markers", cr.Request.URL.String())
 			parseAlbum(g, cr)
 			return
 		}
 		// Default to profile for safety
-		log.Printf("router: defaulting to PROFILE -> %s", cr.Request.URL.String())
+		trace.record(stepRouter, "default-profile", cr.Request.URL.String())
 		parseProfile(g, cr)
 	}
-	gz := geziyor.NewGeziyor(&geziyor.Options{
-		StartRequestsFunc: func(g *geziyor.Geziyor) {
-			doGet(g, link, parseRouter)
-		},
-		ParseFunc:         parseRouter,
-		RetryTimes:        2,
-		Timeout:           30 * time.Second,
-		LogDisabled:       true,
-		RobotsTxtDisabled: true,
-	})
-
+	opt := cw.options(startURL, parseRouter)
+	opt.RequestMiddlewares = append(opt.RequestMiddlewares, slots)
+	opt.ResponseMiddlewares = []middleware.ResponseProcessor{slots}
+	opt.ErrorFunc = func(g *geziyor.Geziyor, r *client.Request, err error) {
+		slots.release(r)
+		log.Printf("crawl: %s: %v", r.URL.String(), err)
+	}
+	gz := newCrawler(opt)
+	// Start returns once every request, album pages included, has finished
 	gz.Start()
-	// Wait for all album requests to complete
-	wg.Wait()
-
-	// Sort albums by date (descending) using the date format from profile tiles like "20. 9. 2025"
-	parseCzDate := func(s string) (time.Time, bool) {
-		s = strings.TrimSpace(s)
-		if s == "" {
-			return time.Time{}, false
-		}
-		layouts := []string{
-			"2. 1. 2006",
-			"2. 1.2006",
-			"2.1.2006",
-			"02.01.2006",
-		}
-		for _, layout := range layouts {
-			if t, err := time.Parse(layout, s); err == nil {
-				return t, true
-			}
-		}
-		return time.Time{}, false
+	if exifs != nil {
+		exifs.apply(resp.Albums)
+	}
+	if likes != nil {
+		likes.apply(resp.Albums)
+	}
+
+	// Sort albums the same way as the tiles they were picked from
+	albumKey := func(a Album) albumSortKey {
+		t, ok := albumTime(a)
+		return albumSortKey{Title: a.Title, Date: t, HasDate: ok, Views: a.ViewsCnt, Photos: a.PhotosCnt, Order: prelim[a.URL].Order, URL: a.URL}
 	}
 	sort.SliceStable(resp.Albums, func(i, j int) bool {
-		ti, oi := parseCzDate(resp.Albums[i].Date)
-		tj, oj := parseCzDate(resp.Albums[j].Date)
-		if oi && oj {
-			return ti.After(tj)
-		}
-		if oi {
-			return true
-		}
-		if oj {
-			return false
-		}
-		// Fallback: by title
-		return resp.Albums[i].Title < resp.Albums[j].Title
+		return lessAlbums(params.Sort, albumKey(resp.Albums[i]), albumKey(resp.Albums[j]))
 	})
+	switch {
+	case tilesLeft > 0:
+		resp.NextCursor = pageCursor{Link: canon.URL, Sort: params.Sort, Albums: nextAlbum, Photos: params.PhotoOffset}.encode()
+	case !profileSeen && morePhotos:
+		resp.NextCursor = pageCursor{Link: canon.URL, Sort: params.Sort, Photos: params.PhotoOffset + params.PhotoLimit}.encode()
+	}
 
-	enc := json.NewEncoder(w)
-	enc.SetIndent("", "  ")
-	_ = enc.Encode(resp)
+	resp.Parser, resp.Warnings = trace.report()
+	if store {
+		parserTotals.add(resp.Parser.Strategies)
+		storeResponse(&resp, q)
+	}
+	if params.Dupes {
+		resp.Duplicates = findDuplicates(ctx, &resp, params.DupeDistance)
+	}
+	if params.Export != "" {
+		if resp.Export, err = exportJSON(ctx, canon, resp); err != nil {
+			log.Printf("storage: exporting %s: %v", link, err)
+			return nil, &scrapeError{http.StatusBadGateway, "export failed: " + err.Error()}
+		}
+	}
+	return &resp, nil
 }
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Album orders for sort=. A leading "-" reverses the order; profile_order keeps
+// the order of the profile tiles.
+var albumSorts = map[string]bool{
+	"date": true, "-date": true, "title": true, "-title": true,
+	"views": true, "-views": true, "photos": true, "-photos": true,
+	"profile_order": true, "-profile_order": true,
+}
+
+const defaultAlbumSort = "-date"
+
+// albumSortKey holds what an album can be sorted by, from a tile or an album page.
+type albumSortKey struct {
+	Title   string
+	Date    time.Time
+	HasDate bool
+	Views   int
+	Photos  int
+	Order   int // position among the profile tiles
+	URL     string
+}
+
+// lessAlbums orders two albums by mode. Albums without a date sort last in
+// either direction; ties fall back to title, then URL.
+func lessAlbums(mode string, a, b albumSortKey) bool {
+	desc := strings.HasPrefix(mode, "-")
+	cmp := 0
+	switch strings.TrimPrefix(mode, "-") {
+	case "date":
+		if a.HasDate != b.HasDate {
+			return a.HasDate
+		}
+		cmp = a.Date.Compare(b.Date)
+	case "title":
+		cmp = strings.Compare(strings.ToLower(a.Title), strings.ToLower(b.Title))
+	case "views":
+		cmp = a.Views - b.Views
+	case "photos":
+		cmp = a.Photos - b.Photos
+	case "profile_order":
+		cmp = a.Order - b.Order
+	}
+	if cmp != 0 {
+		return (cmp < 0) != desc
+	}
+	if a.Title != b.Title {
+		return a.Title < b.Title
+	}
+	return a.URL < b.URL
+}
+
+// pageCursor is the opaque next_cursor: where the next page starts, bound to
+// the link and sort it was issued for.
+type pageCursor struct {
+	Link   string `json:"l"`
+	Sort   string `json:"s"`
+	Albums int    `json:"a,omitempty"`
+	Photos int    `json:"p,omitempty"`
+}
+
+func (c pageCursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (pageCursor, error) {
+	var c pageCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(b, &c) != nil || c.Albums < 0 || c.Photos < 0 {
+		return c, errors.New("invalid cursor")
+	}
+	return c, nil
+}
+
+// pageFromRequest reads sort, offset, photo_offset and cursor. A cursor takes
+// precedence over the offsets, must belong to the same link and carries its
+// sort; an explicit sort only has to agree with it.
+func pageFromRequest(q url.Values, link string) (sortMode string, albumOffset, photoOffset int, err error) {
+	sortMode = defaultAlbumSort
+	explicit := strings.TrimSpace(q.Get("sort"))
+	if explicit != "" {
+		if !albumSorts[explicit] {
+			return "", 0, 0, fmt.Errorf("invalid sort %q, use date, -date, title, views, photos or profile_order", explicit)
+		}
+		sortMode = explicit
+	}
+	if s := q.Get("cursor"); s != "" {
+		c, err := decodeCursor(s)
+		if err != nil || !albumSorts[c.Sort] {
+			return "", 0, 0, errors.New("invalid cursor")
+		}
+		if c.Link != link {
+			return "", 0, 0, errors.New("cursor does not match link")
+		}
+		if explicit != "" && explicit != c.Sort {
+			return "", 0, 0, fmt.Errorf("cursor was issued for sort %q, not %q", c.Sort, explicit)
+		}
+		return c.Sort, c.Albums, c.Photos, nil
+	}
+	for _, p := range []struct {
+		name string
+		dst  *int
+	}{{"offset", &albumOffset}, {"photo_offset", &photoOffset}} {
+		if s := q.Get(p.name); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 {
+				return "", 0, 0, fmt.Errorf("invalid %s %q", p.name, s)
+			}
+			*p.dst = n
+		}
+	}
+	return sortMode, albumOffset, photoOffset, nil
+}
+
+// photoCap is how many photos to collect from an album page to serve one page:
+// the skipped ones, the page itself and one more to tell if there is a next page.
+func photoCap(offset, limit int) int {
+	if limit <= 0 {
+		return 0
+	}
+	return offset + limit + 1
+}
+
+// pagePhotos cuts one page out of the collected photos and reports whether more follow.
+func pagePhotos(photos []Photo, offset, limit int) ([]Photo, bool) {
+	if offset >= len(photos) {
+		return []Photo{}, false
+	}
+	photos = photos[offset:]
+	if limit > 0 && len(photos) > limit {
+		return photos[:limit], true
+	}
+	return photos, false
+}
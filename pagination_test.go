@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLessAlbums(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2025, 8, d, 0, 0, 0, 0, time.UTC) }
+	keys := []albumSortKey{
+		{Title: "b", Date: day(2), HasDate: true, Views: 5, Photos: 30, Order: 0, URL: "u1"},
+		{Title: "A", Date: day(3), HasDate: true, Views: 9, Photos: 10, Order: 1, URL: "u2"},
+		{Title: "c", Views: 1, Photos: 20, Order: 2, URL: "u3"},
+		{Title: "d", Date: day(1), HasDate: true, Views: 5, Photos: 40, Order: 3, URL: "u4"},
+	}
+	tests := []struct {
+		mode string
+		want string // titles in order
+	}{
+		// Albums without a date come last either way
+		{"date", "dbAc"},
+		{"-date", "Abdc"},
+		{"title", "Abcd"},
+		{"-title", "dcbA"},
+		// Equal views fall back to the title
+		{"views", "cbdA"},
+		{"-views", "Abdc"},
+		{"photos", "Acbd"},
+		{"profile_order", "bAcd"},
+		{"-profile_order", "dcAb"},
+	}
+	for _, tt := range tests {
+		got := slices.Clone(keys)
+		slices.SortStableFunc(got, func(a, b albumSortKey) int {
+			if lessAlbums(tt.mode, a, b) {
+				return -1
+			}
+			if lessAlbums(tt.mode, b, a) {
+				return 1
+			}
+			return 0
+		})
+		var titles strings.Builder
+		for _, k := range got {
+			titles.WriteString(k.Title)
+		}
+		if titles.String() != tt.want {
+			t.Errorf("sort=%s: got %s, want %s", tt.mode, titles.String(), tt.want)
+		}
+	}
+}
+
+func TestPageFromRequest(t *testing.T) {
+	link := "https://eu.zonerama.com/FKKofolaKrnov/1"
+	cursor := pageCursor{Link: link, Sort: "title", Albums: 10, Photos: 5}.encode()
+	tests := []struct {
+		query          string
+		sort           string
+		albums, photos int
+		err            string
+	}{
+		{"", defaultAlbumSort, 0, 0, ""},
+		{"sort=views&offset=3&photo_offset=7", "views", 3, 7, ""},
+		{"sort=size", "", 0, 0, "invalid sort"},
+		{"offset=-1", "", 0, 0, "invalid offset"},
+		{"photo_offset=x", "", 0, 0, "invalid photo_offset"},
+		// The cursor carries its sort and wins over the offsets
+		{"cursor=" + cursor + "&offset=99", "title", 10, 5, ""},
+		{"cursor=" + cursor + "&sort=title", "title", 10, 5, ""},
+		{"cursor=" + cursor + "&sort=-date", "", 0, 0, "was issued for sort"},
+		{"cursor=garbage!", "", 0, 0, "invalid cursor"},
+		{"cursor=" + pageCursor{Link: link, Sort: "size"}.encode(), "", 0, 0, "invalid cursor"},
+		{"cursor=" + pageCursor{Link: link, Sort: "date", Albums: -1}.encode(), "", 0, 0, "invalid cursor"},
+		{"cursor=" + pageCursor{Link: "https://eu.zonerama.com/Other/1", Sort: "date"}.encode(), "", 0, 0, "does not match link"},
+	}
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.query)
+		sort, albums, photos, err := pageFromRequest(q, link)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%q: got error %v, want %q", tt.query, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || sort != tt.sort || albums != tt.albums || photos != tt.photos {
+			t.Errorf("%q: got %s %d %d %v, want %s %d %d", tt.query, sort, albums, photos, err, tt.sort, tt.albums, tt.photos)
+		}
+	}
+}
+
+func TestPagePhotos(t *testing.T) {
+	photos := fixturePhotos(t, "snippet3.html") // 22 gallery items
+	tests := []struct {
+		offset, limit int
+		n             int
+		more          bool
+	}{
+		{0, 10, 10, true},
+		{20, 10, 2, false},
+		{12, 10, 10, false},
+		{0, 0, 22, false},
+		{22, 10, 0, false},
+		{30, 10, 0, false},
+	}
+	for _, tt := range tests {
+		// The crawl collects photoCap photos, so that is what pagePhotos gets
+		in := photos
+		if c := photoCap(tt.offset, tt.limit); c > 0 && c < len(in) {
+			in = in[:c]
+		}
+		page, more := pagePhotos(in, tt.offset, tt.limit)
+		if len(page) != tt.n || more != tt.more {
+			t.Errorf("offset %d limit %d: got %d photos, more %v, want %d, %v", tt.offset, tt.limit, len(page), more, tt.n, tt.more)
+		}
+		if tt.n > 0 && page[0].ID != photos[tt.offset].ID {
+			t.Errorf("offset %d: page starts at %s, want %s", tt.offset, page[0].ID, photos[tt.offset].ID)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// scrapeParams are the query parameters /zonerama, /zonerama-album and
+// /zonerama-photo share. Profile-only ones (album_limit, filters, mode,
+// concurrency) are read in scrapeProfile.
+type scrapeParams struct {
+	PhotoLimit    int // 0 = no limit
+	Sort          string
+	AlbumOffset   int
+	PhotoOffset   int
+	MaxPhotos     int // photos to collect per album page, see photoCap
+	SizeEdges     []int
+	Media         string
+	Export        string
+	Dupes         bool
+	DupeDistance  int
+	IncludePhotos bool
+	Debug         bool
+	Exif          bool
+	ExifJPEG      bool
+	Likes         bool
+	Likers        bool
+	Rendered      bool
+}
+
+// parseScrapeParams reads and validates the shared parameters; link is the
+// canonical URL cursors must belong to.
+func parseScrapeParams(q url.Values, link string) (*scrapeParams, error) {
+	p := &scrapeParams{PhotoLimit: 10, IncludePhotos: true, Rendered: true}
+	if s := q.Get("photo_limit"); s != "" {
+		fmt.Sscanf(s, "%d", &p.PhotoLimit)
+	}
+	// Sorting and paging: sort, offset/photo_offset or cursor
+	var err error
+	if p.Sort, p.AlbumOffset, p.PhotoOffset, err = pageFromRequest(q, link); err != nil {
+		return nil, err
+	}
+	p.MaxPhotos = photoCap(p.PhotoOffset, p.PhotoLimit)
+	// fields= is applied when writing the response, but rejected early when invalid
+	if _, err := parseFields(q.Get("fields")); err != nil {
+		return nil, err
+	}
+	// Extra renditions by long edge, sizes=750,1500,3000
+	if p.SizeEdges, err = parseSizesParam(q.Get("sizes")); err != nil {
+		return nil, err
+	}
+	if p.Media, err = parseMediaParam(q.Get("media")); err != nil {
+		return nil, err
+	}
+	// export=json also writes the response to storage
+	if p.Export, err = parseExportParam(q.Get("export")); err != nil {
+		return nil, err
+	}
+	// dupes=true hashes every photo's thumbnail and groups near-duplicates
+	p.Dupes, _ = strconv.ParseBool(q.Get("dupes"))
+	if p.DupeDistance, err = parseDupeDistance(q.Get("dupe_distance")); err != nil {
+		return nil, err
+	}
+	if b, err := strconv.ParseBool(q.Get("include_photos")); err == nil {
+		p.IncludePhotos = b
+	}
+	p.Debug, _ = strconv.ParseBool(q.Get("debug"))
+	// EXIF per photo (one extra request per photo), exif_jpeg=true falls back to the image bytes
+	p.Exif, _ = strconv.ParseBool(q.Get("exif"))
+	p.ExifJPEG, _ = strconv.ParseBool(q.Get("exif_jpeg"))
+	// Like counts (likes=true) and liking accounts (likers=true, implies likes)
+	p.Likes, _ = strconv.ParseBool(q.Get("likes"))
+	p.Likers, _ = strconv.ParseBool(q.Get("likers"))
+	// Rendering: default true, disabled with rendered=false or no-render/no_render=true
+	if b, err := strconv.ParseBool(q.Get("rendered")); err == nil {
+		p.Rendered = b
+	}
+	for _, name := range []string{"no-render", "no_render"} {
+		if b, err := strconv.ParseBool(q.Get(name)); err == nil && b {
+			p.Rendered = false
+		}
+	}
+	return p, nil
+}
+
+// collectors returns the EXIF and likes collectors the parameters ask for, nil otherwise.
+func (p *scrapeParams) collectors() (*exifCollector, *likesCollector) {
+	var exifs *exifCollector
+	if p.Exif {
+		exifs = newExifCollector(p.ExifJPEG)
+	}
+	var likes *likesCollector
+	if p.Likes || p.Likers {
+		likes = newLikesCollector(p.Likers)
+	}
+	return exifs, likes
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Parse steps and their selector strategies. Each step tries the strategies
+// named in the active Selectors in order; anything after the first is a
+// fallback: it still produces results, but usually means Zonerama changed its
+// markup.
+const (
+	stepRouter       = "router"
+	stepProfileTiles = "profile_tiles"
+	stepTileURL      = "tile_url"
+	stepAlbumPhotos  = "album_photos"
+
+	strategyNone = "none" // nothing matched
+)
+
+// ParserInfo counts which strategy matched, per step, over one response.
+type ParserInfo struct {
+	SelectorsRevision string                    `json:"selectors_revision,omitempty"`
+	Strategies        map[string]map[string]int `json:"strategies"`
+}
+
+// parseTrace collects the strategies used while crawling one request.
+type parseTrace struct {
+	mu       sync.Mutex
+	revision string
+	primary  map[string]string // first strategy per step in the selectors used
+	counts   map[string]map[string]int
+	pages    map[string]string // first page per step/strategy, for the warning
+}
+
+func newParseTrace(sels *Selectors) *parseTrace {
+	primary := map[string]string{}
+	if len(sels.Profile.Tiles) > 0 {
+		primary[stepProfileTiles] = sels.Profile.Tiles[0].Name
+	}
+	if len(sels.Profile.TileURL) > 0 {
+		primary[stepTileURL] = sels.Profile.TileURL[0].Name
+	}
+	if len(sels.Album.Photos) > 0 {
+		primary[stepAlbumPhotos] = sels.Album.Photos[0].Name
+	}
+	return &parseTrace{revision: sels.Revision, primary: primary, counts: map[string]map[string]int{}, pages: map[string]string{}}
+}
+
+// The router legitimately picks either page kind, so only its last resort is a fallback.
+func (t *parseTrace) isFallback(step, strategy string) bool {
+	if strategy == strategyNone {
+		return true
+	}
+	if step == stepRouter {
+		return strategy == "default-profile"
+	}
+	p, ok := t.primary[step]
+	return ok && strategy != p
+}
+
+// record notes that strategy matched step on page. Fallbacks are logged once per request.
+func (t *parseTrace) record(step, strategy, page string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.counts[step] == nil {
+		t.counts[step] = map[string]int{}
+	}
+	t.counts[step][strategy]++
+	if _, ok := t.pages[step+"/"+strategy]; !ok {
+		t.pages[step+"/"+strategy] = page
+		if t.isFallback(step, strategy) {
+			log.Printf("parser: %s used %s at %s", step, strategy, page)
+		}
+	}
+}
+
+// report returns the counts for the response and a warning per fallback used.
+func (t *parseTrace) report() (*ParserInfo, []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	info := &ParserInfo{SelectorsRevision: t.revision, Strategies: map[string]map[string]int{}}
+	var warnings []string
+	for step, byStrategy := range t.counts {
+		info.Strategies[step] = map[string]int{}
+		for strategy, n := range byStrategy {
+			info.Strategies[step][strategy] = n
+			if !t.isFallback(step, strategy) {
+				continue
+			}
+			if strategy == strategyNone {
+				warnings = append(warnings, fmt.Sprintf("%s: no selector matched %d time(s), first at %s", step, n, t.pages[step+"/"+strategy]))
+			} else {
+				warnings = append(warnings, fmt.Sprintf("%s: fell back to %s %d time(s), first at %s", step, strategy, n, t.pages[step+"/"+strategy]))
+			}
+		}
+	}
+	sort.Strings(warnings)
+	return info, warnings
+}
+
+// parserTotals sums strategy counts over every scrape since startup.
+var parserTotals = &strategyTotals{counts: map[string]map[string]int{}}
+
+type strategyTotals struct {
+	mu     sync.Mutex
+	counts map[string]map[string]int
+}
+
+func (s *strategyTotals) add(counts map[string]map[string]int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for step, byStrategy := range counts {
+		if s.counts[step] == nil {
+			s.counts[step] = map[string]int{}
+		}
+		for strategy, n := range byStrategy {
+			s.counts[step][strategy] += n
+		}
+	}
+}
+
+func (s *strategyTotals) snapshot() map[string]map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]map[string]int, len(s.counts))
+	for step, byStrategy := range s.counts {
+		out[step] = make(map[string]int, len(byStrategy))
+		for strategy, n := range byStrategy {
+			out[step][strategy] = n
+		}
+	}
+	return out
+}
+
+// The canary is a known album (and optionally a profile) scraped on demand to
+// check that every marker the parser relies on is still there.
+var (
+	canaryAlbum     = envString("ZONERAMA_CANARY_ALBUM", "")
+	canaryProfile   = envString("ZONERAMA_CANARY_PROFILE", "")
+	canaryMinPhotos = envInt("ZONERAMA_CANARY_MIN_PHOTOS", 1)
+	canaryTTL       = envMillis("ZONERAMA_CANARY_TTL_MS", 10*time.Minute)
+)
+
+// ParserHealth is the /health/parser result. Status is ok, degraded (a fallback
+// was needed) or failing (a marker is missing).
+type ParserHealth struct {
+	Status     string                    `json:"status"`
+	CheckedAt  string                    `json:"checked_at"`
+	DurationMS int64                     `json:"duration_ms"`
+	Checks     []ParserCheck             `json:"checks"`
+	Warnings   []string                  `json:"warnings,omitempty"`
+	Strategies map[string]map[string]int `json:"strategies,omitempty"` // used by the canary scrape
+	SinceStart map[string]map[string]int `json:"since_start"`          // used by every scrape since startup
+}
+
+type ParserCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+var canaryCache struct {
+	sync.Mutex
+	result *ParserHealth
+	at     time.Time
+}
+
+// parserHealthHandler serves GET /health/parser. Results are cached for
+// ZONERAMA_CANARY_TTL_MS; refresh=true scrapes again. Failing checks answer 503.
+func parserHealthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if canaryAlbum == "" && canaryProfile == "" {
+		writeJSONError(w, http.StatusNotFound, "parser canary is disabled; set ZONERAMA_CANARY_ALBUM to a known album link")
+		return
+	}
+	refresh, _ := strconv.ParseBool(r.URL.Query().Get("refresh"))
+
+	canaryCache.Lock()
+	defer canaryCache.Unlock()
+	h := canaryCache.result
+	if h == nil || refresh || time.Since(canaryCache.at) > canaryTTL {
+		release, ok := crawlSlots.acquire(r.Context())
+		if !ok {
+			if r.Context().Err() != nil {
+				return
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(crawlSlots.retryAfter()))
+			writeJSONError(w, http.StatusServiceUnavailable, "server busy: too many crawls in progress, retry later")
+			return
+		}
+		h = runParserCanary(r.Context())
+		release()
+		canaryCache.result, canaryCache.at = h, time.Now()
+	}
+	out := *h
+	out.SinceStart = parserTotals.snapshot()
+	if out.Status == "failing" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	writeJSON(w, r, out)
+}
+
+// runParserCanary scrapes the canary links in-process, without storing them, and
+// checks the markers.
+func runParserCanary(ctx context.Context) *ParserHealth {
+	start := time.Now()
+	h := &ParserHealth{Status: "ok", CheckedAt: start.UTC().Format(time.RFC3339), Strategies: map[string]map[string]int{}}
+	// Details name the selectors in use, which a ZONERAMA_SELECTORS file may have changed
+	sels := currentSelectors()
+	check := func(name string, ok bool, detail string, args ...any) {
+		c := ParserCheck{Name: name, OK: ok}
+		if !ok {
+			c.Detail = fmt.Sprintf(detail, args...)
+		}
+		h.Checks = append(h.Checks, c)
+	}
+
+	if canaryAlbum != "" {
+		resp, err := scrapeAlbum(ctx, url.Values{
+			"link": {canaryAlbum}, "photo_limit": {strconv.Itoa(max(canaryMinPhotos, 1))},
+		}, false)
+		switch {
+		case err != nil:
+			check("album_scrape", false, "%v", err)
+		case len(resp.Albums) == 0:
+			check("album_scrape", false, "no album parsed from %s", canaryAlbum)
+		default:
+			a := resp.Albums[0]
+			check("album_scrape", true, "")
+			check("album_id", a.ID != "", "no album ID from album.id %s", sels.Album.ID.describe())
+			check("album_title", a.Title != "", "no title from album.title %s", sels.Album.Title.describe())
+			check("album_date", a.DateISO != "", "date %q from album.date %s could not be read", a.Date, sels.Album.Date.describe())
+			check("album_photos_count", a.PhotosCnt > 0, "no count from album.photos_count %s", sels.Album.PhotosCount.describe())
+			check("album_photos", len(a.Photos) >= canaryMinPhotos, "%d photos found with album.photos %s, want at least %d",
+				len(a.Photos), describeRules(sels.Album.Photos), canaryMinPhotos)
+			sized := len(a.Photos) > 0
+			for _, p := range a.Photos {
+				sized = sized && p.Width > 0 && p.ImagePattern != ""
+			}
+			check("photo_attributes", sized, "gallery items lack data-width or data-image-pattern")
+			mergeStrategies(h, resp)
+		}
+	}
+	if canaryProfile != "" {
+		resp, err := scrapeProfile(ctx, url.Values{
+			"link": {canaryProfile}, "album_limit": {"1"}, "include_photos": {"false"},
+		}, false)
+		switch {
+		case err != nil:
+			check("profile_scrape", false, "%v", err)
+		default:
+			check("profile_scrape", true, "")
+			check("profile_tiles", len(resp.Albums) > 0, "no album tiles from profile.tiles %s at %s", describeRules(sels.Profile.Tiles), canaryProfile)
+			check("tile_counts", len(resp.Albums) > 0 && resp.Albums[0].PhotosCnt > 0, "no photo count in profile.tile_info %q, profile.tile_counts %q",
+				sels.Profile.TileInfo, sels.Profile.TileCounts)
+			mergeStrategies(h, resp)
+		}
+	}
+
+	for _, c := range h.Checks {
+		if !c.OK {
+			h.Status = "failing"
+		}
+	}
+	if h.Status == "ok" && len(h.Warnings) > 0 {
+		h.Status = "degraded"
+	}
+	h.DurationMS = time.Since(start).Milliseconds()
+	if h.Status != "ok" {
+		log.Printf("parser canary: %s", h.Status)
+	}
+	return h
+}
+
+func mergeStrategies(h *ParserHealth, resp *Response) {
+	h.Warnings = append(h.Warnings, resp.Warnings...)
+	if resp.Parser == nil {
+		return
+	}
+	for step, byStrategy := range resp.Parser.Strategies {
+		if h.Strategies[step] == nil {
+			h.Strategies[step] = map[string]int{}
+		}
+		for strategy, n := range byStrategy {
+			h.Strategies[step][strategy] += n
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTraceReport(t *testing.T) {
+	tr := newParseTrace(currentSelectors())
+	tr.record(stepRouter, "profile", "p1")
+	tr.record(stepProfileTiles, "list-alb", "p1")
+	tr.record(stepTileURL, "data-url", "p1")
+	tr.record(stepTileURL, "thumbnail-anchor", "p2")
+	tr.record(stepTileURL, "thumbnail-anchor", "p3")
+	tr.record(stepAlbumPhotos, strategyNone, "a1")
+
+	info, warnings := tr.report()
+	want := map[string]map[string]int{
+		stepRouter:       {"profile": 1},
+		stepProfileTiles: {"list-alb": 1},
+		stepTileURL:      {"data-url": 1, "thumbnail-anchor": 2},
+		stepAlbumPhotos:  {strategyNone: 1},
+	}
+	if !reflect.DeepEqual(info.Strategies, want) {
+		t.Errorf("strategies: got %v, want %v", info.Strategies, want)
+	}
+	// Only the fallback and the miss warn, naming the first page each was seen on
+	if len(warnings) != 2 ||
+		!strings.Contains(warnings[0], "album_photos: no selector matched 1 time(s), first at a1") ||
+		!strings.Contains(warnings[1], "tile_url: fell back to thumbnail-anchor 2 time(s), first at p2") {
+		t.Errorf("warnings: %q", warnings)
+	}
+}
+
+func TestParseTraceIsFallback(t *testing.T) {
+	tr := newParseTrace(currentSelectors())
+	tests := []struct {
+		step, strategy string
+		want           bool
+	}{
+		{stepRouter, "album", false},
+		{stepRouter, "profile", false},
+		{stepRouter, "default-profile", true},
+		{stepProfileTiles, "list-alb", false},
+		{stepProfileTiles, "data-type-album", true},
+		{stepAlbumPhotos, "data-type", false},
+		{stepAlbumPhotos, "photo-anchors", true},
+		{stepAlbumPhotos, strategyNone, true},
+	}
+	for _, tt := range tests {
+		if got := tr.isFallback(tt.step, tt.strategy); got != tt.want {
+			t.Errorf("%s/%s: got %v, want %v", tt.step, tt.strategy, got, tt.want)
+		}
+	}
+}
+
+func TestStrategyTotals(t *testing.T) {
+	s := &strategyTotals{counts: map[string]map[string]int{}}
+	s.add(map[string]map[string]int{stepTileURL: {"data-url": 3}})
+	s.add(map[string]map[string]int{stepTileURL: {"data-url": 2, "first-anchor": 1}, stepRouter: {"album": 1}})
+	snap := s.snapshot()
+	want := map[string]map[string]int{stepTileURL: {"data-url": 5, "first-anchor": 1}, stepRouter: {"album": 1}}
+	if !reflect.DeepEqual(snap, want) {
+		t.Errorf("got %v, want %v", snap, want)
+	}
+	// The snapshot is a copy
+	snap[stepTileURL]["data-url"] = 0
+	if s.snapshot()[stepTileURL]["data-url"] != 5 {
+		t.Error("snapshot shares maps with the totals")
+	}
+}
+
+func TestMergeStrategies(t *testing.T) {
+	h := &ParserHealth{Strategies: map[string]map[string]int{stepRouter: {"album": 1}}}
+	mergeStrategies(h, &Response{
+		Warnings: []string{"w"},
+		Parser:   &ParserInfo{Strategies: map[string]map[string]int{stepRouter: {"album": 1, "profile": 1}}},
+	})
+	mergeStrategies(h, &Response{})
+	if h.Strategies[stepRouter]["album"] != 2 || h.Strategies[stepRouter]["profile"] != 1 || len(h.Warnings) != 1 {
+		t.Errorf("got %v, warnings %q", h.Strategies, h.Warnings)
+	}
+}
+
+func TestParserHealthDisabled(t *testing.T) {
+	oldAlbum, oldProfile := canaryAlbum, canaryProfile
+	canaryAlbum, canaryProfile = "", ""
+	t.Cleanup(func() { canaryAlbum, canaryProfile = oldAlbum, oldProfile })
+
+	w := httptest.NewRecorder()
+	parserHealthHandler(w, httptest.NewRequest("GET", "/health/parser", nil))
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "ZONERAMA_CANARY_ALBUM") {
+		t.Errorf("got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/geziyor/geziyor"
+	"github.com/geziyor/geziyor/client"
+)
+
+// PhotoSize is one level of the panzoom size pyramid.
+type PhotoSize struct {
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	URL    string `json:"url"`
+}
+
+// AlbumRef points back from a photo to its album.
+type AlbumRef struct {
+	ID    string `json:"id,omitempty"`
+	Title string `json:"title,omitempty"`
+	URL   string `json:"url,omitempty"`
+}
+
+// PhotoDetail is the rich single-photo object returned by /zonerama-photo.
+type PhotoDetail struct {
+	Photo
+	Title       string    `json:"title,omitempty"`
+	Description string    `json:"description,omitempty"`
+	AccountID   string    `json:"account_id,omitempty"`
+	Album       *AlbumRef `json:"album,omitempty"`
+	FileName    string    `json:"file_name,omitempty"`
+	FileSize    string    `json:"file_size,omitempty"`
+	Uploaded    string    `json:"uploaded,omitempty"`
+	LikesCount  int       `json:"likes_count"`
+	Exif        *Exif     `json:"exif,omitempty"`
+	Position    int       `json:"position,omitempty"` // 1-based position in the album
+	Total       int       `json:"total,omitempty"`
+	PrevID      string    `json:"prev_id,omitempty"`
+	NextID      string    `json:"next_id,omitempty"`
+}
+
+type PhotoResponse struct {
+	InputLink string         `json:"input_link"`
+	Canonical *CanonicalLink `json:"canonical,omitempty"`
+	Photo     *PhotoDetail   `json:"photo"`
+}
+
+// Entries of data-panzoom-pyramid: {url: '...',width: 750,height: 500 }
+var panzoomSizeRe = regexp.MustCompile(`url:\s*'([^']+)'\s*,\s*width:\s*(\d+)\s*,\s*height:\s*(\d+)`)
+
+// parsePanzoom reads the size pyramid and full-size dimensions from a zoneramaPanZoom element.
+func parsePanzoom(s *goquery.Selection) (sizes []PhotoSize, pattern string, width, height int) {
+	for _, m := range panzoomSizeRe.FindAllStringSubmatch(s.AttrOr("data-panzoom-pyramid", ""), -1) {
+		w, _ := strconv.Atoi(m[2])
+		h, _ := strconv.Atoi(m[3])
+		sizes = append(sizes, PhotoSize{Width: w, Height: h, URL: m[1]})
+	}
+	pattern = strings.TrimSpace(s.AttrOr("data-panzoom-pattern", ""))
+	width, _ = strconv.Atoi(s.AttrOr("data-panzoom-imagewidth", ""))
+	height, _ = strconv.Atoi(s.AttrOr("data-panzoom-imageheight", ""))
+	return sizes, pattern, width, height
+}
+
+// applyPhotoSlide fills d from a rendered slide (or the /Part/PhotoOnSlide fragment).
+// It reports false when the slide for d.ID is not in scope.
+func applyPhotoSlide(root *goquery.Selection, d *PhotoDetail) bool {
+	sels := currentSelectors()
+	pz := root.Find(strings.ReplaceAll(sels.Photo.Slide, "{id}", d.ID)).First()
+	if pz.Length() == 0 {
+		return false
+	}
+	// The info panel is a sibling of the image inside the slide container
+	scope := pz.Closest(sels.Photo.SlideScope)
+	if scope.Length() == 0 {
+		scope = root
+	}
+	d.Sizes, d.ImagePattern, d.Width, d.Height = parsePanzoom(pz)
+	read := scope.Find(sels.Photo.Info).First()
+	d.Title = strings.TrimSpace(read.Find(sels.Photo.Title).Text())
+	d.Description = strings.TrimSpace(read.Find(sels.Photo.Description).Text())
+
+	rows := parseInfoTable(scope)
+	for label, value := range rows {
+		switch strings.ToLower(label) {
+		case "soubor", "file":
+			d.FileName = value
+		case "velikost", "size":
+			d.FileSize = value
+			d.Bytes = parseFileSize(value)
+		case "datum vložení", "date uploaded", "uploaded":
+			d.Uploaded = value
+		case "rozměry", "dimensions":
+			if d.Width == 0 {
+				fmt.Sscanf(value, "%d x %d", &d.Width, &d.Height)
+			}
+		}
+	}
+	d.Orientation = orientation(d.Width, d.Height)
+	d.Type = "photo"
+	if itemType(pz) == "video" || scope.Find("video").Length() > 0 {
+		d.Type = "video"
+		applyVideoItem(scope, &d.Photo, extSet(defaultVideoExts), nil)
+	}
+	d.Exif = exifFromInfoTable(rows)
+	return true
+}
+
+// zoneramaPhotoHandler returns one photo: /zonerama-photo?link=https://eu.zonerama.com/Photo/<album>/<photo>
+func zoneramaPhotoHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	resp, err := scrapePhoto(r.Context(), r.URL.Query())
+	if err != nil {
+		writeScrapeError(w, err)
+		return
+	}
+	writeJSON(w, r, resp)
+}
+
+// scrapePhoto fetches the photo page, and its slide fragment when the page was
+// not rendered. It takes the shared scrape parameters; of those, rendered,
+// debug, sizes, likers and exif_jpeg apply to a single photo.
+func scrapePhoto(ctx context.Context, q url.Values) (*PhotoResponse, error) {
+	link := q.Get("link")
+	if link == "" {
+		return nil, &scrapeError{http.StatusBadRequest, "missing link param: /zonerama-photo?link=https://eu.zonerama.com/Photo/<AlbumId>/<PhotoId>"}
+	}
+	canon, err := resolveZoneramaLink(link)
+	if err != nil {
+		return nil, &scrapeError{http.StatusBadRequest, err.Error()}
+	}
+	if canon.Kind != "photo" {
+		return nil, &scrapeError{http.StatusBadRequest, "zonerama-photo expects a photo link, e.g. https://eu.zonerama.com/Photo/<AlbumId>/<PhotoId>"}
+	}
+	params, err := parseScrapeParams(q, canon.URL)
+	if err != nil {
+		return nil, &scrapeError{http.StatusBadRequest, err.Error()}
+	}
+	// Optional: liking account names, one extra request
+	var likes *likesCollector
+	if params.Likers {
+		likes = newLikesCollector(true)
+	}
+	polite := politenessFromQuery(ctx, q)
+	cw := newCrawl(polite, params)
+
+	var (
+		mu     sync.Mutex
+		parsed bool
+	)
+	detail := &PhotoDetail{Photo: Photo{ID: canon.PhotoID, Type: "photo", PageURL: canon.URL}}
+	detail.Album = &AlbumRef{ID: canon.AlbumID, URL: canon.AlbumURL()}
+
+	// The slide fragment carries the image pyramid, description and info panel
+	parseSlide := func(g *geziyor.Geziyor, cr *client.Response) {
+		if !responseAllowed(cr) {
+			return
+		}
+		cw.save("slide", cr)
+		if cr.HTMLDoc == nil {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if applyPhotoSlide(cr.HTMLDoc.Selection, detail) {
+			parsed = true
+		}
+	}
+
+	parsePhoto := func(g *geziyor.Geziyor, cr *client.Response) {
+		if !responseAllowed(cr) {
+			return
+		}
+		cw.save("photo", cr)
+		doc := cr.HTMLDoc
+		if doc == nil {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		sels := currentSelectors()
+		if id := sels.Photo.ID.value(doc.Selection); id != "" {
+			detail.ID = id
+			parsed = true
+		}
+		// The page knows its album better than the link did
+		if id := sels.Photo.AlbumID.value(doc.Selection); id != "" && id != detail.Album.ID {
+			detail.Album.ID = id
+			detail.Album.URL = cr.JoinURL("/Link/Album/" + id)
+		}
+		detail.AccountID = sels.Photo.AccountID.value(doc.Selection)
+		if a := doc.Find(sels.Photo.AlbumLink).First(); a.Length() > 0 {
+			detail.Album.Title = strings.TrimSpace(a.Text())
+			if href := strings.TrimSpace(a.AttrOr("href", "")); href != "" {
+				detail.Album.URL = cr.JoinURL(href)
+			}
+		}
+		// Neighbouring photos come from the album's ordered ID list
+		var ids []string
+		for _, id := range strings.Split(sels.Photo.Items.value(doc.Selection), ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				ids = append(ids, id)
+			}
+		}
+		for i, id := range ids {
+			if id != detail.ID {
+				continue
+			}
+			detail.Position, detail.Total = i+1, len(ids)
+			if i > 0 {
+				detail.PrevID = ids[i-1]
+			}
+			if i+1 < len(ids) {
+				detail.NextID = ids[i+1]
+			}
+		}
+		detail.LikesCount, _ = photoLikes(doc.Selection)
+		if likes != nil {
+			likes.fetchLikers(g, polite, cr, likeTypePhoto, detail.ID)
+		}
+
+		// Rendered pages already contain the slide; otherwise fetch the fragment
+		if !applyPhotoSlide(doc.Selection, detail) {
+			g.Get(cr.JoinURL("/Part/PhotoOnSlide?ID="+detail.ID), polite.retrying(parseSlide))
+		}
+	}
+
+	gz := newCrawler(cw.options(canon.URL, parsePhoto))
+	gz.Start()
+
+	if !parsed {
+		return nil, &scrapeError{http.StatusBadGateway, "could not fetch or parse the photo page"}
+	}
+	if likes != nil {
+		_, detail.Likers = likes.lookup(likeTypePhoto, detail.ID)
+	}
+	host := defaultZoneramaHost
+	if u, err := url.Parse(canon.URL); err == nil {
+		host = u.Host
+	}
+	detail.Image1500 = fmt.Sprintf("https://%s/photos/%s_1500x1000.jpg", host, detail.ID)
+	if detail.ImagePattern != "" && detail.Width > 0 {
+		fw, fh := fitSize(detail.Width, detail.Height, 1500)
+		detail.Image1500 = sizedURL(detail.ImagePattern, fw, fh)
+	}
+	// Opt-in fallback: read EXIF from the image itself when the panel did not show it
+	if detail.Exif == nil && params.ExifJPEG {
+		ex, err := fetchJPEGExif(ctx, photoImageURL(detail.Sizes, detail.Image1500))
+		if err != nil {
+			log.Printf("exif: reading JPEG for photo %s: %v", detail.ID, err)
+		}
+		detail.Exif = ex
+	}
+
+	if detail.Video != nil && detail.Video.Poster == "" {
+		detail.Video.Poster = detail.Image1500
+	}
+	// sizes= replaces the pyramid with the requested renditions
+	if len(params.SizeEdges) > 0 {
+		if sizes := photoSizes(detail.ImagePattern, detail.Width, detail.Height, params.SizeEdges); sizes != nil {
+			detail.Sizes = sizes
+		}
+	}
+	return &PhotoResponse{InputLink: link, Canonical: canon, Photo: detail}, nil
+}
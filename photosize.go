@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Bounds for sizes=: a handful of renditions, none larger than Zonerama serves.
+const (
+	maxRequestedSizes = 8
+	maxRequestedEdge  = 10000
+)
+
+// parseSizesParam reads sizes=750,1500,3000: long-edge lengths in pixels.
+func parseSizesParam(s string) ([]int, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var edges []int
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil || n <= 0 || n > maxRequestedEdge {
+			return nil, fmt.Errorf("invalid size %q, use 1-%d", part, maxRequestedEdge)
+		}
+		edges = append(edges, n)
+	}
+	if len(edges) > maxRequestedSizes {
+		return nil, fmt.Errorf("at most %d sizes", maxRequestedSizes)
+	}
+	return edges, nil
+}
+
+// fitSize scales width x height so the long edge is at most edge, keeping the aspect ratio.
+func fitSize(width, height, edge int) (int, int) {
+	long := max(width, height)
+	if long <= edge {
+		return width, height
+	}
+	f := float64(edge) / float64(long)
+	return int(math.Round(float64(width) * f)), int(math.Round(float64(height) * f))
+}
+
+func orientation(width, height int) string {
+	switch {
+	case width <= 0 || height <= 0:
+		return ""
+	case width > height:
+		return "landscape"
+	case height > width:
+		return "portrait"
+	}
+	return "square"
+}
+
+// photoSizes renders the image pattern at each requested long edge.
+func photoSizes(pattern string, width, height int, edges []int) []PhotoSize {
+	if pattern == "" || width <= 0 || height <= 0 {
+		return nil
+	}
+	sizes := make([]PhotoSize, 0, len(edges))
+	for _, e := range edges {
+		w, h := fitSize(width, height, e)
+		sizes = append(sizes, PhotoSize{Width: w, Height: h, URL: sizedURL(pattern, w, h)})
+	}
+	return sizes
+}
+
+// applyGalleryItem reads the dimensions and image pattern of an album gallery item
+// (data-width, data-height, data-size, data-image-pattern). Image1500 then fits the
+// real aspect ratio instead of assuming a 1500x1000 landscape.
+func applyGalleryItem(s *goquery.Selection, p *Photo, edges []int) {
+	p.Width, _ = strconv.Atoi(s.AttrOr("data-width", ""))
+	p.Height, _ = strconv.Atoi(s.AttrOr("data-height", ""))
+	p.Orientation = orientation(p.Width, p.Height)
+	// data-size is usually the layout span (1 or 2); only byte counts are kept
+	if n, err := strconv.ParseInt(s.AttrOr("data-size", ""), 10, 64); err == nil && n >= 1024 {
+		p.Bytes = n
+	}
+	pattern := strings.TrimSpace(s.AttrOr("data-image-pattern", ""))
+	if !strings.Contains(pattern, "{width}") {
+		return
+	}
+	p.ImagePattern = pattern
+	if p.Width > 0 && p.Height > 0 {
+		w, h := fitSize(p.Width, p.Height, 1500)
+		p.Image1500 = sizedURL(pattern, w, h)
+		p.Sizes = photoSizes(pattern, p.Width, p.Height, edges)
+	}
+}
+
+// parseFileSize turns the info panel's "9,19 MB" into bytes (approximate, as displayed).
+func parseFileSize(s string) int64 {
+	f := strings.Fields(strings.ReplaceAll(s, ",", "."))
+	if len(f) != 2 {
+		return 0
+	}
+	v, err := strconv.ParseFloat(f[0], 64)
+	if err != nil {
+		return 0
+	}
+	mult := map[string]float64{"b": 1, "kb": 1 << 10, "mb": 1 << 20, "gb": 1 << 30}[strings.ToLower(f[1])]
+	return int64(v * mult)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestParseSizesParam(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []int
+		err  bool
+	}{
+		{"", nil, false},
+		{"750, 1500,,3000", []int{750, 1500, 3000}, false},
+		{"0", nil, true},
+		{"abc", nil, true},
+		{"10001", nil, true},
+		{"1,2,3,4,5,6,7,8,9", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseSizesParam(tt.in)
+		if (err != nil) != tt.err || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestFitSize(t *testing.T) {
+	tests := []struct {
+		w, h, edge   int
+		wantW, wantH int
+		orientation  string
+	}{
+		{6000, 4000, 1500, 1500, 1000, "landscape"},
+		{3672, 5482, 1500, 1005, 1500, "portrait"},
+		{1051, 801, 1500, 1051, 801, "landscape"},
+		{2000, 2000, 750, 750, 750, "square"},
+		{0, 801, 1500, 0, 801, ""},
+	}
+	for _, tt := range tests {
+		w, h := fitSize(tt.w, tt.h, tt.edge)
+		if w != tt.wantW || h != tt.wantH {
+			t.Errorf("fitSize(%d, %d, %d) = %dx%d, want %dx%d", tt.w, tt.h, tt.edge, w, h, tt.wantW, tt.wantH)
+		}
+		if o := orientation(tt.w, tt.h); o != tt.orientation {
+			t.Errorf("orientation(%d, %d) = %q, want %q", tt.w, tt.h, o, tt.orientation)
+		}
+	}
+}
+
+func TestApplyGalleryItem(t *testing.T) {
+	items, _ := firstMatch(currentSelectors().Album.Photos, loadFixture(t, "snippet3.html").Selection)
+	if items.Length() != 22 {
+		t.Fatalf("got %d gallery items, want 22", items.Length())
+	}
+	items.Each(func(i int, s *goquery.Selection) {
+		var p Photo
+		applyGalleryItem(s, &p, []int{750, 3000})
+		if p.Width <= 0 || p.Height <= 0 || p.Orientation == "" || !strings.Contains(p.ImagePattern, "{width}") {
+			t.Errorf("item %d: got %+v", i, p)
+			return
+		}
+		w, h := fitSize(p.Width, p.Height, 1500)
+		if p.Image1500 != sizedURL(p.ImagePattern, w, h) || len(p.Sizes) != 2 {
+			t.Errorf("item %d: image_1500 %s, sizes %+v", i, p.Image1500, p.Sizes)
+		}
+		// data-size is the layout span here, not a byte count
+		if p.Bytes != 0 {
+			t.Errorf("item %d: bytes %d from a layout span", i, p.Bytes)
+		}
+	})
+
+	var first Photo
+	applyGalleryItem(items.First(), &first, nil)
+	if first.Width != 5482 || first.Height != 3672 || first.Image1500 != "https://eu.zonerama.com/photos/565775564_1500x1005_18.jpg" {
+		t.Errorf("first item: got %+v", first)
+	}
+}
+
+func TestParseFileSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"9,19 MB", 9636413},
+		{"512 kB", 512 << 10},
+		{"1.5 GB", 3 << 29},
+		{"800 B", 800},
+		{"9,19", 0},
+		{"big MB", 0},
+		{"3 TB", 0},
+	}
+	for _, tt := range tests {
+		if got := parseFileSize(tt.in); got != tt.want {
+			t.Errorf("%q: got %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestScrapePhotoParams(t *testing.T) {
+	photo := "https://eu.zonerama.com/Photo/12/345"
+	tests := []struct {
+		query url.Values
+		want  string // error substring
+	}{
+		{url.Values{}, "missing link"},
+		{url.Values{"link": {"https://eu.zonerama.com/FKKofolaKrnov/Album/12"}}, "expects a photo link"},
+		{url.Values{"link": {photo}, "sizes": {"0"}}, "invalid size"},
+		{url.Values{"link": {photo}, "fields": {"photo..id"}}, "invalid field"},
+	}
+	for _, tt := range tests {
+		_, err := scrapePhoto(context.Background(), tt.query)
+		var se *scrapeError
+		if !errors.As(err, &se) || se.status != http.StatusBadRequest || !strings.Contains(se.msg, tt.want) {
+			t.Errorf("%v: got %v, want a 400 mentioning %q", tt.query, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/geziyor/geziyor"
+	"github.com/geziyor/geziyor/client"
+	"golang.org/x/time/rate"
+)
+
+// politenessConfig controls how hard we hit Zonerama. The server-wide values come
+// from the environment; individual requests may only make them stricter.
+type politenessConfig struct {
+	RPS         float64       // requests per second per host; 0 = unlimited
+	Burst       int           // token bucket burst per host
+	Delay       time.Duration // base delay before each request, randomized to 0.5x-1.5x
+	MaxRetries  int           // retries on 429/503 before giving up
+	BackoffBase time.Duration // first backoff step, doubled on each retry
+	BackoffMax  time.Duration // upper bound for backoff and Retry-After waits
+	Robots      bool          // honor robots.txt
+}
+
+// Upper bound for a per-request delay_ms so a single call cannot stall for minutes.
+const maxRequestDelay = 10 * time.Second
+
+// Status codes Geziyor retries immediately. 429 and 503 are left out on purpose:
+// they are handled by crawlPoliteness.retrying with backoff instead.
+var politeRetryHTTPCodes = []int{500, 502, 504, 522, 524, 408}
+
+func loadPolitenessConfig() politenessConfig {
+	cfg := politenessConfig{
+		RPS:         envFloat("ZONERAMA_RPS", 2),
+		Burst:       envInt("ZONERAMA_BURST", 2),
+		Delay:       envMillis("ZONERAMA_DELAY_MS", 200*time.Millisecond),
+		MaxRetries:  envInt("ZONERAMA_BACKOFF_RETRIES", 3),
+		BackoffBase: envMillis("ZONERAMA_BACKOFF_BASE_MS", time.Second),
+		BackoffMax:  envMillis("ZONERAMA_BACKOFF_MAX_MS", 30*time.Second),
+		Robots:      envBool("ZONERAMA_ROBOTS", false),
+	}
+	if cfg.Burst < 1 {
+		cfg.Burst = 1
+	}
+	if cfg.MaxRetries < 0 {
+		cfg.MaxRetries = 0
+	}
+	return cfg
+}
+
+var (
+	politeCfg   = loadPolitenessConfig()
+	politeHosts = newHostLimiter(politeCfg.RPS, politeCfg.Burst)
+)
+
+// hostLimiter is shared by all crawls so that concurrent API calls do not
+// multiply the load on a single Zonerama host.
+type hostLimiter struct {
+	mu    sync.Mutex
+	rps   float64
+	burst int
+	hosts map[string]*hostState
+}
+
+type hostState struct {
+	lim         *rate.Limiter
+	pausedUntil time.Time
+}
+
+func newHostLimiter(rps float64, burst int) *hostLimiter {
+	return &hostLimiter{rps: rps, burst: burst, hosts: make(map[string]*hostState)}
+}
+
+func (h *hostLimiter) state(host string) *hostState {
+	st, ok := h.hosts[host]
+	if !ok {
+		lim := rate.NewLimiter(rate.Inf, 0)
+		if h.rps > 0 {
+			lim = rate.NewLimiter(rate.Limit(h.rps), h.burst)
+		}
+		st = &hostState{lim: lim}
+		h.hosts[host] = st
+	}
+	return st
+}
+
+// wait blocks until the host is not paused and a token is available, or ctx is done.
+func (h *hostLimiter) wait(ctx context.Context, host string) error {
+	for {
+		h.mu.Lock()
+		st := h.state(host)
+		pause := time.Until(st.pausedUntil)
+		h.mu.Unlock()
+		if pause <= 0 {
+			return st.lim.Wait(ctx)
+		}
+		if err := sleepCtx(ctx, pause); err != nil {
+			return err
+		}
+	}
+}
+
+// sleepCtx sleeps for d unless ctx is done first.
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pause holds back every request to host for d (used after 429/503).
+func (h *hostLimiter) pause(host string, d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	st := h.state(host)
+	if until := time.Now().Add(d); until.After(st.pausedUntil) {
+		st.pausedUntil = until
+	}
+}
+
+// crawlPoliteness is the effective policy for one API call. It is used as a
+// Geziyor request middleware and wraps callbacks to retry throttled responses.
+// Once ctx is done (the client went away), queued requests are cancelled.
+type crawlPoliteness struct {
+	ctx   context.Context
+	cfg   politenessConfig
+	local *rate.Limiter // set when the request asked for a lower rps than the server
+}
+
+// politenessFromQuery applies the optional rps, delay_ms and robots query
+// params on top of the server config. They can only slow the crawl down.
+func politenessFromQuery(ctx context.Context, q url.Values) *crawlPoliteness {
+	p := &crawlPoliteness{ctx: ctx, cfg: politeCfg}
+	if s := q.Get("rps"); s != "" {
+		if f, err := strconv.ParseFloat(s, 64); err == nil && f > 0 && (p.cfg.RPS <= 0 || f < p.cfg.RPS) {
+			p.cfg.RPS = f
+			p.local = rate.NewLimiter(rate.Limit(f), 1)
+		}
+	}
+	if s := q.Get("delay_ms"); s != "" {
+		if n, err := strconv.Atoi(s); err == nil {
+			d := time.Duration(n) * time.Millisecond
+			if d > maxRequestDelay {
+				d = maxRequestDelay
+			}
+			if d > p.cfg.Delay {
+				p.cfg.Delay = d
+			}
+		}
+	}
+	if s := q.Get("robots"); s != "" {
+		if b, err := strconv.ParseBool(s); err == nil && b {
+			p.cfg.Robots = true
+		}
+	}
+	return p
+}
+
+// ProcessRequest implements middleware.RequestProcessor. Requests still waiting
+// for their turn when the crawl's context ends are cancelled instead of sent.
+func (p *crawlPoliteness) ProcessRequest(r *client.Request) {
+	err := politeHosts.wait(p.ctx, r.URL.Host)
+	if err == nil && p.local != nil {
+		err = p.local.Wait(p.ctx)
+	}
+	if err == nil && p.cfg.Delay > 0 {
+		err = sleepCtx(p.ctx, time.Duration(float64(p.cfg.Delay)*(0.5+rand.Float64())))
+	}
+	if err != nil {
+		r.Cancel()
+		return
+	}
+	r.Request = r.Request.WithContext(p.ctx)
+}
+
+// retrying wraps a callback so 429/503 responses pause the host and re-issue the
+// request with exponential backoff. After MaxRetries the callback gets the last response.
+func (p *crawlPoliteness) retrying(cb func(*geziyor.Geziyor, *client.Response)) func(*geziyor.Geziyor, *client.Response) {
+	var wrapped func(*geziyor.Geziyor, *client.Response)
+	wrapped = func(g *geziyor.Geziyor, cr *client.Response) {
+		if cr != nil && cr.Request != nil && (cr.StatusCode == http.StatusTooManyRequests || cr.StatusCode == http.StatusServiceUnavailable) {
+			attempt, _ := cr.Request.Meta["attempt"].(int)
+			wait := p.backoff(attempt, cr.Header.Get("Retry-After"))
+			politeHosts.pause(cr.Request.URL.Host, wait)
+			if attempt < p.cfg.MaxRetries {
+				log.Printf("politeness: %d from %s, retrying in %s (attempt %d/%d)", cr.StatusCode, cr.Request.URL.String(), wait, attempt+1, p.cfg.MaxRetries)
+				if req, err := newCrawlRequest(cr.Request.URL.String(), cr.Request.Rendered); err == nil {
+					for k, v := range cr.Request.Meta {
+						if k != metaRenderedPage {
+							req.Meta[k] = v
+						}
+					}
+					req.Meta["attempt"] = attempt + 1
+					g.Do(req, wrapped)
+					return
+				}
+			}
+			log.Printf("politeness: giving up on %s after %d attempts", cr.Request.URL.String(), attempt+1)
+		}
+		cb(g, cr)
+	}
+	return wrapped
+}
+
+// albumSlots caps how many album pages one crawl fetches at once. It runs as the
+// last request middleware, so requests cancelled earlier in the chain (client
+// gone, robots.txt) never take a slot, and it hands the slot back as soon as the
+// fetch ends: in ProcessResponse on success and in release from the crawl's
+// ErrorFunc on failure. Nothing waits on the callback, which geziyor skips for
+// cancelled and failed requests.
+type albumSlots struct {
+	ctx context.Context
+	sem chan struct{}
+}
+
+const (
+	metaAlbum = "album"      // request is an album page and needs a slot
+	metaSlot  = "album_slot" // request currently holds a slot
+)
+
+func newAlbumSlots(ctx context.Context, n int) *albumSlots {
+	return &albumSlots{ctx: ctx, sem: make(chan struct{}, n)}
+}
+
+// get queues a rendered album request; it does not block the caller.
+func (s *albumSlots) get(g *geziyor.Geziyor, url string, cb func(*geziyor.Geziyor, *client.Response)) {
+	req, err := newCrawlRequest(url, true)
+	if err != nil {
+		log.Printf("crawl: album request %s: %v", url, err)
+		return
+	}
+	req.Meta[metaAlbum] = true
+	g.Do(req, cb)
+}
+
+// ProcessRequest implements middleware.RequestProcessor.
+func (s *albumSlots) ProcessRequest(r *client.Request) {
+	if r.Meta[metaAlbum] != true {
+		return
+	}
+	select {
+	case s.sem <- struct{}{}:
+		r.Meta[metaSlot] = true
+	case <-s.ctx.Done():
+		r.Cancel()
+	}
+}
+
+// ProcessResponse implements middleware.ResponseProcessor.
+func (s *albumSlots) ProcessResponse(cr *client.Response) {
+	s.release(cr.Request)
+}
+
+// release gives back the slot held by r, if any. It is safe to call twice.
+func (s *albumSlots) release(r *client.Request) {
+	if r != nil && r.Meta[metaSlot] == true {
+		delete(r.Meta, metaSlot)
+		<-s.sem
+	}
+}
+
+// backoff returns the wait before the next attempt. A Retry-After header
+// (seconds or HTTP date) wins over the exponential schedule; both are capped.
+func (p *crawlPoliteness) backoff(attempt int, retryAfter string) time.Duration {
+	d := p.cfg.BackoffBase << uint(attempt)
+	if ra := parseRetryAfter(retryAfter); ra > 0 {
+		d = ra
+	}
+	if d <= 0 || d > p.cfg.BackoffMax {
+		d = p.cfg.BackoffMax
+	}
+	return d
+}
+
+func parseRetryAfter(s string) time.Duration {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		return time.Duration(n) * time.Second
+	}
+	if t, err := http.ParseTime(s); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
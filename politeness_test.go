@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/geziyor/geziyor/client"
+)
+
+func TestPolitenessFromQuery(t *testing.T) {
+	old := politeCfg
+	politeCfg = politenessConfig{RPS: 2, Burst: 2, Delay: 200 * time.Millisecond}
+	t.Cleanup(func() { politeCfg = old })
+
+	tests := []struct {
+		query  string
+		rps    float64
+		local  bool
+		delay  time.Duration
+		robots bool
+	}{
+		{"", 2, false, 200 * time.Millisecond, false},
+		{"rps=0.5", 0.5, true, 200 * time.Millisecond, false},
+		// Requests can only slow the crawl down
+		{"rps=10", 2, false, 200 * time.Millisecond, false},
+		{"rps=0&delay_ms=50", 2, false, 200 * time.Millisecond, false},
+		{"delay_ms=1500", 2, false, 1500 * time.Millisecond, false},
+		{"delay_ms=600000", 2, false, maxRequestDelay, false},
+		{"robots=true", 2, false, 200 * time.Millisecond, true},
+		{"robots=nope", 2, false, 200 * time.Millisecond, false},
+	}
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.query)
+		p := politenessFromQuery(context.Background(), q)
+		if p.cfg.RPS != tt.rps || (p.local != nil) != tt.local || p.cfg.Delay != tt.delay || p.cfg.Robots != tt.robots {
+			t.Errorf("%q: got rps %v local %v delay %v robots %v, want %v %v %v %v", tt.query,
+				p.cfg.RPS, p.local != nil, p.cfg.Delay, p.cfg.Robots, tt.rps, tt.local, tt.delay, tt.robots)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := &crawlPoliteness{cfg: politenessConfig{BackoffBase: time.Second, BackoffMax: 30 * time.Second}}
+	tests := []struct {
+		attempt    int
+		retryAfter string
+		want       time.Duration
+	}{
+		{0, "", time.Second},
+		{1, "", 2 * time.Second},
+		{3, "", 8 * time.Second},
+		{10, "", 30 * time.Second},
+		// Retry-After wins over the schedule, within the cap
+		{0, "7", 7 * time.Second},
+		{3, "120", 30 * time.Second},
+		{2, "soon", 4 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := p.backoff(tt.attempt, tt.retryAfter); got != tt.want {
+			t.Errorf("backoff(%d, %q) = %v, want %v", tt.attempt, tt.retryAfter, got, tt.want)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if got := parseRetryAfter(" 12 "); got != 12*time.Second {
+		t.Errorf("seconds: got %v", got)
+	}
+	date := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(date); got < 55*time.Second || got > time.Minute {
+		t.Errorf("HTTP date: got %v, want about a minute", got)
+	}
+	for _, s := range []string{"", "later", "-"} {
+		if got := parseRetryAfter(s); got != 0 {
+			t.Errorf("%q: got %v, want 0", s, got)
+		}
+	}
+}
+
+func TestHostLimiterPause(t *testing.T) {
+	h := newHostLimiter(0, 1)
+	if err := h.wait(context.Background(), "eu.zonerama.com"); err != nil {
+		t.Fatalf("unlimited host: %v", err)
+	}
+	h.pause("eu.zonerama.com", time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := h.wait(ctx, "eu.zonerama.com"); err == nil {
+		t.Error("paused host: wait returned before the pause ended")
+	}
+	// Pauses are per host
+	if err := h.wait(context.Background(), "zonerama.com"); err != nil {
+		t.Errorf("other host: %v", err)
+	}
+}
+
+func TestAlbumSlots(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := newAlbumSlots(ctx, 1)
+	album := func() *client.Request {
+		r, _ := client.NewRequest("GET", "https://eu.zonerama.com/Fixture/Album/1", nil)
+		r.Meta[metaAlbum] = true
+		return r
+	}
+
+	// Other requests never take a slot
+	other, _ := client.NewRequest("GET", "https://eu.zonerama.com/Fixture/1", nil)
+	s.ProcessRequest(other)
+	first := album()
+	s.ProcessRequest(first)
+	if first.Cancelled || first.Meta[metaSlot] != true {
+		t.Fatal("first album request did not get the free slot")
+	}
+
+	second := album()
+	done := make(chan struct{})
+	go func() {
+		s.ProcessRequest(second)
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("second album request did not wait for the slot")
+	case <-time.After(20 * time.Millisecond):
+	}
+	s.release(first)
+	s.release(first) // a second release is a no-op
+	<-done
+	if second.Cancelled || second.Meta[metaSlot] != true {
+		t.Fatal("second album request did not get the released slot")
+	}
+
+	// Once the crawl is cancelled, waiting requests are cancelled instead
+	third := album()
+	cancel()
+	s.ProcessRequest(third)
+	if !third.Cancelled || third.Meta[metaSlot] == true {
+		t.Error("waiting album request was not cancelled with the crawl")
+	}
+	s.ProcessResponse(&client.Response{Request: second})
+	if len(s.sem) != 0 {
+		t.Errorf("%d slots still held", len(s.sem))
+	}
+}
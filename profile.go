@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// albumTile is what a profile page shows about one album before its page is fetched.
+type albumTile struct {
+	Order     int // position on the profile page
+	URL       string
+	ID        string
+	Cover     *Cover
+	Title     string
+	Date      string // raw tile date, before the "|" of the info block
+	PhotosCnt int
+	ViewsCnt  int
+}
+
+// parseTiles reads the album tiles of a profile page in page order. join resolves
+// relative URLs against page; tiles without a link, with a link off the
+// allowlist or without an album ID (banners, placeholders) are skipped. The strategies used are recorded in trace.
+func parseTiles(sels *Selectors, doc *goquery.Selection, page string, join func(string) string, trace *parseTrace) []albumTile {
+	albumTiles, tileRule := firstMatch(sels.Profile.Tiles, doc)
+	trace.record(stepProfileTiles, tileRule.Name, page)
+	log.Printf("parseProfile: found %d album candidates at %s", albumTiles.Length(), page)
+	var tiles []albumTile
+	albumTiles.Each(func(i int, s *goquery.Selection) {
+		// data-url, else the thumbnail anchor, else the tile's first anchor
+		albumURL, urlStrategy := firstValue(sels.Profile.TileURL, s)
+		trace.record(stepTileURL, urlStrategy, page)
+		if albumURL == "" {
+			return
+		}
+		if strings.HasPrefix(albumURL, "/") {
+			albumURL = join(albumURL)
+		}
+		// Tiles come from the page, so re-validate before we crawl them
+		if _, err := validateZoneramaURL(albumURL); err != nil {
+			log.Printf("parseProfile: skipping tile %q: %v", albumURL, err)
+			return
+		}
+		t := albumTile{Order: i, URL: albumURL}
+		// Extract date, photos count and views count from the tile's <p> block
+		if p := s.Find(sels.Profile.TileInfo).First(); p.Length() > 0 {
+			if full := strings.TrimSpace(p.Text()); full != "" {
+				t.Date = strings.TrimSpace(strings.Split(full, "|")[0])
+			}
+			spans := p.Find(sels.Profile.TileCounts)
+			if spans.Length() > 0 {
+				fmt.Sscanf(strings.TrimSpace(spans.Eq(0).Text()), "%d", &t.PhotosCnt)
+			}
+			if spans.Length() > 1 {
+				fmt.Sscanf(strings.TrimSpace(spans.Eq(1).Text()), "%d", &t.ViewsCnt)
+			}
+		}
+		t.Title, _ = firstValue(sels.Profile.TileTitle, s)
+		t.ID = sels.Profile.TileAlbumID.value(s)
+		if t.ID == "" {
+			if c, err := resolveZoneramaLink(albumURL); err == nil {
+				t.ID = c.AlbumID
+			}
+		}
+		if t.ID == "" {
+			log.Printf("parseProfile: skipping tile %q: not an album", albumURL)
+			return
+		}
+		t.Cover = coverFromTile(s, join)
+		tiles = append(tiles, t)
+	})
+	return tiles
+}
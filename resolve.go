@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// CanonicalLink is what an input link resolves to. Kind is one of account,
+// tab, album, photo, or page for other Zonerama pages we pass through as-is.
+type CanonicalLink struct {
+	Kind      string `json:"kind"`
+	URL       string `json:"url"`
+	Account   string `json:"account,omitempty"`
+	AccountID string `json:"account_id,omitempty"`
+	TabID     string `json:"tab_id,omitempty"`
+	AlbumID   string `json:"album_id,omitempty"`
+	PhotoID   string `json:"photo_id,omitempty"`
+
+	base string // scheme://host the canonical URLs are built on
+}
+
+// Host used when a link has no regional subdomain (zonerama.com, www., <Account>.zonerama.com).
+var defaultZoneramaHost = envString("ZONERAMA_DEFAULT_HOST", "eu.zonerama.com")
+
+var numericRe = regexp.MustCompile(`^\d+$`)
+
+// First path segments that are Zonerama pages rather than account names.
+var reservedSegments = map[string]bool{
+	"photo": true, "album": true, "embed": true, "link": true, "profile": true,
+	"view": true, "discover": true, "favorites": true, "faq": true, "termsconditions": true,
+	"notification": true, "content": true, "photos": true, "publicalbumcover": true,
+	"za": true, "part": true, "slideonprofile": true, "search": true,
+}
+
+// resolveZoneramaLink canonicalizes the many link shapes users paste:
+//
+//	/<Account>, /<Account>/<TabId>, /<Account>/Album/<id>, /<Account>/Photo/<album>/<photo>,
+//	/Photo/<album>/<photo>, /Embed/Album/<id>, /Link/{Account,Album}/<id>, /Link/Photo/<photo>/<album>,
+//	/Profile/<id>[/<tab>]
+//
+// Query strings, fragments and trailing slashes are dropped, the apex and www hosts map to
+// the default regional host, and <Account>.zonerama.com becomes /<Account>.
+func resolveZoneramaLink(raw string) (*CanonicalLink, error) {
+	u, host, err := parseLinkURL(raw)
+	if err != nil {
+		return nil, err
+	}
+	if host != "zonerama.com" && !strings.HasSuffix(host, ".zonerama.com") {
+		return nil, errors.New("link must point to zonerama.com")
+	}
+	c := &CanonicalLink{}
+	switch {
+	case host == "zonerama.com" || host == "www.zonerama.com":
+		host = defaultZoneramaHost
+	case !allowedHosts[host]:
+		label := strings.TrimSuffix(host, ".zonerama.com")
+		if strings.Contains(label, ".") {
+			return nil, errors.New("link must point to zonerama.com")
+		}
+		c.Account = label
+		host = defaultZoneramaHost
+	}
+	c.base = "https://" + host
+
+	var seg []string
+	for _, s := range strings.Split(u.Path, "/") {
+		if s != "" {
+			seg = append(seg, s)
+		}
+	}
+	if c.Account != "" {
+		seg = append([]string{c.Account}, seg...)
+	}
+	lower := func(i int) string {
+		if i < len(seg) {
+			return strings.ToLower(seg[i])
+		}
+		return ""
+	}
+	isID := func(i int) bool { return i < len(seg) && numericRe.MatchString(seg[i]) }
+
+	switch {
+	case len(seg) == 0:
+		c.Kind = "page"
+	case lower(0) == "photo" && len(seg) == 3 && isID(1) && isID(2):
+		c.setPhoto(seg[1], seg[2])
+	case (lower(0) == "embed" || lower(0) == "link") && lower(1) == "album" && len(seg) == 3 && isID(2):
+		c.setAlbum(seg[2])
+	case lower(0) == "link" && lower(1) == "photo" && len(seg) == 4 && isID(2) && isID(3):
+		// Unlike /Photo/, the short link puts the photo ID first
+		c.setPhoto(seg[3], seg[2])
+	case (lower(0) == "embed" || lower(0) == "link") && lower(1) == "account" && len(seg) == 3 && isID(2):
+		c.Kind, c.AccountID = "account", seg[2]
+	case lower(0) == "profile" && (len(seg) == 2 || len(seg) == 3) && isID(1):
+		c.Kind, c.AccountID = "account", seg[1]
+		if len(seg) == 3 && isID(2) {
+			c.Kind, c.TabID = "tab", seg[2]
+		}
+	case reservedSegments[lower(0)]:
+		c.Kind = "page"
+	default:
+		c.Account = seg[0]
+		switch {
+		case len(seg) == 1:
+			c.Kind = "account"
+		case len(seg) == 2 && isID(1):
+			c.Kind, c.TabID = "tab", seg[1]
+		case len(seg) == 3 && lower(1) == "album" && isID(2):
+			c.setAlbum(seg[2])
+		case len(seg) == 4 && lower(1) == "photo" && isID(2) && isID(3):
+			c.setPhoto(seg[2], seg[3])
+		default:
+			c.Kind = "page"
+		}
+	}
+	c.URL = c.buildURL(u.Path, u.RawQuery)
+	if _, err := validateZoneramaURL(c.URL); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *CanonicalLink) setAlbum(id string) {
+	c.Kind, c.AlbumID = "album", id
+}
+
+func (c *CanonicalLink) setPhoto(albumID, photoID string) {
+	c.Kind, c.AlbumID, c.PhotoID = "photo", albumID, photoID
+}
+
+func (c *CanonicalLink) buildURL(path, rawQuery string) string {
+	switch c.Kind {
+	case "account":
+		if c.Account != "" {
+			return c.base + "/" + c.Account
+		}
+		return c.base + "/Link/Account/" + c.AccountID
+	case "tab":
+		if c.Account != "" {
+			return c.base + "/" + c.Account + "/" + c.TabID
+		}
+		return c.base + "/Profile/" + c.AccountID + "/" + c.TabID
+	case "album":
+		return c.AlbumURL()
+	case "photo":
+		if c.Account != "" {
+			return c.base + "/" + c.Account + "/Photo/" + c.AlbumID + "/" + c.PhotoID
+		}
+		return c.base + "/Link/Photo/" + c.PhotoID + "/" + c.AlbumID
+	}
+	// Unknown pages keep their path and query, only the host is normalized
+	out := c.base + "/" + strings.Trim(path, "/")
+	if rawQuery != "" {
+		out += "?" + rawQuery
+	}
+	return out
+}
+
+// AlbumURL is the canonical album URL for album and photo links.
+func (c *CanonicalLink) AlbumURL() string {
+	if c.AlbumID == "" {
+		return ""
+	}
+	if c.Account != "" {
+		return c.base + "/" + c.Account + "/Album/" + c.AlbumID
+	}
+	return c.base + "/Link/Album/" + c.AlbumID
+}
+
+// CrawlURL is where a crawl should start: photo links start at their album.
+func (c *CanonicalLink) CrawlURL() string {
+	if c.Kind == "photo" {
+		return c.AlbumURL()
+	}
+	return c.URL
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestResolveZoneramaLink(t *testing.T) {
+	tests := []struct {
+		in                        string
+		kind, url                 string
+		account, albumID, photoID string
+		crawl                     string
+	}{
+		{in: "https://eu.zonerama.com/FKKofolaKrnov", kind: "account", url: "https://eu.zonerama.com/FKKofolaKrnov", account: "FKKofolaKrnov"},
+		{in: "https://eu.zonerama.com/FKKofolaKrnov/Album/13796374", kind: "album", url: "https://eu.zonerama.com/FKKofolaKrnov/Album/13796374", account: "FKKofolaKrnov", albumID: "13796374"},
+		{in: "https://eu.zonerama.com/FKKofolaKrnov/Photo/13903610/565775525", kind: "photo", url: "https://eu.zonerama.com/FKKofolaKrnov/Photo/13903610/565775525", account: "FKKofolaKrnov", albumID: "13903610", photoID: "565775525", crawl: "https://eu.zonerama.com/FKKofolaKrnov/Album/13903610"},
+		// The short photo link puts the photo ID first, /Photo/ the album ID
+		{in: "https://eu.zonerama.com/Link/Photo/565775525/13903610", kind: "photo", url: "https://eu.zonerama.com/Link/Photo/565775525/13903610", albumID: "13903610", photoID: "565775525", crawl: "https://eu.zonerama.com/Link/Album/13903610"},
+		{in: "https://eu.zonerama.com/Photo/13903610/565775525", kind: "photo", url: "https://eu.zonerama.com/Link/Photo/565775525/13903610", albumID: "13903610", photoID: "565775525", crawl: "https://eu.zonerama.com/Link/Album/13903610"},
+		{in: "https://eu.zonerama.com/Embed/Album/13903610", kind: "album", url: "https://eu.zonerama.com/Link/Album/13903610", albumID: "13903610"},
+		{in: "https://eu.zonerama.com/Link/Album/13903610/", kind: "album", url: "https://eu.zonerama.com/Link/Album/13903610", albumID: "13903610"},
+		{in: "https://www.zonerama.com/Profile/884961/1470757", kind: "tab", url: "https://eu.zonerama.com/Profile/884961/1470757"},
+		{in: "https://zonerama.com/Profile/758969", kind: "account", url: "https://eu.zonerama.com/Link/Account/758969"},
+		{in: "https://eu.zonerama.com/Link/Account/884961", kind: "account", url: "https://eu.zonerama.com/Link/Account/884961"},
+		{in: "https://eu.zonerama.com/FKKofolaKrnov/1470757?x=1#top", kind: "tab", url: "https://eu.zonerama.com/FKKofolaKrnov/1470757", account: "FKKofolaKrnov"},
+		{in: "https://fkkofolakrnov.zonerama.com/Album/13796374", kind: "album", url: "https://eu.zonerama.com/fkkofolakrnov/Album/13796374", account: "fkkofolakrnov", albumID: "13796374"},
+		{in: "https://eu.zonerama.com/FAQ", kind: "page", url: "https://eu.zonerama.com/FAQ"},
+	}
+	for _, tt := range tests {
+		c, err := resolveZoneramaLink(tt.in)
+		if err != nil {
+			t.Errorf("%s: %v", tt.in, err)
+			continue
+		}
+		if c.Kind != tt.kind || c.URL != tt.url || c.Account != tt.account || c.AlbumID != tt.albumID || c.PhotoID != tt.photoID {
+			t.Errorf("%s: got %s %s account=%q album=%q photo=%q, want %s %s account=%q album=%q photo=%q",
+				tt.in, c.Kind, c.URL, c.Account, c.AlbumID, c.PhotoID, tt.kind, tt.url, tt.account, tt.albumID, tt.photoID)
+		}
+		crawl := tt.crawl
+		if crawl == "" {
+			crawl = tt.url
+		}
+		if got := c.CrawlURL(); got != crawl {
+			t.Errorf("%s: crawl URL %s, want %s", tt.in, got, crawl)
+		}
+	}
+}
+
+func TestResolveZoneramaLinkRejects(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"https://example.com/FKKofolaKrnov",
+		"ftp://eu.zonerama.com/FKKofolaKrnov",
+		"https://a.b.zonerama.com/Album/1",
+		"https://eu.zonerama.com.example.com/FKKofolaKrnov",
+	} {
+		if c, err := resolveZoneramaLink(in); err == nil {
+			t.Errorf("%q: resolved to %s, want an error", in, c.URL)
+		}
+	}
+}
+
+// Gallery links and album tiles of the fixtures are already canonical, and the
+// short photo link built from them resolves back to the same photo.
+func TestResolveZoneramaLinkFixtures(t *testing.T) {
+	doc := loadFixture(t, "snippet3.html")
+	links := map[string]bool{}
+	doc.Find("a[href*='/Photo/']").Each(func(i int, a *goquery.Selection) {
+		links[a.AttrOr("href", "")] = true
+	})
+	if len(links) == 0 {
+		t.Fatal("snippet3.html: no photo links")
+	}
+	for link := range links {
+		c, err := resolveZoneramaLink(link)
+		if err != nil {
+			t.Errorf("%s: %v", link, err)
+			continue
+		}
+		if c.Kind != "photo" || c.AlbumID != "13903610" || !strings.HasSuffix(link, "/"+c.PhotoID) || c.URL != link {
+			t.Errorf("%s: got %s %s album=%s photo=%s", link, c.Kind, c.URL, c.AlbumID, c.PhotoID)
+			continue
+		}
+		short := "https://eu.zonerama.com/Link/Photo/" + c.PhotoID + "/" + c.AlbumID
+		again, err := resolveZoneramaLink(short)
+		if err != nil || again.URL != short || again.AlbumID != c.AlbumID || again.PhotoID != c.PhotoID {
+			t.Errorf("%s: short link did not round-trip: %+v, %v", short, again, err)
+		}
+	}
+	for _, a := range fixtureAlbums(t, "clean.html") {
+		c, err := resolveZoneramaLink(a.URL)
+		if err != nil || c.Kind != "album" || c.URL != a.URL || c.Account != "FKKofolaKrnov" {
+			t.Errorf("%s: got %+v, %v", a.URL, c, err)
+		}
+	}
+}
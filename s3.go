@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// s3Store writes objects to an S3-compatible service (AWS S3, MinIO, ...). Requests
+// are signed with AWS Signature Version 4; objects larger than one part go up as
+// multipart uploads, and URL hands out presigned GET links.
+type s3Store struct {
+	endpoint  *url.URL // scheme://host[:port]
+	region    string
+	bucket    string
+	prefix    string // prepended to every key, e.g. "zonerama/"
+	accessKey string
+	secretKey string
+	token     string // optional session token
+	pathStyle bool   // https://host/bucket/key instead of https://bucket.host/key
+	partSize  int
+	urlTTL    time.Duration
+	client    *http.Client
+}
+
+// S3 requires parts of at least 5 MiB, except the last one.
+const s3MinPartSize = 5 << 20
+
+func newS3Store() (*s3Store, error) {
+	endpoint := envString("ZONERAMA_S3_ENDPOINT", "https://s3.amazonaws.com")
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid ZONERAMA_S3_ENDPOINT %q", endpoint)
+	}
+	s := &s3Store{
+		endpoint:  &url.URL{Scheme: u.Scheme, Host: u.Host},
+		region:    envString("ZONERAMA_S3_REGION", "us-east-1"),
+		bucket:    envString("ZONERAMA_S3_BUCKET", ""),
+		prefix:    strings.Trim(envString("ZONERAMA_S3_PREFIX", ""), "/"),
+		accessKey: envString("ZONERAMA_S3_ACCESS_KEY", ""),
+		secretKey: envString("ZONERAMA_S3_SECRET_KEY", ""),
+		token:     envString("ZONERAMA_S3_SESSION_TOKEN", ""),
+		pathStyle: envBool("ZONERAMA_S3_PATH_STYLE", true),
+		partSize:  max(envInt("ZONERAMA_S3_PART_SIZE", 16<<20), s3MinPartSize),
+		urlTTL:    storageURLTTL,
+		client:    &http.Client{Timeout: 5 * time.Minute},
+	}
+	if s.bucket == "" || s.accessKey == "" || s.secretKey == "" {
+		return nil, errors.New("ZONERAMA_S3_BUCKET, ZONERAMA_S3_ACCESS_KEY and ZONERAMA_S3_SECRET_KEY are required")
+	}
+	if s.prefix != "" {
+		s.prefix += "/"
+	}
+	return s, nil
+}
+
+func (s *s3Store) Name() string { return "s3" }
+
+// objectURL is the unsigned URL of key, with an optional query.
+func (s *s3Store) objectURL(key string, query url.Values) *url.URL {
+	u := *s.endpoint
+	if s.pathStyle {
+		u.Path = "/" + s.bucket + "/" + s.prefix + key
+	} else {
+		u.Host = s.bucket + "." + u.Host
+		u.Path = "/" + s.prefix + key
+	}
+	u.RawPath = s3EscapePath(u.Path)
+	u.RawQuery = s3CanonicalQuery(query)
+	return &u
+}
+
+// Put uploads r in one request when it fits in a part, otherwise as a multipart upload.
+func (s *s3Store) Put(ctx context.Context, key string, r io.Reader, contentType string) error {
+	first, err := s.readPart(r)
+	if err != nil {
+		return err
+	}
+	if len(first) < s.partSize {
+		h := http.Header{"Content-Type": {contentType}}
+		_, err := s.do(ctx, http.MethodPut, key, nil, h, first)
+		return err
+	}
+	return s.putMultipart(ctx, key, first, r, contentType)
+}
+
+// readPart reads up to partSize bytes. The buffer grows with the data, so small
+// objects do not pay for a whole part.
+func (s *s3Store) readPart(r io.Reader) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(io.LimitReader(r, int64(s.partSize))); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (s *s3Store) putMultipart(ctx context.Context, key string, first []byte, r io.Reader, contentType string) error {
+	resp, err := s.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, http.Header{"Content-Type": {contentType}}, nil)
+	if err != nil {
+		return err
+	}
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.Unmarshal(resp, &initiated); err != nil || initiated.UploadID == "" {
+		return fmt.Errorf("s3: starting multipart upload of %s: bad response", key)
+	}
+	uploadID := initiated.UploadID
+
+	type part struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	}
+	var parts []part
+	buf := first
+	for num := 1; ; num++ {
+		etag, err := s.uploadPart(ctx, key, uploadID, num, buf)
+		if err != nil {
+			s.abortMultipart(key, uploadID)
+			return err
+		}
+		parts = append(parts, part{PartNumber: num, ETag: etag})
+		if buf, err = s.readPart(r); err != nil {
+			s.abortMultipart(key, uploadID)
+			return err
+		}
+		if len(buf) == 0 {
+			break
+		}
+	}
+
+	body, _ := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}{Parts: parts})
+	resp, err = s.do(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, http.Header{"Content-Type": {"application/xml"}}, body)
+	if err == nil && bytes.Contains(resp, []byte("<Error>")) {
+		// S3 can answer 200 and still fail the completion
+		err = fmt.Errorf("s3: completing multipart upload of %s: %s", key, s3ErrorMessage(resp))
+	}
+	if err != nil {
+		s.abortMultipart(key, uploadID)
+	}
+	return err
+}
+
+func (s *s3Store) uploadPart(ctx context.Context, key, uploadID string, num int, data []byte) (string, error) {
+	q := url.Values{"partNumber": {strconv.Itoa(num)}, "uploadId": {uploadID}}
+	req, err := s.request(ctx, http.MethodPut, key, q, nil, data)
+	if err != nil {
+		return "", err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("s3: uploading part %d of %s: %d %s", num, key, resp.StatusCode, s3ErrorMessage(msg))
+	}
+	return resp.Header.Get("ETag"), nil
+}
+
+// abortMultipart drops the parts of a failed upload so they do not linger in the bucket.
+func (s *s3Store) abortMultipart(key, uploadID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	_, _ = s.do(ctx, http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil, nil)
+}
+
+// URL presigns a GET for key, valid for urlTTL.
+func (s *s3Store) URL(key string) (string, error) {
+	return s.presign(key, time.Now().UTC()), nil
+}
+
+func (s *s3Store) presign(key string, now time.Time) string {
+	amzDate := now.Format("20060102T150405Z")
+	scope := now.Format("20060102") + "/" + s.region + "/s3/aws4_request"
+	q := url.Values{
+		"X-Amz-Algorithm":     {"AWS4-HMAC-SHA256"},
+		"X-Amz-Credential":    {s.accessKey + "/" + scope},
+		"X-Amz-Date":          {amzDate},
+		"X-Amz-Expires":       {strconv.Itoa(int(s.urlTTL / time.Second))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	if s.token != "" {
+		q.Set("X-Amz-Security-Token", s.token)
+	}
+	u := s.objectURL(key, q)
+	canonical := strings.Join([]string{http.MethodGet, u.EscapedPath(), u.RawQuery, "host:" + u.Host + "\n", "host", "UNSIGNED-PAYLOAD"}, "\n")
+	u.RawQuery += "&X-Amz-Signature=" + s.signature(now, amzDate, scope, canonical)
+	return u.String()
+}
+
+// do sends a signed request and returns the response body, or an error for non-2xx answers.
+func (s *s3Store) do(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte) ([]byte, error) {
+	req, err := s.request(ctx, method, key, query, header, body)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("s3: %s %s: %d %s", method, key, resp.StatusCode, s3ErrorMessage(data))
+	}
+	return data, nil
+}
+
+// request builds a header-signed request. The payload hash covers body, which is always in memory.
+func (s *s3Store) request(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte) (*http.Request, error) {
+	u := s.objectURL(key, query)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	scope := now.Format("20060102") + "/" + s.region + "/s3/aws4_request"
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.token != "" {
+		req.Header.Set("X-Amz-Security-Token", s.token)
+	}
+	signed := []string{"host"}
+	lines := map[string]string{"host": u.Host}
+	for k := range req.Header {
+		lk := strings.ToLower(k)
+		if strings.HasPrefix(lk, "x-amz-") || lk == "content-type" {
+			signed = append(signed, lk)
+			lines[lk] = strings.TrimSpace(req.Header.Get(k))
+		}
+	}
+	sort.Strings(signed)
+	var canonHeaders strings.Builder
+	for _, k := range signed {
+		canonHeaders.WriteString(k + ":" + lines[k] + "\n")
+	}
+	signedHeaders := strings.Join(signed, ";")
+	canonical := strings.Join([]string{method, u.EscapedPath(), u.RawQuery, canonHeaders.String(), signedHeaders, payloadHash}, "\n")
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, s.signature(now, amzDate, scope, canonical)))
+	return req, nil
+}
+
+func (s *s3Store) signature(now time.Time, amzDate, scope, canonicalRequest string) string {
+	sum := sha256.Sum256([]byte(canonicalRequest))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), now.Format("20060102"))
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, toSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape percent-encodes everything but the unreserved characters, as SigV4 requires.
+func s3Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func s3EscapePath(p string) string {
+	segs := strings.Split(p, "/")
+	for i, seg := range segs {
+		segs[i] = s3Escape(seg)
+	}
+	return strings.Join(segs, "/")
+}
+
+// s3CanonicalQuery sorts and encodes query parameters the way SigV4 signs them.
+func s3CanonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		vs := append([]string(nil), q[k]...)
+		sort.Strings(vs)
+		for _, v := range vs {
+			parts = append(parts, s3Escape(k)+"="+s3Escape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3ErrorMessage pulls Code and Message out of an S3 XML error body.
+func s3ErrorMessage(body []byte) string {
+	var e struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if xml.Unmarshal(body, &e) != nil || e.Code == "" {
+		return strings.TrimSpace(string(body))
+	}
+	return e.Code + ": " + e.Message
+}
@@ -0,0 +1,589 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+	"go.yaml.in/yaml/v2"
+)
+
+// selectorsVersion is the schema version this build understands.
+const selectorsVersion = 1
+
+// Selectors holds every CSS selector and extraction rule the parsers use. The
+// built-in defaults match Zonerama's current markup; ZONERAMA_SELECTORS points at
+// a YAML or JSON file whose fields override them, reloaded when it changes.
+type Selectors struct {
+	Version  int              `yaml:"version" json:"version"`
+	Revision string           `yaml:"revision,omitempty" json:"revision,omitempty"` // free-form label, echoed in responses
+	Router   RouterSelectors  `yaml:"router" json:"router"`
+	Profile  ProfileSelectors `yaml:"profile" json:"profile"`
+	Album    AlbumSelectors   `yaml:"album" json:"album"`
+	Photo    PhotoSelectors   `yaml:"photo" json:"photo"`
+	Page     PageSelectors    `yaml:"page" json:"page"`
+}
+
+// RouterSelectors classify the start page; anything else is parsed as a profile.
+type RouterSelectors struct {
+	Profile string `yaml:"profile" json:"profile"`
+	Album   string `yaml:"album" json:"album"`
+}
+
+// ProfileSelectors read the album tiles of a profile or tab page. Tiles and
+// TileURL are strategies: the first that matches wins.
+type ProfileSelectors struct {
+	Tiles       []SelectorRule `yaml:"tiles" json:"tiles"`
+	TileURL     []SelectorRule `yaml:"tile_url" json:"tile_url"`
+	TileTitle   []SelectorRule `yaml:"tile_title" json:"tile_title"`
+	TileAlbumID SelectorRule   `yaml:"tile_album_id" json:"tile_album_id"`
+	TileInfo    string         `yaml:"tile_info" json:"tile_info"`     // the "date | photos | views" block
+	TileCounts  string         `yaml:"tile_counts" json:"tile_counts"` // within tile_info: photos, then views
+	TileCover   string         `yaml:"tile_cover" json:"tile_cover"`
+	// Read from the tile_cover image, the first non-empty wins
+	TileCoverPattern []SelectorRule `yaml:"tile_cover_pattern" json:"tile_cover_pattern"`
+}
+
+// AlbumSelectors read an album page. Photos are strategies for gallery items,
+// whose attr holds the photo ID; PhotoFallbacks extract the ID with pattern when
+// no gallery item is found.
+type AlbumSelectors struct {
+	ID             SelectorRule   `yaml:"id" json:"id"`
+	Title          SelectorRule   `yaml:"title" json:"title"`
+	Date           SelectorRule   `yaml:"date" json:"date"`
+	PhotosCount    SelectorRule   `yaml:"photos_count" json:"photos_count"`
+	Cover          SelectorRule   `yaml:"cover" json:"cover"`
+	Photos         []SelectorRule `yaml:"photos" json:"photos"`
+	PhotoLink      SelectorRule   `yaml:"photo_link" json:"photo_link"`
+	PhotoFallbacks []SelectorRule `yaml:"photo_fallbacks" json:"photo_fallbacks"`
+}
+
+// PhotoSelectors read a photo page and its slide. {id} in Slide is replaced by the photo ID.
+// Likers finds the account links of a /Part/Likers fragment. LikerName names each
+// one: the first non-empty rule wins (an empty rule reads the link text), else
+// the last segment of the link.
+type PhotoSelectors struct {
+	ID          SelectorRule   `yaml:"id" json:"id"`
+	AlbumID     SelectorRule   `yaml:"album_id" json:"album_id"`
+	AccountID   SelectorRule   `yaml:"account_id" json:"account_id"`
+	AlbumLink   string         `yaml:"album_link" json:"album_link"`
+	Items       SelectorRule   `yaml:"items" json:"items"` // comma-separated photo IDs of the album, in order
+	Likes       []SelectorRule `yaml:"likes" json:"likes"`
+	Slide       string         `yaml:"slide" json:"slide"`
+	SlideScope  string         `yaml:"slide_scope" json:"slide_scope"`
+	Info        string         `yaml:"info" json:"info"`
+	Title       string         `yaml:"title" json:"title"`
+	Description string         `yaml:"description" json:"description"`
+	Likers      SelectorRule   `yaml:"likers" json:"likers"`
+	LikerName   []SelectorRule `yaml:"liker_name" json:"liker_name"`
+}
+
+// PageSelectors are shared by every page kind. {type} in LikeCounter is the
+// like object type (1 album, 2 photo). InfoCells are the label and value cells
+// of an InfoRows row; VideoDuration rules are tried on a video item until one
+// gives a duration.
+type PageSelectors struct {
+	Lang           SelectorRule   `yaml:"lang" json:"lang"`
+	TimezoneOffset SelectorRule   `yaml:"timezone_offset" json:"timezone_offset"`
+	VideoExts      SelectorRule   `yaml:"video_exts" json:"video_exts"`
+	LikeCounter    string         `yaml:"like_counter" json:"like_counter"`
+	InfoRows       string         `yaml:"info_rows" json:"info_rows"`
+	InfoCells      string         `yaml:"info_cells" json:"info_cells"`
+	VideoDuration  []SelectorRule `yaml:"video_duration" json:"video_duration"`
+}
+
+// SelectorRule extracts one value: the first element matching Selector within
+// the scope (the scope itself when empty), its Attr or else its text, and the
+// first group of Pattern (or the whole match) when set.
+type SelectorRule struct {
+	Name     string `yaml:"name,omitempty" json:"name,omitempty"` // strategy name, reported in parser.strategies
+	Selector string `yaml:"selector,omitempty" json:"selector,omitempty"`
+	Attr     string `yaml:"attr,omitempty" json:"attr,omitempty"`
+	Pattern  string `yaml:"pattern,omitempty" json:"pattern,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// find returns the rule's matches within scope.
+func (r SelectorRule) find(scope *goquery.Selection) *goquery.Selection {
+	if r.Selector == "" {
+		return scope
+	}
+	return scope.Find(r.Selector)
+}
+
+// raw reads Attr, or the text, of el without applying Pattern.
+func (r SelectorRule) raw(el *goquery.Selection) string {
+	if r.Attr != "" {
+		return strings.TrimSpace(el.AttrOr(r.Attr, ""))
+	}
+	return strings.TrimSpace(el.Text())
+}
+
+// match applies Pattern to a raw value; "" when it does not match.
+func (r SelectorRule) match(v string) string {
+	if r.re == nil || v == "" {
+		return v
+	}
+	m := r.re.FindStringSubmatch(v)
+	switch {
+	case m == nil:
+		return ""
+	case len(m) > 1:
+		return m[1]
+	}
+	return m[0]
+}
+
+// read extracts the value from el itself.
+func (r SelectorRule) read(el *goquery.Selection) string {
+	return r.match(r.raw(el))
+}
+
+// describe is the rule as shown in messages: the selector and "@attr" when set.
+func (r SelectorRule) describe() string {
+	s := r.Selector
+	if s == "" {
+		s = "(scope)"
+	}
+	if r.Attr != "" {
+		s += " @" + r.Attr
+	}
+	return s
+}
+
+// describeRules lists the selectors of a strategy list, in order.
+func describeRules(rules []SelectorRule) string {
+	out := make([]string, len(rules))
+	for i, r := range rules {
+		out[i] = r.describe()
+	}
+	return strings.Join(out, " | ")
+}
+
+// value extracts the value from the first match within scope.
+func (r SelectorRule) value(scope *goquery.Selection) string {
+	return r.read(r.find(scope).First())
+}
+
+// firstValue tries rules in order and returns the first non-empty value.
+func firstValue(rules []SelectorRule, scope *goquery.Selection) (value, strategy string) {
+	for _, r := range rules {
+		if v := r.value(scope); v != "" {
+			return v, r.Name
+		}
+	}
+	return "", strategyNone
+}
+
+// firstMatch tries rules in order and returns the matches of the first that finds any.
+func firstMatch(rules []SelectorRule, scope *goquery.Selection) (*goquery.Selection, SelectorRule) {
+	for _, r := range rules {
+		if s := r.find(scope); s.Length() > 0 {
+			return s, r
+		}
+	}
+	return scope.Slice(0, 0), SelectorRule{Name: strategyNone}
+}
+
+// defaultSelectors returns a fresh copy of the built-in selectors.
+func defaultSelectors() *Selectors {
+	return &Selectors{
+		Version: selectorsVersion,
+		Router: RouterSelectors{
+			Profile: "li.list-alb, #profile-albums",
+			Album:   "meta[property='znrm:album'], .row-name-album",
+		},
+		Profile: ProfileSelectors{
+			Tiles: []SelectorRule{
+				{Name: "list-alb", Selector: "li.list-alb"},
+				{Name: "data-type-album", Selector: "[data-type='album'], li[class*='list-alb']"},
+			},
+			TileURL: []SelectorRule{
+				{Name: "data-url", Attr: "data-url"},
+				{Name: "thumbnail-anchor", Selector: "a.thumbnail", Attr: "href"},
+				{Name: "first-anchor", Selector: "a", Attr: "href"},
+			},
+			TileTitle: []SelectorRule{
+				{Selector: "a.thumbnail", Attr: "title"},
+				{Selector: "h2"},
+			},
+			TileAlbumID: SelectorRule{Attr: "data-album-id"},
+			TileInfo:    "p",
+			TileCounts:  "span",
+			TileCover:   "img[data-pattern], img[data-image]",
+			TileCoverPattern: []SelectorRule{
+				{Attr: "data-pattern"},
+				{Attr: "data-image"},
+			},
+		},
+		Album: AlbumSelectors{
+			ID:          SelectorRule{Selector: "meta[property='znrm:album']", Attr: "content"},
+			Title:       SelectorRule{Selector: ".row-name-album h2 span"},
+			Date:        SelectorRule{Selector: ".row-name-album .album-info .hide-on-phone"},
+			PhotosCount: SelectorRule{Selector: ".row-name-album [data-id='header-album-photos']"},
+			Cover:       SelectorRule{Selector: "meta[property='og:image']", Attr: "content"},
+			Photos: []SelectorRule{
+				{Name: "data-type", Selector: "[data-type='photo'][data-id], [data-type='video'][data-id]", Attr: "data-id"},
+				{Name: "gallery-inner", Selector: ".gallery-inner [data-id]", Attr: "data-id"},
+			},
+			PhotoLink: SelectorRule{Selector: "a.gallery-link", Attr: "href"},
+			PhotoFallbacks: []SelectorRule{
+				{Name: "photo-anchors", Selector: "a[href*='/Photo/']", Attr: "href", Pattern: `/Photo/\d+/(\d+)`},
+				{Name: "photo-images", Selector: "img[src*='/photos/']", Attr: "src", Pattern: `/photos/(\d+)_`},
+			},
+		},
+		Photo: PhotoSelectors{
+			ID:        SelectorRule{Selector: "meta[property='znrm:photo']", Attr: "content"},
+			AlbumID:   SelectorRule{Selector: "meta[property='znrm:album']", Attr: "content"},
+			AccountID: SelectorRule{Selector: "meta[property='znrm:account']", Attr: "content"},
+			AlbumLink: "#photo-title",
+			Items:     SelectorRule{Selector: "#photo-data", Attr: "data-items-id"},
+			Likes: []SelectorRule{
+				{Selector: "#photo-like [data-id='like-counter']"},
+				{Selector: "#photo-likes"},
+			},
+			Slide:       "[data-panzoom-id='{id}']",
+			SlideScope:  ".full-gallery-spc0",
+			Info:        "[data-id='photo-data-read']",
+			Title:       ".photo-desc-data-name",
+			Description: ".photo-desc-data-desc",
+			Likers:      SelectorRule{Selector: "a[href]", Attr: "href"},
+			LikerName: []SelectorRule{
+				{Attr: "title"},
+				{Selector: "img", Attr: "alt"},
+				{},
+			},
+		},
+		Page: PageSelectors{
+			Lang:           SelectorRule{Selector: "meta[property='znrm:lang']", Attr: "content"},
+			TimezoneOffset: SelectorRule{Selector: "meta[property='znrm:timezoneoffset']", Attr: "content"},
+			VideoExts:      SelectorRule{Selector: "meta[property='znrm:videos.ext']", Attr: "content"},
+			LikeCounter:    "[data-object='like'][data-like-objecttype='{type}'] [data-id='like-counter']",
+			InfoRows:       ".param table tr",
+			InfoCells:      "td",
+			VideoDuration: []SelectorRule{
+				{Attr: "data-duration"},
+				{Attr: "data-video-duration"},
+				{Selector: ".video-duration, .duration, [data-id='video-duration']"},
+			},
+		},
+	}
+}
+
+// compile checks every selector and pattern and prepares the patterns. Errors
+// name the offending field, e.g. "album.photos[1].selector".
+func (s *Selectors) compile() error {
+	if s.Version != selectorsVersion {
+		return fmt.Errorf("version %d is not supported, use %d", s.Version, selectorsVersion)
+	}
+	var errs []error
+	css := func(field, sel string, required bool) {
+		if sel == "" {
+			if required {
+				errs = append(errs, fmt.Errorf("%s: selector is required", field))
+			}
+			return
+		}
+		// Templates are checked with a sample value
+		sel = strings.NewReplacer("{id}", "0", "{type}", "0").Replace(sel)
+		if _, err := cascadia.ParseGroup(sel); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", field, err))
+		}
+	}
+	rule := func(field string, r *SelectorRule, needSelector bool) {
+		css(field+".selector", r.Selector, needSelector)
+		if r.Pattern != "" {
+			re, err := regexp.Compile(r.Pattern)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s.pattern: %v", field, err))
+			}
+			r.re = re
+		}
+	}
+	strategies := func(field string, rules []SelectorRule, needSelector bool) {
+		if len(rules) == 0 {
+			errs = append(errs, fmt.Errorf("%s: at least one rule is required", field))
+		}
+		names := map[string]bool{}
+		for i := range rules {
+			f := fmt.Sprintf("%s[%d]", field, i)
+			if rules[i].Name == "" || rules[i].Name == strategyNone || names[rules[i].Name] {
+				errs = append(errs, fmt.Errorf("%s.name: a unique name other than %q is required", f, strategyNone))
+			}
+			names[rules[i].Name] = true
+			rule(f, &rules[i], needSelector)
+		}
+	}
+	// Fallback lists are tried in order but not reported, so they need no names
+	fallbacks := func(field string, rules []SelectorRule, needAttr bool) {
+		if len(rules) == 0 {
+			errs = append(errs, fmt.Errorf("%s: at least one rule is required", field))
+		}
+		for i := range rules {
+			f := fmt.Sprintf("%s[%d]", field, i)
+			if needAttr && rules[i].Attr == "" {
+				errs = append(errs, fmt.Errorf("%s.attr: an attribute is required", f))
+			}
+			rule(f, &rules[i], false)
+		}
+	}
+
+	css("router.profile", s.Router.Profile, true)
+	css("router.album", s.Router.Album, true)
+
+	strategies("profile.tiles", s.Profile.Tiles, true)
+	strategies("profile.tile_url", s.Profile.TileURL, false)
+	for i := range s.Profile.TileTitle {
+		rule(fmt.Sprintf("profile.tile_title[%d]", i), &s.Profile.TileTitle[i], false)
+	}
+	rule("profile.tile_album_id", &s.Profile.TileAlbumID, false)
+	css("profile.tile_info", s.Profile.TileInfo, true)
+	css("profile.tile_counts", s.Profile.TileCounts, true)
+	css("profile.tile_cover", s.Profile.TileCover, true)
+	fallbacks("profile.tile_cover_pattern", s.Profile.TileCoverPattern, true)
+
+	rule("album.id", &s.Album.ID, true)
+	rule("album.title", &s.Album.Title, true)
+	rule("album.date", &s.Album.Date, true)
+	rule("album.photos_count", &s.Album.PhotosCount, true)
+	rule("album.cover", &s.Album.Cover, true)
+	strategies("album.photos", s.Album.Photos, true)
+	for i, r := range s.Album.Photos {
+		if r.Attr == "" {
+			errs = append(errs, fmt.Errorf("album.photos[%d].attr: the attribute holding the photo ID is required", i))
+		}
+	}
+	rule("album.photo_link", &s.Album.PhotoLink, true)
+	for i := range s.Album.PhotoFallbacks {
+		f := fmt.Sprintf("album.photo_fallbacks[%d]", i)
+		if s.Album.PhotoFallbacks[i].Name == "" {
+			errs = append(errs, fmt.Errorf("%s.name: a name is required", f))
+		}
+		rule(f, &s.Album.PhotoFallbacks[i], true)
+	}
+
+	rule("photo.id", &s.Photo.ID, true)
+	rule("photo.album_id", &s.Photo.AlbumID, true)
+	rule("photo.account_id", &s.Photo.AccountID, true)
+	css("photo.album_link", s.Photo.AlbumLink, true)
+	rule("photo.items", &s.Photo.Items, true)
+	for i := range s.Photo.Likes {
+		rule(fmt.Sprintf("photo.likes[%d]", i), &s.Photo.Likes[i], true)
+	}
+	css("photo.slide", s.Photo.Slide, true)
+	css("photo.slide_scope", s.Photo.SlideScope, true)
+	css("photo.info", s.Photo.Info, true)
+	css("photo.title", s.Photo.Title, true)
+	css("photo.description", s.Photo.Description, true)
+	rule("photo.likers", &s.Photo.Likers, true)
+	if s.Photo.Likers.Attr == "" {
+		errs = append(errs, errors.New("photo.likers.attr: the attribute holding the account link is required"))
+	}
+	fallbacks("photo.liker_name", s.Photo.LikerName, false)
+
+	rule("page.lang", &s.Page.Lang, true)
+	rule("page.timezone_offset", &s.Page.TimezoneOffset, true)
+	rule("page.video_exts", &s.Page.VideoExts, true)
+	css("page.like_counter", s.Page.LikeCounter, true)
+	css("page.info_rows", s.Page.InfoRows, true)
+	css("page.info_cells", s.Page.InfoCells, true)
+	fallbacks("page.video_duration", s.Page.VideoDuration, false)
+	return errors.Join(errs...)
+}
+
+// The active selectors. Handlers take one snapshot per request, so a reload
+// never mixes two configurations within a crawl.
+var (
+	activeSelectors atomic.Pointer[Selectors]
+	selectorsState  struct {
+		sync.Mutex
+		source    string // file path, or "built-in"
+		loadedAt  time.Time
+		modTime   time.Time
+		lastError string
+	}
+	selectorsPoll = envMillis("ZONERAMA_SELECTORS_POLL_MS", 5*time.Second)
+)
+
+func init() {
+	s := defaultSelectors()
+	if err := s.compile(); err != nil {
+		panic("built-in selectors: " + err.Error())
+	}
+	activeSelectors.Store(s)
+	selectorsState.source, selectorsState.loadedAt = "built-in", time.Now()
+}
+
+// currentSelectors returns the active configuration; treat it as read-only.
+func currentSelectors() *Selectors {
+	return activeSelectors.Load()
+}
+
+// loadSelectorsFile reads a YAML or JSON (.json) file over the built-in defaults.
+// Unknown keys are rejected, so a typo cannot silently keep a default.
+func loadSelectorsFile(path string) (*Selectors, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := defaultSelectors()
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		decode := func(v *Selectors) error {
+			dec := json.NewDecoder(strings.NewReader(string(data)))
+			dec.DisallowUnknownFields()
+			return dec.Decode(v)
+		}
+		// Lists come from a second decode into empty selectors, see takeLists
+		var fresh Selectors
+		if err = decode(s); err == nil {
+			err = decode(&fresh)
+			s.takeLists(&fresh)
+		}
+	} else {
+		err = yaml.UnmarshalStrict(data, s)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if err := s.compile(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// takeLists replaces s's rule lists with those fresh has. encoding/json decodes
+// list items over the elements already in place, so a list decoded over the
+// defaults would keep the attrs and patterns of the default rules it replaced.
+// YAML replaces lists whole.
+func (s *Selectors) takeLists(fresh *Selectors) {
+	for _, l := range []struct {
+		dst *[]SelectorRule
+		src []SelectorRule
+	}{
+		{&s.Profile.Tiles, fresh.Profile.Tiles},
+		{&s.Profile.TileURL, fresh.Profile.TileURL},
+		{&s.Profile.TileTitle, fresh.Profile.TileTitle},
+		{&s.Profile.TileCoverPattern, fresh.Profile.TileCoverPattern},
+		{&s.Album.Photos, fresh.Album.Photos},
+		{&s.Album.PhotoFallbacks, fresh.Album.PhotoFallbacks},
+		{&s.Photo.Likes, fresh.Photo.Likes},
+		{&s.Photo.LikerName, fresh.Photo.LikerName},
+		{&s.Page.VideoDuration, fresh.Page.VideoDuration},
+	} {
+		// nil when the file does not have the list
+		if l.src != nil {
+			*l.dst = l.src
+		}
+	}
+}
+
+// reloadSelectors loads path and activates it. On error the previous
+// configuration stays active and the error is kept for GET /selectors.
+func reloadSelectors(path string) error {
+	fi, statErr := os.Stat(path)
+	s, err := loadSelectorsFile(path)
+	selectorsState.Lock()
+	defer selectorsState.Unlock()
+	if statErr == nil {
+		selectorsState.modTime = fi.ModTime()
+	}
+	if err != nil {
+		selectorsState.lastError = err.Error()
+		return err
+	}
+	activeSelectors.Store(s)
+	selectorsState.source, selectorsState.loadedAt, selectorsState.lastError = path, time.Now(), ""
+	log.Printf("selectors: loaded %s (revision %q)", path, s.Revision)
+	return nil
+}
+
+// startSelectors loads ZONERAMA_SELECTORS, if set, and reloads it when the file
+// changes or the process gets SIGHUP. A broken file at startup is fatal; later
+// it only logs and keeps the last good configuration.
+func startSelectors() {
+	path := envString("ZONERAMA_SELECTORS", "")
+	if path == "" {
+		return
+	}
+	if err := reloadSelectors(path); err != nil {
+		log.Fatalf("selectors: %v", err)
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		tick := time.NewTicker(max(selectorsPoll, 100*time.Millisecond))
+		defer tick.Stop()
+		for {
+			select {
+			case <-hup:
+			case <-tick.C:
+				fi, err := os.Stat(path)
+				selectorsState.Lock()
+				unchanged := err == nil && fi.ModTime().Equal(selectorsState.modTime)
+				selectorsState.Unlock()
+				if err != nil || unchanged {
+					continue
+				}
+			}
+			if err := reloadSelectors(path); err != nil {
+				log.Printf("selectors: keeping the previous configuration: %v", err)
+			}
+		}
+	}()
+}
+
+// selectorsHandler serves GET /selectors: the active configuration and where it came from.
+func selectorsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	selectorsState.Lock()
+	out := map[string]any{
+		"source":    selectorsState.source,
+		"loaded_at": selectorsState.loadedAt.UTC().Format(time.RFC3339),
+		"selectors": currentSelectors(),
+	}
+	if selectorsState.lastError != "" {
+		out["last_error"] = selectorsState.lastError
+	}
+	selectorsState.Unlock()
+	writeJSON(w, r, out)
+}
+
+// runSelectorsCLI prints the built-in selectors as YAML, a starting point for
+// ZONERAMA_SELECTORS; with a file argument it validates that file instead.
+func runSelectorsCLI(args []string) int {
+	if len(args) > 1 {
+		fmt.Fprintln(os.Stderr, "usage: zonerama selectors [file.yaml|file.json]")
+		return 2
+	}
+	if len(args) == 1 {
+		s, err := loadSelectorsFile(args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("%s: ok (version %d, revision %q)\n", args[0], s.Version, s.Revision)
+		return 0
+	}
+	out, err := yaml.Marshal(defaultSelectors())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	os.Stdout.Write(out)
+	return 0
+}
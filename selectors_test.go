@@ -0,0 +1,162 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestSelectorRule(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(
+		`<div><a class="x" href="/Photo/12/345">  Link  </a><img src="/photos/678_750x500.jpg"></div>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := []SelectorRule{
+		{Name: "missing", Selector: "span"},
+		{Name: "anchor", Selector: "a", Attr: "href", Pattern: `/Photo/\d+/(\d+)`},
+	}
+	s := defaultSelectors()
+	s.Album.PhotoFallbacks = rules
+	if err := s.compile(); err != nil {
+		t.Fatal(err)
+	}
+	rules = s.Album.PhotoFallbacks
+	if v, name := firstValue(rules, doc.Selection); v != "345" || name != "anchor" {
+		t.Errorf("firstValue: got %q from %s", v, name)
+	}
+	if v, name := firstValue(rules[:1], doc.Selection); v != "" || name != strategyNone {
+		t.Errorf("firstValue without a match: got %q from %s", v, name)
+	}
+	if sel, r := firstMatch(rules, doc.Selection); sel.Length() != 1 || r.Name != "anchor" {
+		t.Errorf("firstMatch: got %d from %s", sel.Length(), r.Name)
+	}
+	if v := (SelectorRule{Selector: "a"}).value(doc.Selection); v != "Link" {
+		t.Errorf("text value: got %q", v)
+	}
+	if d := rules[1].describe(); d != "a @href" {
+		t.Errorf("describe: got %q", d)
+	}
+}
+
+func TestSelectorsCompile(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(s *Selectors)
+		want string // error substring
+	}{
+		{"version", func(s *Selectors) { s.Version = 2 }, "version 2 is not supported"},
+		{"css", func(s *Selectors) { s.Router.Album = "[" }, "router.album"},
+		{"template", func(s *Selectors) { s.Photo.Slide = "[data-panzoom-id='{id}'" }, "photo.slide"},
+		{"pattern", func(s *Selectors) { s.Album.PhotoFallbacks[1].Pattern = "(" }, "album.photo_fallbacks[1].pattern"},
+		{"empty strategies", func(s *Selectors) { s.Profile.Tiles = nil }, "profile.tiles: at least one rule"},
+		{"duplicate name", func(s *Selectors) { s.Profile.TileURL[1].Name = "data-url" }, "profile.tile_url[1].name"},
+		{"reserved name", func(s *Selectors) { s.Album.Photos[0].Name = strategyNone }, "album.photos[0].name"},
+		{"photo attr", func(s *Selectors) { s.Album.Photos[1].Attr = "" }, "album.photos[1].attr"},
+		{"required", func(s *Selectors) { s.Album.Title.Selector = "" }, "album.title.selector: selector is required"},
+		{"likers attr", func(s *Selectors) { s.Photo.Likers.Attr = "" }, "photo.likers.attr"},
+	}
+	for _, tt := range tests {
+		s := defaultSelectors()
+		tt.edit(s)
+		if err := s.compile(); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestLoadSelectorsFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	s, err := loadSelectorsFile(write("ok.yaml", `
+version: 1
+revision: test
+router:
+  album: ".album-page"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Overridden fields change, everything else keeps the default
+	if s.Revision != "test" || s.Router.Album != ".album-page" || s.Router.Profile != defaultSelectors().Router.Profile {
+		t.Errorf("got revision %q, router %+v", s.Revision, s.Router)
+	}
+	if s.Album.PhotoFallbacks[0].re == nil {
+		t.Error("patterns were not compiled")
+	}
+
+	s, err = loadSelectorsFile(write("ok.json", `{"version": 1, "album": {"title": {"selector": "h1"}}}`))
+	if err != nil || s.Album.Title.Selector != "h1" {
+		t.Errorf("JSON: got %+v, %v", s, err)
+	}
+
+	// A list in the file replaces the default list whole, in either format
+	for name, data := range map[string]string{
+		"list.json": `{"version": 1, "album": {"photo_fallbacks": [{"name": "imgs", "selector": "img", "attr": "src"}]}}`,
+		"list.yaml": "version: 1\nalbum:\n  photo_fallbacks:\n    - {name: imgs, selector: img, attr: src}\n",
+	} {
+		s, err := loadSelectorsFile(write(name, data))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		want := []SelectorRule{{Name: "imgs", Selector: "img", Attr: "src"}}
+		if got := s.Album.PhotoFallbacks; len(got) != 1 || got[0] != want[0] || got[0].re != nil {
+			t.Errorf("%s: got %+v, want %+v", name, got, want)
+		}
+		if len(s.Album.Photos) != len(defaultSelectors().Album.Photos) {
+			t.Errorf("%s: lists the file leaves out lost their defaults", name)
+		}
+	}
+
+	for name, data := range map[string]string{
+		"typo.yaml":    "version: 1\nrouter:\n  albums: x\n",
+		"typo.json":    `{"version": 1, "routr": {}}`,
+		"invalid.yaml": "version: 1\nrouter:\n  album: \"[\"\n",
+	} {
+		if _, err := loadSelectorsFile(write(name, data)); err == nil || !strings.Contains(err.Error(), name) {
+			t.Errorf("%s: got %v", name, err)
+		}
+	}
+}
+
+func TestReloadSelectorsKeepsLastGood(t *testing.T) {
+	old := currentSelectors()
+	selectorsState.Lock()
+	oldSource := selectorsState.source
+	selectorsState.Unlock()
+	t.Cleanup(func() {
+		activeSelectors.Store(old)
+		selectorsState.Lock()
+		selectorsState.source, selectorsState.lastError = oldSource, ""
+		selectorsState.Unlock()
+	})
+
+	p := filepath.Join(t.TempDir(), "selectors.yaml")
+	os.WriteFile(p, []byte("version: 1\nrevision: good\n"), 0o644)
+	if err := reloadSelectors(p); err != nil || currentSelectors().Revision != "good" {
+		t.Fatalf("reload: %v, revision %q", err, currentSelectors().Revision)
+	}
+	os.WriteFile(p, []byte("version: 1\nrevision: bad\nrouter:\n  album: \"[\"\n"), 0o644)
+	if err := reloadSelectors(p); err == nil {
+		t.Fatal("broken file was accepted")
+	}
+	if currentSelectors().Revision != "good" {
+		t.Errorf("broken file replaced the configuration")
+	}
+	selectorsState.Lock()
+	lastError := selectorsState.lastError
+	selectorsState.Unlock()
+	if !strings.Contains(lastError, "router.album") {
+		t.Errorf("last error: %q", lastError)
+	}
+}
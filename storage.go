@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// objectStore is where files the service produces are written: debug HTML and
+// JSON exports. Keys are slash-separated, e.g. "exports/Account/20250928T080000Z.json".
+type objectStore interface {
+	Name() string
+	Put(ctx context.Context, key string, r io.Reader, contentType string) error
+	// URL is where a client can download key: /files/<key> for local disk,
+	// a presigned link for S3.
+	URL(key string) (string, error)
+}
+
+// Key prefixes the service writes to; /files/ serves nothing else.
+const (
+	debugPrefix  = "debuging/"
+	exportPrefix = "exports/"
+)
+
+var (
+	storageURLTTL = envMillis("ZONERAMA_STORAGE_URL_TTL_MS", time.Hour)
+	storage       = mustOpenStorage()
+)
+
+// mustOpenStorage picks the backend from ZONERAMA_STORAGE: local (default) or s3.
+func mustOpenStorage() objectStore {
+	switch kind := envString("ZONERAMA_STORAGE", "local"); kind {
+	case "local":
+		return &localStore{root: envString("ZONERAMA_STORAGE_DIR", ".")}
+	case "s3":
+		s, err := newS3Store()
+		if err != nil {
+			log.Fatalf("storage: %v", err)
+		}
+		return s
+	default:
+		log.Fatalf("storage: unknown ZONERAMA_STORAGE %q, use local or s3", kind)
+	}
+	return nil
+}
+
+// localStore writes beneath a directory on disk. The default root is the working
+// directory, so debug pages keep landing in ./debuging/.
+type localStore struct {
+	root string
+}
+
+func (l *localStore) Name() string { return "local" }
+
+func (l *localStore) Put(ctx context.Context, key string, r io.Reader, contentType string) error {
+	p := filepath.Join(l.root, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	// Write aside and rename, so readers never see a partial file
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+func (l *localStore) URL(key string) (string, error) {
+	return publicURL + "/files/" + key, nil
+}
+
+// saveDebugFile stores a fetched page under debuging/. Failures are only logged.
+func saveDebugFile(name string, body []byte) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := storage.Put(ctx, debugPrefix+name, bytes.NewReader(body), "text/html; charset=utf-8"); err != nil {
+		log.Printf("storage: saving %s: %v", name, err)
+	}
+}
+
+// Export describes a stored copy of a response (export=json).
+type Export struct {
+	Key     string `json:"key"`
+	URL     string `json:"url"`
+	Bytes   int    `json:"bytes"`
+	Storage string `json:"storage"` // local or s3
+}
+
+// parseExportParam reads export=json; empty means no export.
+func parseExportParam(s string) (string, error) {
+	switch s = strings.ToLower(strings.TrimSpace(s)); s {
+	case "", "json":
+		return s, nil
+	}
+	return "", fmt.Errorf("invalid export %q, use json", s)
+}
+
+// exportKey files a snapshot under the account, or the album for album links.
+func exportKey(canon *CanonicalLink, now time.Time) string {
+	dir := catalogAccount(canon)
+	if canon != nil && canon.AlbumID != "" {
+		dir = "album-" + canon.AlbumID
+	}
+	if dir == "" {
+		dir = "other"
+	}
+	dir = strings.NewReplacer("/", "_", "\\", "_", "..", "_", ":", "-").Replace(dir)
+	return exportPrefix + dir + "/" + now.UTC().Format("20060102T150405.000Z") + ".json"
+}
+
+// exportJSON writes v as an indented JSON snapshot and returns where it went.
+func exportJSON(ctx context.Context, canon *CanonicalLink, v any) (*Export, error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	key := exportKey(canon, time.Now())
+	if err := storage.Put(ctx, key, bytes.NewReader(data), "application/json"); err != nil {
+		return nil, err
+	}
+	u, err := storage.URL(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Export{Key: key, URL: u, Bytes: len(data), Storage: storage.Name()}, nil
+}
+
+// filesHandler serves /files/<key> (and the older /debuging/<name>): from disk for
+// the local store, as a redirect to a presigned URL for S3. Only keys under the
+// service's own prefixes are served.
+func filesHandler(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/files/")
+	if strings.HasPrefix(r.URL.Path, "/"+debugPrefix) {
+		key = strings.TrimPrefix(r.URL.Path, "/")
+	}
+	key = strings.TrimPrefix(path.Clean("/"+key), "/")
+	if !servableKey(key) {
+		writeJSONError(w, http.StatusNotFound, "not found")
+		return
+	}
+	switch s := storage.(type) {
+	case *localStore:
+		// Files only: ServeFile would list a directory such as exports/<account>
+		p := filepath.Join(s.root, filepath.FromSlash(key))
+		if fi, err := os.Stat(p); err != nil || fi.IsDir() {
+			writeJSONError(w, http.StatusNotFound, "not found")
+			return
+		}
+		http.ServeFile(w, r, p)
+	default:
+		u, err := storage.URL(key)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		http.Redirect(w, r, u, http.StatusFound)
+	}
+}
+
+// servableKey reports whether key names a file beneath one of the service's
+// prefixes, not a prefix itself.
+func servableKey(key string) bool {
+	for _, prefix := range []string{debugPrefix, exportPrefix} {
+		if name, ok := strings.CutPrefix(key, prefix); ok && name != "" {
+			return true
+		}
+	}
+	return false
+}
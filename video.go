@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Video is what an album gallery tells about a video item. The poster is the
+// item's image (Photo.Image1500 and image_pattern); the stream is only known
+// when the page exposes it.
+type Video struct {
+	Poster      string `json:"poster,omitempty"`
+	Duration    int    `json:"duration,omitempty"` // seconds
+	StreamURL   string `json:"stream_url,omitempty"`
+	DownloadURL string `json:"download_url,omitempty"`
+}
+
+// Used when the page has no znrm:videos.ext.
+var defaultVideoExts = []string{"mp4", "m4v", "mov", "webm", "mkv", "avi", "mpg", "mpeg", "wmv", "ogv", "3gp", "m3u8", "mpd"}
+
+// media= values.
+const (
+	mediaAll    = "all"
+	mediaPhotos = "photos"
+	mediaVideos = "videos"
+)
+
+func parseMediaParam(s string) (string, error) {
+	switch s = strings.ToLower(strings.TrimSpace(s)); s {
+	case "":
+		return mediaAll, nil
+	case mediaAll, mediaPhotos, mediaVideos:
+		return s, nil
+	}
+	return "", fmt.Errorf("invalid media %q, use photos, videos or all", s)
+}
+
+// mediaAllowed reports whether an item of type typ ("photo" or "video") passes media=.
+func mediaAllowed(media, typ string) bool {
+	switch media {
+	case mediaPhotos:
+		return typ == "photo"
+	case mediaVideos:
+		return typ == "video"
+	}
+	return true
+}
+
+// videoExts reads the video extensions Zonerama accepts (znrm:videos.ext).
+func videoExts(doc *goquery.Document) map[string]bool {
+	if v := currentSelectors().Page.VideoExts.value(doc.Selection); v != "" {
+		return extSet(append(strings.Split(v, ","), "m3u8", "mpd"))
+	}
+	return extSet(defaultVideoExts)
+}
+
+func extSet(list []string) map[string]bool {
+	exts := make(map[string]bool, len(list))
+	for _, e := range list {
+		exts[strings.ToLower(strings.TrimSpace(e))] = true
+	}
+	return exts
+}
+
+// itemType tells photos from videos in the album gallery.
+func itemType(s *goquery.Selection) string {
+	if strings.EqualFold(s.AttrOr("data-type", ""), "video") || s.Find("video").Length() > 0 {
+		return "video"
+	}
+	if _, ok := s.Attr("data-video"); ok {
+		return "video"
+	}
+	if _, ok := s.Attr("data-duration"); ok {
+		return "video"
+	}
+	return "photo"
+}
+
+// parseDuration reads "75", "1:15" or "1:02:03" as seconds.
+func parseDuration(s string) int {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0
+	}
+	total := 0
+	for _, part := range strings.Split(s, ":") {
+		n, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return 0
+		}
+		total = total*60 + int(n)
+	}
+	return total
+}
+
+// applyVideoItem fills the video details of a gallery item: poster from the
+// item image, duration from data-duration or a duration badge, and stream or
+// download URLs from data attributes or <video>/<source> elements whose
+// extension is a known video type. join may be nil to keep relative URLs.
+func applyVideoItem(s *goquery.Selection, p *Photo, exts map[string]bool, join func(string) string) {
+	v := &Video{Poster: p.Image1500}
+	for _, r := range currentSelectors().Page.VideoDuration {
+		if v.Duration = parseDuration(r.value(s)); v.Duration > 0 {
+			break
+		}
+	}
+	isVideo := func(u string) bool {
+		if i := strings.IndexAny(u, "?#"); i >= 0 {
+			u = u[:i]
+		}
+		return exts[strings.ToLower(strings.TrimPrefix(path.Ext(u), "."))]
+	}
+	abs := func(u string) string {
+		if join != nil && strings.HasPrefix(u, "/") {
+			return join(u)
+		}
+		return u
+	}
+	for _, attr := range []string{"data-video", "data-video-url", "data-video-src", "data-stream", "data-src"} {
+		if u := strings.TrimSpace(s.AttrOr(attr, "")); u != "" && (attr != "data-src" || isVideo(u)) {
+			v.StreamURL = abs(u)
+			break
+		}
+	}
+	if v.StreamURL == "" {
+		s.Find("video[src], video source[src]").EachWithBreak(func(i int, el *goquery.Selection) bool {
+			v.StreamURL = abs(strings.TrimSpace(el.AttrOr("src", "")))
+			return v.StreamURL == ""
+		})
+	}
+	s.Find("a[href]").EachWithBreak(func(i int, a *goquery.Selection) bool {
+		href := strings.TrimSpace(a.AttrOr("href", ""))
+		if _, dl := a.Attr("download"); dl || isVideo(href) {
+			v.DownloadURL = abs(href)
+			return false
+		}
+		return true
+	})
+	p.Video = v
+}
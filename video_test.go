@@ -0,0 +1,106 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// galleryItem parses one gallery item of the album page markup.
+func galleryItem(t *testing.T, html string) *goquery.Selection {
+	t.Helper()
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc.Find(".gallery-item").First()
+}
+
+func TestItemType(t *testing.T) {
+	tests := []struct {
+		html string
+		want string
+	}{
+		{`<div class="gallery-item" data-id="1"></div>`, "photo"},
+		{`<div class="gallery-item" data-type="Video"></div>`, "video"},
+		{`<div class="gallery-item"><video></video></div>`, "video"},
+		{`<div class="gallery-item" data-video=""></div>`, "video"},
+		{`<div class="gallery-item" data-duration="12"></div>`, "video"},
+	}
+	for _, tt := range tests {
+		if got := itemType(galleryItem(t, tt.html)); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.html, got, tt.want)
+		}
+	}
+	// The saved gallery only has photos
+	items, _ := firstMatch(currentSelectors().Album.Photos, loadFixture(t, "snippet3.html").Selection)
+	items.Each(func(i int, s *goquery.Selection) {
+		if typ := itemType(s); typ != "photo" {
+			t.Errorf("snippet3.html item %d: %s", i, typ)
+		}
+	})
+}
+
+func TestParseDuration(t *testing.T) {
+	for in, want := range map[string]int{"75": 75, "1:15": 75, " 1:02:03 ": 3723, "0:07.5": 7, "": 0, "1:xx": 0} {
+		if got := parseDuration(in); got != want {
+			t.Errorf("%q: got %d, want %d", in, got, want)
+		}
+	}
+}
+
+func TestVideoExts(t *testing.T) {
+	exts := videoExts(loadFixture(t, "albums.html"))
+	for _, e := range []string{"mp4", "wmv", "m3u8", "mpd"} {
+		if !exts[e] {
+			t.Errorf("albums.html: %s is not a video extension", e)
+		}
+	}
+	if exts["jpg"] {
+		t.Error("albums.html: jpg is a video extension")
+	}
+	// Without the meta tag the defaults apply
+	if exts := videoExts(loadFixture(t, "snippet3.html")); !exts["webm"] {
+		t.Error("default extensions miss webm")
+	}
+}
+
+func TestApplyVideoItem(t *testing.T) {
+	exts := extSet(defaultVideoExts)
+	join := func(u string) string { return "https://eu.zonerama.com" + u }
+	tests := []struct {
+		html string
+		want Video
+	}{
+		{`<div class="gallery-item" data-duration="1:15" data-video="/Video/1/stream.m3u8"><a href="/Video/1/download" download>x</a></div>`,
+			Video{Poster: "poster", Duration: 75, StreamURL: "https://eu.zonerama.com/Video/1/stream.m3u8", DownloadURL: "https://eu.zonerama.com/Video/1/download"}},
+		// data-src is a video only with a video extension
+		{`<div class="gallery-item" data-src="/photos/1.jpg"><video><source src="https://cdn.example/v.mp4"></video><span class="duration">0:42</span></div>`,
+			Video{Poster: "poster", Duration: 42, StreamURL: "https://cdn.example/v.mp4"}},
+		{`<div class="gallery-item" data-src="/v/1.MP4?x=1"><a href="/Photo/1">photo</a><a href="/v/1.mov">mov</a></div>`,
+			Video{Poster: "poster", StreamURL: "https://eu.zonerama.com/v/1.MP4?x=1", DownloadURL: "https://eu.zonerama.com/v/1.mov"}},
+		{`<div class="gallery-item" data-type="video"></div>`, Video{Poster: "poster"}},
+	}
+	for _, tt := range tests {
+		p := Photo{Image1500: "poster"}
+		applyVideoItem(galleryItem(t, tt.html), &p, exts, join)
+		if p.Video == nil || *p.Video != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.html, p.Video, tt.want)
+		}
+	}
+}
+
+func TestMediaParam(t *testing.T) {
+	for in, want := range map[string]string{"": mediaAll, " Videos ": mediaVideos, "photos": mediaPhotos} {
+		if got, err := parseMediaParam(in); err != nil || got != want {
+			t.Errorf("%q: got %q, %v", in, got, err)
+		}
+	}
+	if _, err := parseMediaParam("gifs"); err == nil {
+		t.Error("media=gifs was accepted")
+	}
+	if !mediaAllowed(mediaAll, "video") || mediaAllowed(mediaPhotos, "video") || mediaAllowed(mediaVideos, "photo") {
+		t.Error("mediaAllowed does not follow media=")
+	}
+}
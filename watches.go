@@ -0,0 +1,428 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	mrand "math/rand/v2"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Watches re-scrape a link on a schedule. They live in the catalog database so
+// they survive restarts; every run calls scrapeProfile directly, so its result
+// is also upserted into the catalog like any other scrape.
+type Watch struct {
+	ID         string            `json:"id"`
+	Link       string            `json:"link"`
+	Interval   string            `json:"interval"`         // Go duration, e.g. "6h"
+	Params     map[string]string `json:"params,omitempty"` // /zonerama query parameters
+	CreatedAt  string            `json:"created_at"`
+	NextRun    string            `json:"next_run"`
+	LastRun    string            `json:"last_run,omitempty"`
+	LastStatus string            `json:"last_status,omitempty"` // ok or error
+	LastError  string            `json:"last_error,omitempty"`
+	Runs       int               `json:"runs"`
+	// The latest successful /zonerama response; only on GET /watches/{id}
+	LastResult json.RawMessage `json:"last_result,omitempty"`
+
+	interval time.Duration
+}
+
+// Scheduler settings. Jitter spreads runs so watches created together do not
+// keep firing together.
+var (
+	watchMinInterval = envMillis("ZONERAMA_WATCH_MIN_INTERVAL_MS", 15*time.Minute)
+	watchTick        = envMillis("ZONERAMA_WATCH_TICK_MS", 30*time.Second)
+	watchConcurrency = max(1, envInt("ZONERAMA_WATCH_CONCURRENCY", 1))
+	// Retry delay when no crawl slot was free
+	watchBusyRetry = time.Minute
+)
+
+// Parameters a watch may pass on to /zonerama. Paging, fields and debug make no
+// sense for a recurring snapshot.
+var watchParams = map[string]bool{
+	"album_limit": true, "photo_limit": true, "since": true, "until": true, "title": true,
+	"min_photos": true, "exclude": true, "sort": true, "include_photos": true, "media": true,
+	"sizes": true, "likes": true, "likers": true, "exif": true, "exif_jpeg": true, "mode": true,
+	"rendered": true, "rps": true, "delay_ms": true, "robots": true, "export": true,
+	"dupes": true, "dupe_distance": true,
+}
+
+// watchJitter is a random delay of up to a tenth of the interval, capped at 10 minutes.
+func watchJitter(interval time.Duration) time.Duration {
+	span := min(interval/10, 10*time.Minute)
+	if span <= 0 {
+		return 0
+	}
+	return mrand.N(span)
+}
+
+// watchQuery validates a watch's link and params and builds the /zonerama query.
+func watchQuery(link string, params map[string]string) (url.Values, error) {
+	if _, err := resolveZoneramaLink(link); err != nil {
+		return nil, err
+	}
+	q := url.Values{}
+	for k, v := range params {
+		if !watchParams[k] {
+			return nil, fmt.Errorf("param %q is not supported for watches", k)
+		}
+		q.Set(k, v)
+	}
+	q.Set("link", link)
+	if _, err := albumFilterFromRequest(q); err != nil {
+		return nil, err
+	}
+	if _, err := parseModeParam(q.Get("mode")); err != nil {
+		return nil, err
+	}
+	if _, err := parseMediaParam(q.Get("media")); err != nil {
+		return nil, err
+	}
+	if _, err := parseSizesParam(q.Get("sizes")); err != nil {
+		return nil, err
+	}
+	if _, err := parseExportParam(q.Get("export")); err != nil {
+		return nil, err
+	}
+	if _, err := parseDupeDistance(q.Get("dupe_distance")); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+func newWatchID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (c *catalogStore) createWatch(w *Watch) error {
+	params, err := json.Marshal(w.Params)
+	if err != nil {
+		return err
+	}
+	_, err = c.db.Exec(`INSERT INTO watches (id, link, params, interval_seconds, created_at, next_run) VALUES (?, ?, ?, ?, ?, ?)`,
+		w.ID, w.Link, string(params), int64(w.interval/time.Second), w.CreatedAt, w.NextRun)
+	return err
+}
+
+const watchColumns = `id, link, params, interval_seconds, created_at, next_run, last_run, last_status, last_error, runs`
+
+func scanWatch(row interface{ Scan(...any) error }, extra ...any) (*Watch, error) {
+	var w Watch
+	var params string
+	var seconds int64
+	dest := append([]any{&w.ID, &w.Link, &params, &seconds, &w.CreatedAt, &w.NextRun, &w.LastRun, &w.LastStatus, &w.LastError, &w.Runs}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	_ = json.Unmarshal([]byte(params), &w.Params)
+	w.interval = time.Duration(seconds) * time.Second
+	w.Interval = w.interval.String()
+	return &w, nil
+}
+
+// watch loads one watch with its latest result; nil when it does not exist.
+func (c *catalogStore) watch(id string) (*Watch, error) {
+	var result string
+	w, err := scanWatch(c.db.QueryRow(`SELECT `+watchColumns+`, COALESCE(last_result, '') FROM watches WHERE id = ?`, id), &result)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if result != "" {
+		w.LastResult = json.RawMessage(result)
+	}
+	return w, nil
+}
+
+// watches lists watches without their results; due=true keeps those whose next run has come.
+func (c *catalogStore) watches(due bool) ([]*Watch, error) {
+	q := `SELECT ` + watchColumns + ` FROM watches`
+	var args []any
+	if due {
+		q += ` WHERE next_run <= ?`
+		args = append(args, time.Now().UTC().Format(time.RFC3339))
+	}
+	rows, err := c.db.Query(q+` ORDER BY next_run`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []*Watch{}
+	for rows.Next() {
+		w, err := scanWatch(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, w)
+	}
+	return out, rows.Err()
+}
+
+func (c *catalogStore) deleteWatch(id string) (bool, error) {
+	res, err := c.db.Exec(`DELETE FROM watches WHERE id = ?`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// finishWatch records a run. A nil result keeps the previous one, so a failed
+// run never hides the last good snapshot.
+func (c *catalogStore) finishWatch(id string, ran, next time.Time, status, msg string, result []byte) error {
+	var res any
+	if result != nil {
+		res = string(result)
+	}
+	_, err := c.db.Exec(`UPDATE watches SET last_run = ?, next_run = ?, last_status = ?, last_error = ?,
+			last_result = COALESCE(?, last_result), runs = runs + 1
+		WHERE id = ?`,
+		ran.UTC().Format(time.RFC3339), next.UTC().Format(time.RFC3339), status, msg, res, id)
+	return err
+}
+
+// postponeWatch moves a run that could not get a crawl slot.
+func (c *catalogStore) postponeWatch(id string, next time.Time) error {
+	_, err := c.db.Exec(`UPDATE watches SET next_run = ? WHERE id = ?`, next.UTC().Format(time.RFC3339), id)
+	return err
+}
+
+// watchScheduler polls for due watches and runs them in the background, at most
+// watchConcurrency at a time and each holding a crawl slot like a client request.
+type watchScheduler struct {
+	store   *catalogStore
+	slots   chan struct{}
+	running map[string]bool
+	done    chan string
+	wake    chan struct{}
+}
+
+var watchRunner *watchScheduler
+
+func startWatchScheduler(store *catalogStore) *watchScheduler {
+	s := &watchScheduler{
+		store:   store,
+		slots:   make(chan struct{}, watchConcurrency),
+		running: make(map[string]bool),
+		done:    make(chan string),
+		wake:    make(chan struct{}, 1),
+	}
+	go s.loop()
+	return s
+}
+
+// poke makes the scheduler look for due watches now instead of at the next tick.
+func (s *watchScheduler) poke() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *watchScheduler) loop() {
+	ticker := time.NewTicker(watchTick)
+	defer ticker.Stop()
+	for {
+		due, err := s.store.watches(true)
+		if err != nil {
+			log.Printf("watches: listing due watches: %v", err)
+		}
+		for _, w := range due {
+			if s.running[w.ID] {
+				continue
+			}
+			s.running[w.ID] = true
+			go s.run(w)
+		}
+		select {
+		case <-ticker.C:
+		case <-s.wake:
+		case id := <-s.done:
+			delete(s.running, id)
+		}
+	}
+}
+
+func (s *watchScheduler) run(w *Watch) {
+	defer func() { s.done <- w.ID }()
+	s.slots <- struct{}{}
+	defer func() { <-s.slots }()
+
+	release, ok := crawlSlots.acquire(context.Background())
+	if !ok {
+		if err := s.store.postponeWatch(w.ID, time.Now().Add(watchBusyRetry+mrand.N(watchBusyRetry))); err != nil {
+			log.Printf("watches: %s: %v", w.ID, err)
+		}
+		return
+	}
+	status, msg, result, changes := runWatchScrape(s.store, w)
+	release()
+	next := time.Now().Add(w.interval + watchJitter(w.interval))
+	if status != "ok" {
+		log.Printf("watches: %s (%s): %s", w.ID, w.Link, msg)
+	}
+	if err := s.store.finishWatch(w.ID, time.Now(), next, status, msg, result); err != nil {
+		log.Printf("watches: %s: %v", w.ID, err)
+	}
+	notifyWatchRun(w, status, msg, changes)
+}
+
+// runWatchScrape runs the watch's /zonerama scrape and returns the response
+// body on success, with the changes against what the catalog held
+// before. changes is nil when the catalog had nothing yet: the first run is the baseline.
+func runWatchScrape(store *catalogStore, w *Watch) (status, msg string, result []byte, changes *Changeset) {
+	q, err := watchQuery(w.Link, w.Params)
+	if err != nil {
+		return "error", err.Error(), nil, nil
+	}
+	canon, _ := resolveZoneramaLink(w.Link)
+	prev, since, err := store.snapshot(canon)
+	if err != nil {
+		return "error", "reading previous snapshot: " + err.Error(), nil, nil
+	}
+	cur, err := scrapeProfile(context.Background(), q, true)
+	if err != nil {
+		return "error", "scrape failed: " + err.Error(), nil, nil
+	}
+	if result, err = json.MarshalIndent(cur, "", "  "); err != nil {
+		return "error", err.Error(), nil, nil
+	}
+	if since != "" {
+		completeAlbums, completePhotos := scrapeIsComplete(canon, q, cur)
+		changes = diffResponses(prev, cur, completeAlbums, completePhotos)
+		changes.Link, changes.Since = canon.URL, since
+	}
+	return "ok", "", result, changes
+}
+
+// watchRequest is the body of POST /watches.
+type watchRequest struct {
+	Link     string            `json:"link"`
+	Interval string            `json:"interval"`
+	Params   map[string]string `json:"params"`
+}
+
+// watchesHandler serves POST/GET /watches and GET/DELETE /watches/{id}.
+func watchesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if catalog == nil || watchRunner == nil {
+		writeJSONError(w, http.StatusNotFound, "watches need the catalog; set ZONERAMA_CATALOG to a database path")
+		return
+	}
+	if _, err := parseFields(r.URL.Query().Get("fields")); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/watches"), "/")
+	switch {
+	case id == "" && r.Method == http.MethodPost:
+		createWatchHandler(w, r)
+	case id == "" && r.Method == http.MethodGet:
+		list, err := catalog.watches(false)
+		if err != nil {
+			log.Printf("watches: listing: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "catalog query failed")
+			return
+		}
+		sort.SliceStable(list, func(i, j int) bool { return list[i].CreatedAt < list[j].CreatedAt })
+		writeJSON(w, r, map[string]any{"watches": list})
+	case id != "" && r.Method == http.MethodGet:
+		wt, err := catalog.watch(id)
+		if err != nil {
+			log.Printf("watches: loading %s: %v", id, err)
+			writeJSONError(w, http.StatusInternalServerError, "catalog query failed")
+			return
+		}
+		if wt == nil {
+			writeJSONError(w, http.StatusNotFound, "no such watch")
+			return
+		}
+		writeJSON(w, r, wt)
+	case id != "" && r.Method == http.MethodDelete:
+		ok, err := catalog.deleteWatch(id)
+		if err != nil {
+			log.Printf("watches: deleting %s: %v", id, err)
+			writeJSONError(w, http.StatusInternalServerError, "catalog query failed")
+			return
+		}
+		if !ok {
+			writeJSONError(w, http.StatusNotFound, "no such watch")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		writeJSONError(w, http.StatusMethodNotAllowed, "use POST /watches, GET /watches, GET or DELETE /watches/{id}")
+	}
+}
+
+func createWatchHandler(w http.ResponseWriter, r *http.Request) {
+	var req watchRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+	interval, err := time.ParseDuration(req.Interval)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, `invalid interval, use a duration such as "30m" or "6h"`)
+		return
+	}
+	if interval < watchMinInterval {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("interval must be at least %s", watchMinInterval))
+		return
+	}
+	if req.Params == nil {
+		req.Params = map[string]string{}
+	}
+	// The key's limits apply to its watches just as to its requests
+	if k, ok := r.Context().Value(apiKeyCtxKey{}).(apiKey); ok {
+		q := url.Values{}
+		for name, v := range req.Params {
+			q.Set(name, v)
+		}
+		clampLimit(q, "album_limit", 5, k.MaxAlbumLimit)
+		clampLimit(q, "photo_limit", 10, k.MaxPhotoLimit)
+		for name := range q {
+			req.Params[name] = q.Get(name)
+		}
+	}
+	if _, err := watchQuery(req.Link, req.Params); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	now := time.Now().UTC()
+	wt := &Watch{
+		ID:        newWatchID(),
+		Link:      req.Link,
+		Params:    req.Params,
+		CreatedAt: now.Format(time.RFC3339),
+		// The first run is soon, jittered like every later one
+		NextRun:  now.Add(watchJitter(min(interval, 10*time.Minute))).Format(time.RFC3339),
+		interval: interval,
+	}
+	wt.Interval = interval.String()
+	if err := catalog.createWatch(wt); err != nil {
+		log.Printf("watches: creating: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "catalog write failed")
+		return
+	}
+	watchRunner.poke()
+	w.Header().Set("Location", "/watches/"+wt.ID)
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, r, wt)
+}
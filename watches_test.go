@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatchQuery(t *testing.T) {
+	link := "https://eu.zonerama.com/FKKofolaKrnov/1470757"
+	q, err := watchQuery(link, map[string]string{"album_limit": "0", "include_photos": "false", "mode": "incremental"})
+	if err != nil || q.Get("link") != link || q.Get("mode") != "incremental" {
+		t.Fatalf("got %v, %v", q, err)
+	}
+	tests := []struct {
+		link   string
+		params map[string]string
+		err    string
+	}{
+		{"https://example.com/", nil, "zonerama.com"},
+		{link, map[string]string{"cursor": "x"}, `param "cursor" is not supported`},
+		{link, map[string]string{"since": "someday"}, "invalid since"},
+		{link, map[string]string{"mode": "delta"}, "invalid mode"},
+		{link, map[string]string{"media": "gifs"}, "invalid media"},
+		{link, map[string]string{"sizes": "0"}, "invalid size"},
+	}
+	for _, tt := range tests {
+		if _, err := watchQuery(tt.link, tt.params); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s %v: got %v, want %q", tt.link, tt.params, err, tt.err)
+		}
+	}
+}
+
+func TestWatchJitter(t *testing.T) {
+	for _, interval := range []time.Duration{0, time.Minute, 6 * time.Hour} {
+		span := min(interval/10, 10*time.Minute)
+		for range 50 {
+			if j := watchJitter(interval); j < 0 || (span > 0 && j >= span) || (span == 0 && j != 0) {
+				t.Fatalf("watchJitter(%s) = %s", interval, j)
+			}
+		}
+	}
+}
+
+func TestWatchStore(t *testing.T) {
+	c := testCatalog(t)
+	now := time.Now().UTC()
+	due := &Watch{ID: "due", Link: "https://eu.zonerama.com/FKKofolaKrnov", Params: map[string]string{"album_limit": "3"},
+		CreatedAt: now.Format(time.RFC3339), NextRun: now.Add(-time.Minute).Format(time.RFC3339), interval: time.Hour}
+	later := &Watch{ID: "later", Link: "https://eu.zonerama.com/FKKofolaKrnov", CreatedAt: now.Format(time.RFC3339),
+		NextRun: now.Add(time.Hour).Format(time.RFC3339), interval: 2 * time.Hour}
+	for _, w := range []*Watch{due, later} {
+		if err := c.createWatch(w); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list, err := c.watches(true)
+	if err != nil || len(list) != 1 || list[0].ID != "due" || list[0].Interval != "1h0m0s" || list[0].Params["album_limit"] != "3" {
+		t.Fatalf("due watches: %+v, %v", list, err)
+	}
+
+	if err := c.finishWatch("due", now, now.Add(time.Hour), "ok", "", []byte(`{"albums":[]}`)); err != nil {
+		t.Fatal(err)
+	}
+	// A failed run keeps the last good result
+	if err := c.finishWatch("due", now, now.Add(time.Hour), "error", "scrape failed", nil); err != nil {
+		t.Fatal(err)
+	}
+	w, err := c.watch("due")
+	if err != nil || w.Runs != 2 || w.LastStatus != "error" || w.LastError != "scrape failed" || string(w.LastResult) != `{"albums":[]}` {
+		t.Fatalf("after two runs: %+v, %v", w, err)
+	}
+	if list, _ := c.watches(true); len(list) != 0 {
+		t.Errorf("%d watches still due after their run", len(list))
+	}
+
+	if ok, err := c.deleteWatch("later"); !ok || err != nil {
+		t.Fatalf("delete: %v, %v", ok, err)
+	}
+	if ok, _ := c.deleteWatch("later"); ok {
+		t.Error("deleted a watch twice")
+	}
+	if w, err := c.watch("later"); w != nil || err != nil {
+		t.Errorf("deleted watch: %+v, %v", w, err)
+	}
+}
+
+func TestWatchesHandler(t *testing.T) {
+	oldCatalog, oldRunner := catalog, watchRunner
+	catalog = testCatalog(t)
+	watchRunner = &watchScheduler{wake: make(chan struct{}, 1)}
+	t.Cleanup(func() { catalog, watchRunner = oldCatalog, oldRunner })
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		watchesHandler(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+	if w := do("POST", "/watches", `{"link":"https://eu.zonerama.com/FKKofolaKrnov","interval":"1m"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("short interval: got %d", w.Code)
+	}
+	if w := do("POST", "/watches", `{"link":"https://eu.zonerama.com/FKKofolaKrnov","interval":"6h","params":{"debug":"1"}}`); w.Code != http.StatusBadRequest {
+		t.Errorf("unsupported param: got %d", w.Code)
+	}
+	w := do("POST", "/watches", `{"link":"https://eu.zonerama.com/FKKofolaKrnov","interval":"6h","params":{"album_limit":"2"}}`)
+	var created Watch
+	if w.Code != http.StatusCreated || json.Unmarshal(w.Body.Bytes(), &created) != nil || w.Header().Get("Location") != "/watches/"+created.ID {
+		t.Fatalf("create: %d %s", w.Code, w.Body.String())
+	}
+	if len(watchRunner.wake) != 1 {
+		t.Error("creating a watch did not wake the scheduler")
+	}
+
+	var list struct{ Watches []Watch }
+	if w := do("GET", "/watches", ""); json.Unmarshal(w.Body.Bytes(), &list) != nil || len(list.Watches) != 1 || list.Watches[0].ID != created.ID {
+		t.Fatalf("list: %s", w.Body.String())
+	}
+	if w := do("GET", "/watches/"+created.ID, ""); w.Code != http.StatusOK {
+		t.Errorf("get: %d", w.Code)
+	}
+	if w := do("PUT", "/watches/"+created.ID, ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("put: %d", w.Code)
+	}
+	if w := do("DELETE", "/watches/"+created.ID, ""); w.Code != http.StatusNoContent {
+		t.Errorf("delete: %d", w.Code)
+	}
+	if w := do("GET", "/watches/"+created.ID, ""); w.Code != http.StatusNotFound {
+		t.Errorf("get after delete: %d", w.Code)
+	}
+}
+
+func TestRunWatchScrape(t *testing.T) {
+	ft := &fixtureTransport{page: "clean.html", served: make(chan struct{})}
+	crawlTransport = ft
+	oldCatalog := catalog
+	catalog = testCatalog(t)
+	t.Cleanup(func() { crawlTransport, catalog = nil, oldCatalog })
+
+	w := &Watch{ID: "w", Link: "https://eu.zonerama.com/FKKofolaKrnov/1470757",
+		Params: map[string]string{"album_limit": "0", "include_photos": "false", "rendered": "false"}}
+	status, msg, result, changes := runWatchScrape(catalog, w)
+	if status != "ok" || len(result) == 0 {
+		t.Fatalf("first run: %s %s", status, msg)
+	}
+	// The first run is the baseline
+	if changes != nil {
+		t.Fatalf("first run reported changes: %+v", changes.Summary)
+	}
+	ft.page = "snippet1.html"
+	status, msg, _, changes = runWatchScrape(catalog, w)
+	if status != "ok" || changes == nil {
+		t.Fatalf("second run: %s %s", status, msg)
+	}
+	if changes.Summary.AddedAlbums != 4 || changes.Summary.RemovedAlbums != 0 || changes.Link != "https://eu.zonerama.com/FKKofolaKrnov/1470757" {
+		t.Errorf("second run: %+v", changes.Summary)
+	}
+}
@@ -0,0 +1,585 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	mrand "math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Webhook events.
+const (
+	eventAlbumNew       = "album.new"       // a watch run found albums the catalog did not have
+	eventWatchCompleted = "watch.completed" // a watch run finished, successfully or not
+	eventPing           = "ping"            // POST /webhooks/{id}/test
+)
+
+var webhookEvents = map[string]bool{eventAlbumNew: true, eventWatchCompleted: true}
+
+// Delivery settings. A delivery is retried with exponential backoff until it
+// gets a 2xx answer or runs out of attempts; every attempt updates its log row.
+var (
+	webhookRetries    = envInt("ZONERAMA_WEBHOOK_RETRIES", 5)
+	webhookBackoff    = envMillis("ZONERAMA_WEBHOOK_BACKOFF_MS", 2*time.Second)
+	webhookMaxBackoff = envMillis("ZONERAMA_WEBHOOK_MAX_BACKOFF_MS", 5*time.Minute)
+	webhookKeepLog    = envInt("ZONERAMA_WEBHOOK_LOG_SIZE", 500) // deliveries kept per webhook
+	// Prefix for result_url in payloads, e.g. https://scraper.example.com; relative when unset
+	publicURL = strings.TrimSuffix(envString("ZONERAMA_PUBLIC_URL", ""), "/")
+
+	// Loopback, private and link-local receivers are refused unless allowed, so that
+	// webhooks cannot be used to probe the server's own network
+	webhookAllowPrivate = envBool("ZONERAMA_WEBHOOK_ALLOW_PRIVATE", false)
+
+	webhookClient = &http.Client{
+		Timeout: envMillis("ZONERAMA_WEBHOOK_TIMEOUT_MS", 10*time.Second),
+		// A receiver answering with a redirect has not accepted the delivery
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		// No proxy: the address check runs on the connection actually dialed, which
+		// also catches names that resolve differently after the webhook was created
+		Transport: &http.Transport{
+			DialContext:         (&net.Dialer{Timeout: 10 * time.Second, Control: checkWebhookDial}).DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+	}
+)
+
+var errPrivateWebhook = errors.New("webhook receiver is a loopback, private or link-local address; set ZONERAMA_WEBHOOK_ALLOW_PRIVATE=true to allow it")
+
+// privateWebhookIP reports whether ip is off limits for receivers.
+func privateWebhookIP(ip net.IP) bool {
+	return ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast()
+}
+
+func checkWebhookDial(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if !webhookAllowPrivate && privateWebhookIP(net.ParseIP(host)) {
+		return errPrivateWebhook
+	}
+	return nil
+}
+
+// checkWebhookHost resolves a receiver's host when the webhook is created, so
+// an internal target is refused up front rather than on every delivery.
+func checkWebhookHost(ctx context.Context, host string) error {
+	if webhookAllowPrivate {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if privateWebhookIP(ip) {
+			return errPrivateWebhook
+		}
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("resolving webhook host %q: %w", host, err)
+	}
+	for _, a := range addrs {
+		if privateWebhookIP(a.IP) {
+			return errPrivateWebhook
+		}
+	}
+	return nil
+}
+
+type Webhook struct {
+	ID        string   `json:"id"`
+	URL       string   `json:"url"`
+	Events    []string `json:"events"`
+	WatchID   string   `json:"watch_id,omitempty"`
+	Secret    string   `json:"secret,omitempty"` // only returned when the webhook is created
+	CreatedAt string   `json:"created_at"`
+	Owner     string   `json:"-"` // webhookOwner of the creating API key; empty without auth
+}
+
+// WebhookDelivery is one row of the delivery log.
+type WebhookDelivery struct {
+	ID         string          `json:"id"`
+	WebhookID  string          `json:"webhook_id"`
+	Event      string          `json:"event"`
+	Attempts   int             `json:"attempts"`
+	StatusCode int             `json:"status_code,omitempty"` // of the last attempt
+	Error      string          `json:"error,omitempty"`
+	Delivered  bool            `json:"delivered"`
+	CreatedAt  string          `json:"created_at"`
+	UpdatedAt  string          `json:"updated_at"`
+	Payload    json.RawMessage `json:"payload"`
+}
+
+// webhookPayload is the JSON body POSTed to receivers.
+type webhookPayload struct {
+	Event      string         `json:"event"`
+	DeliveryID string         `json:"delivery_id"`
+	CreatedAt  string         `json:"created_at"`
+	Watch      *webhookWatch  `json:"watch,omitempty"`
+	Status     string         `json:"status,omitempty"` // watch.completed: ok or error
+	Error      string         `json:"error,omitempty"`
+	ResultURL  string         `json:"result_url,omitempty"`
+	Summary    *ChangeSummary `json:"summary,omitempty"`
+	Albums     []AlbumSummary `json:"albums,omitempty"` // album.new: the new albums
+	Changes    *Changeset     `json:"changes,omitempty"`
+}
+
+type webhookWatch struct {
+	ID   string `json:"id"`
+	Link string `json:"link"`
+}
+
+// signWebhook is the X-Zonerama-Signature value: HMAC-SHA256 over "<timestamp>.<body>".
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookDelay is the wait before retry n (1-based): doubling from webhookBackoff with up to 50% jitter.
+func webhookDelay(n int) time.Duration {
+	d := webhookBackoff << min(n-1, 20)
+	if d <= 0 || d > webhookMaxBackoff {
+		d = webhookMaxBackoff
+	}
+	if half := d / 2; half > 0 {
+		d = half + mrand.N(half)
+	}
+	return d
+}
+
+// webhookOwner identifies the API key of r by its SHA-256, so the key itself is
+// not stored. It is empty when auth is disabled.
+func webhookOwner(r *http.Request) string {
+	k, ok := r.Context().Value(apiKeyCtxKey{}).(apiKey)
+	if !ok {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(k.Key))
+	return hex.EncodeToString(sum[:])
+}
+
+// visibleTo reports whether owner may see and manage h. Webhooks created without
+// auth have no owner and stay visible to every key.
+func (h *Webhook) visibleTo(owner string) bool {
+	return owner == "" || h.Owner == "" || h.Owner == owner
+}
+
+func (c *catalogStore) createWebhook(h *Webhook) error {
+	_, err := c.db.Exec(`INSERT INTO webhooks (id, url, secret, events, watch_id, created_at, owner) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		h.ID, h.URL, h.Secret, strings.Join(h.Events, ","), h.WatchID, h.CreatedAt, h.Owner)
+	return err
+}
+
+func scanWebhook(row interface{ Scan(...any) error }) (*Webhook, error) {
+	var h Webhook
+	var events string
+	if err := row.Scan(&h.ID, &h.URL, &h.Secret, &events, &h.WatchID, &h.CreatedAt, &h.Owner); err != nil {
+		return nil, err
+	}
+	h.Events = strings.Split(events, ",")
+	return &h, nil
+}
+
+// webhooks lists webhooks, with secrets, optionally only those subscribed to event for watchID
+// and only those visible to owner.
+func (c *catalogStore) webhooks(event, watchID, owner string) ([]*Webhook, error) {
+	var (
+		where []string
+		args  []any
+	)
+	if event != "" {
+		where = append(where, `(',' || events || ',') LIKE ? AND (watch_id = '' OR watch_id = ?)`)
+		args = append(args, "%,"+event+",%", watchID)
+	}
+	if owner != "" {
+		where = append(where, `(owner = '' OR owner = ?)`)
+		args = append(args, owner)
+	}
+	q := `SELECT id, url, secret, events, watch_id, created_at, owner FROM webhooks`
+	if len(where) > 0 {
+		q += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+	rows, err := c.db.Query(q+` ORDER BY created_at, id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []*Webhook{}
+	for rows.Next() {
+		h, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, h)
+	}
+	return out, rows.Err()
+}
+
+// webhook loads one webhook with its secret; nil when it does not exist.
+func (c *catalogStore) webhook(id string) (*Webhook, error) {
+	h, err := scanWebhook(c.db.QueryRow(`SELECT id, url, secret, events, watch_id, created_at, owner FROM webhooks WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return h, err
+}
+
+// deleteWebhook removes a webhook and its delivery log.
+func (c *catalogStore) deleteWebhook(id string) (bool, error) {
+	res, err := c.db.Exec(`DELETE FROM webhooks WHERE id = ?`, id)
+	if err != nil {
+		return false, err
+	}
+	if _, err := c.db.Exec(`DELETE FROM webhook_deliveries WHERE webhook_id = ?`, id); err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// logDelivery adds a delivery to the log and trims the log to webhookKeepLog rows.
+func (c *catalogStore) logDelivery(d *WebhookDelivery) error {
+	if _, err := c.db.Exec(`INSERT INTO webhook_deliveries (id, webhook_id, event, payload, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		d.ID, d.WebhookID, d.Event, string(d.Payload), d.CreatedAt, d.CreatedAt); err != nil {
+		return err
+	}
+	_, err := c.db.Exec(`DELETE FROM webhook_deliveries WHERE webhook_id = ? AND id NOT IN (
+			SELECT id FROM webhook_deliveries WHERE webhook_id = ? ORDER BY created_at DESC, id DESC LIMIT ?)`,
+		d.WebhookID, d.WebhookID, webhookKeepLog)
+	return err
+}
+
+func (c *catalogStore) updateDelivery(d *WebhookDelivery) error {
+	_, err := c.db.Exec(`UPDATE webhook_deliveries SET attempts = ?, status_code = ?, error = ?, delivered = ?, updated_at = ? WHERE id = ?`,
+		d.Attempts, d.StatusCode, d.Error, d.Delivered, d.UpdatedAt, d.ID)
+	return err
+}
+
+// deliveries lists a webhook's log, newest first.
+func (c *catalogStore) deliveries(webhookID string, limit, offset int) ([]WebhookDelivery, error) {
+	rows, err := c.db.Query(`SELECT id, webhook_id, event, attempts, status_code, error, delivered, created_at, updated_at, payload
+		FROM webhook_deliveries WHERE webhook_id = ? ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?`, webhookID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []WebhookDelivery{}
+	for rows.Next() {
+		var d WebhookDelivery
+		var payload string
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.Event, &d.Attempts, &d.StatusCode, &d.Error, &d.Delivered, &d.CreatedAt, &d.UpdatedAt, &payload); err != nil {
+			return nil, err
+		}
+		d.Payload = json.RawMessage(payload)
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// resumeDeliveries restarts the retries of deliveries a previous run left pending.
+// Test pings are sent once by hand and are not resumed.
+func resumeDeliveries(c *catalogStore) {
+	rows, err := c.db.Query(`SELECT w.id, w.url, w.secret, w.events, w.watch_id, w.created_at,
+			d.id, d.event, d.attempts, d.status_code, d.error, d.created_at, d.updated_at, d.payload
+		FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+		WHERE d.delivered = 0 AND d.attempts <= ? AND d.event != ?
+		ORDER BY d.created_at, d.id`, webhookRetries, eventPing)
+	if err != nil {
+		log.Printf("webhooks: listing pending deliveries: %v", err)
+		return
+	}
+	type pending struct {
+		h *Webhook
+		d *WebhookDelivery
+	}
+	var todo []pending
+	for rows.Next() {
+		var h Webhook
+		var events, payload string
+		d := &WebhookDelivery{}
+		if err := rows.Scan(&h.ID, &h.URL, &h.Secret, &events, &h.WatchID, &h.CreatedAt,
+			&d.ID, &d.Event, &d.Attempts, &d.StatusCode, &d.Error, &d.CreatedAt, &d.UpdatedAt, &payload); err != nil {
+			log.Printf("webhooks: reading pending delivery: %v", err)
+			break
+		}
+		h.Events = strings.Split(events, ",")
+		d.WebhookID, d.Payload = h.ID, json.RawMessage(payload)
+		todo = append(todo, pending{&h, d})
+	}
+	rows.Close()
+	if len(todo) > 0 {
+		log.Printf("webhooks: resuming %d pending deliveries", len(todo))
+	}
+	// Rows are closed before deliveries start updating them
+	for _, p := range todo {
+		go deliverWebhook(p.h, p.d)
+	}
+}
+
+// emitWebhook queues p for every webhook subscribed to event (and to watchID, if
+// the webhook is bound to a watch). Deliveries run in the background.
+func emitWebhook(event, watchID string, p webhookPayload) {
+	if catalog == nil {
+		return
+	}
+	hooks, err := catalog.webhooks(event, watchID, "")
+	if err != nil {
+		log.Printf("webhooks: listing for %s: %v", event, err)
+		return
+	}
+	for _, h := range hooks {
+		if d := queueDelivery(h, event, p); d != nil {
+			go deliverWebhook(h, d)
+		}
+	}
+}
+
+// queueDelivery fills in the delivery fields of p, encodes it and logs the delivery.
+func queueDelivery(h *Webhook, event string, p webhookPayload) *WebhookDelivery {
+	now := time.Now().UTC().Format(time.RFC3339)
+	p.Event, p.DeliveryID, p.CreatedAt = event, newWatchID(), now
+	body, err := json.Marshal(p)
+	if err != nil {
+		log.Printf("webhooks: encoding %s: %v", event, err)
+		return nil
+	}
+	d := &WebhookDelivery{ID: p.DeliveryID, WebhookID: h.ID, Event: event, CreatedAt: now, Payload: body}
+	if err := catalog.logDelivery(d); err != nil {
+		log.Printf("webhooks: logging delivery %s: %v", d.ID, err)
+	}
+	return d
+}
+
+// deliverWebhook POSTs a delivery until the receiver accepts it or attempts run out.
+func deliverWebhook(h *Webhook, d *WebhookDelivery) {
+	for {
+		d.Attempts++
+		d.StatusCode, d.Error = 0, ""
+		err := postWebhook(h, d)
+		if err != nil {
+			d.Error = err.Error()
+		} else {
+			d.Delivered = true
+		}
+		d.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+		if err := catalog.updateDelivery(d); err != nil {
+			log.Printf("webhooks: updating delivery %s: %v", d.ID, err)
+		}
+		if d.Delivered {
+			return
+		}
+		if d.Attempts > webhookRetries {
+			log.Printf("webhooks: giving up on %s to %s after %d attempts: %s", d.Event, h.URL, d.Attempts, d.Error)
+			return
+		}
+		time.Sleep(webhookDelay(d.Attempts))
+	}
+}
+
+func postWebhook(h *Webhook, d *WebhookDelivery) error {
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ZoneramaScraper-Webhook/1")
+	req.Header.Set("X-Zonerama-Event", d.Event)
+	req.Header.Set("X-Zonerama-Delivery", d.ID)
+	req.Header.Set("X-Zonerama-Timestamp", ts)
+	req.Header.Set("X-Zonerama-Signature", signWebhook(h.Secret, ts, d.Payload))
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	d.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("receiver answered %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// notifyWatchRun sends album.new when the run found new albums, then watch.completed.
+// changes comes from the catalog snapshot, so each removal is in one run's summary only.
+func notifyWatchRun(w *Watch, status, msg string, changes *Changeset) {
+	base := webhookPayload{Watch: &webhookWatch{ID: w.ID, Link: w.Link}, ResultURL: publicURL + "/watches/" + w.ID}
+	if changes != nil && len(changes.AddedAlbums) > 0 {
+		p := base
+		p.Albums, p.Changes = changes.AddedAlbums, changes
+		emitWebhook(eventAlbumNew, w.ID, p)
+	}
+	p := base
+	p.Status, p.Error = status, msg
+	if changes != nil {
+		p.Summary = &changes.Summary
+	}
+	emitWebhook(eventWatchCompleted, w.ID, p)
+}
+
+// webhookRequest is the body of POST /webhooks.
+type webhookRequest struct {
+	URL     string   `json:"url"`
+	Events  []string `json:"events"`
+	WatchID string   `json:"watch_id"`
+	Secret  string   `json:"secret"`
+}
+
+// webhooksHandler serves POST/GET /webhooks, GET/DELETE /webhooks/{id},
+// GET /webhooks/{id}/deliveries and POST /webhooks/{id}/test.
+func webhooksHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if catalog == nil {
+		writeJSONError(w, http.StatusNotFound, "webhooks need the catalog; set ZONERAMA_CATALOG to a database path")
+		return
+	}
+	if _, err := parseFields(r.URL.Query().Get("fields")); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	id, action, _ := strings.Cut(strings.Trim(strings.TrimPrefix(r.URL.Path, "/webhooks"), "/"), "/")
+	owner := webhookOwner(r)
+	var hook *Webhook
+	if id != "" {
+		var err error
+		if hook, err = catalog.webhook(id); err != nil {
+			log.Printf("webhooks: loading %s: %v", id, err)
+			writeJSONError(w, http.StatusInternalServerError, "catalog query failed")
+			return
+		}
+		// Another key's webhook looks just like a missing one
+		if hook == nil || !hook.visibleTo(owner) {
+			writeJSONError(w, http.StatusNotFound, "no such webhook")
+			return
+		}
+	}
+	switch {
+	case id == "" && r.Method == http.MethodPost:
+		createWebhookHandler(w, r)
+	case id == "" && r.Method == http.MethodGet:
+		hooks, err := catalog.webhooks("", "", owner)
+		if err != nil {
+			log.Printf("webhooks: listing: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "catalog query failed")
+			return
+		}
+		for _, h := range hooks {
+			h.Secret = ""
+		}
+		writeJSON(w, r, map[string]any{"webhooks": hooks})
+	case action == "" && r.Method == http.MethodGet:
+		hook.Secret = ""
+		writeJSON(w, r, hook)
+	case action == "" && r.Method == http.MethodDelete:
+		if _, err := catalog.deleteWebhook(id); err != nil {
+			log.Printf("webhooks: deleting %s: %v", id, err)
+			writeJSONError(w, http.StatusInternalServerError, "catalog query failed")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case action == "deliveries" && r.Method == http.MethodGet:
+		limit, offset, err := catalogPage(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		list, err := catalog.deliveries(id, limit, offset)
+		if err != nil {
+			log.Printf("webhooks: deliveries of %s: %v", id, err)
+			writeJSONError(w, http.StatusInternalServerError, "catalog query failed")
+			return
+		}
+		writeJSON(w, r, map[string]any{"deliveries": list})
+	case action == "test" && r.Method == http.MethodPost:
+		// Delivered synchronously (one attempt) so the caller sees the receiver's answer
+		d := queueDelivery(hook, eventPing, webhookPayload{})
+		if d == nil {
+			writeJSONError(w, http.StatusInternalServerError, "encoding ping failed")
+			return
+		}
+		d.Attempts = 1
+		if err := postWebhook(hook, d); err != nil {
+			d.Error = err.Error()
+		} else {
+			d.Delivered = true
+		}
+		d.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+		if err := catalog.updateDelivery(d); err != nil {
+			log.Printf("webhooks: updating delivery %s: %v", d.ID, err)
+		}
+		writeJSON(w, r, d)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		writeJSONError(w, http.StatusMethodNotAllowed, "use POST/GET /webhooks, GET/DELETE /webhooks/{id}, GET /webhooks/{id}/deliveries or POST /webhooks/{id}/test")
+	}
+}
+
+func createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var req webhookRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+	// Any public http(s) receiver; local ones need ZONERAMA_WEBHOOK_ALLOW_PRIVATE
+	u, err := url.Parse(strings.TrimSpace(req.URL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		writeJSONError(w, http.StatusBadRequest, "url must be an absolute http or https URL")
+		return
+	}
+	if err := checkWebhookHost(r.Context(), u.Hostname()); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(req.Events) == 0 {
+		req.Events = []string{eventAlbumNew, eventWatchCompleted}
+	}
+	for _, e := range req.Events {
+		if !webhookEvents[e] {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("unknown event %q, use %s or %s", e, eventAlbumNew, eventWatchCompleted))
+			return
+		}
+	}
+	if req.WatchID != "" {
+		wt, err := catalog.watch(req.WatchID)
+		if err != nil {
+			log.Printf("webhooks: loading watch %s: %v", req.WatchID, err)
+			writeJSONError(w, http.StatusInternalServerError, "catalog query failed")
+			return
+		}
+		if wt == nil {
+			writeJSONError(w, http.StatusBadRequest, "no such watch")
+			return
+		}
+	}
+	if req.Secret == "" {
+		req.Secret = newWatchID() + newWatchID()
+	}
+	h := &Webhook{ID: newWatchID(), URL: u.String(), Events: req.Events, WatchID: req.WatchID, Secret: req.Secret, CreatedAt: time.Now().UTC().Format(time.RFC3339), Owner: webhookOwner(r)}
+	if err := catalog.createWebhook(h); err != nil {
+		log.Printf("webhooks: creating: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "catalog write failed")
+		return
+	}
+	w.Header().Set("Location", "/webhooks/"+h.ID)
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, r, h)
+}
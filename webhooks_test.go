@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSignWebhook(t *testing.T) {
+	body := []byte(`{"event":"ping"}`)
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte("1700000000." + string(body)))
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if got := signWebhook("s3cret", "1700000000", body); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if signWebhook("other", "1700000000", body) == want || signWebhook("s3cret", "1700000001", body) == want {
+		t.Error("signature does not depend on the secret and timestamp")
+	}
+}
+
+func TestWebhookDelay(t *testing.T) {
+	oldBase, oldMax := webhookBackoff, webhookMaxBackoff
+	webhookBackoff, webhookMaxBackoff = 2*time.Second, time.Minute
+	t.Cleanup(func() { webhookBackoff, webhookMaxBackoff = oldBase, oldMax })
+	for n, full := range map[int]time.Duration{1: 2 * time.Second, 3: 8 * time.Second, 10: time.Minute, 80: time.Minute} {
+		for range 20 {
+			if d := webhookDelay(n); d < full/2 || d >= full {
+				t.Fatalf("webhookDelay(%d) = %s, want in [%s, %s)", n, d, full/2, full)
+			}
+		}
+	}
+}
+
+func TestPrivateWebhookIP(t *testing.T) {
+	for _, s := range []string{"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254", "0.0.0.0", "::1", "fe80::1", "fd00::1"} {
+		if !privateWebhookIP(net.ParseIP(s)) {
+			t.Errorf("%s is not private", s)
+		}
+	}
+	for _, s := range []string{"93.184.216.34", "2606:4700::1111"} {
+		if privateWebhookIP(net.ParseIP(s)) {
+			t.Errorf("%s is private", s)
+		}
+	}
+	if err := checkWebhookHost(context.Background(), "10.0.0.1"); err != errPrivateWebhook {
+		t.Errorf("private literal: got %v", err)
+	}
+	if err := checkWebhookHost(context.Background(), "93.184.216.34"); err != nil {
+		t.Errorf("public literal: got %v", err)
+	}
+	if err := checkWebhookDial("tcp", "127.0.0.1:80", nil); err != errPrivateWebhook {
+		t.Errorf("dial to loopback: got %v", err)
+	}
+}
+
+// webhookReceiver records the deliveries it gets and answers with codes in turn.
+type webhookReceiver struct {
+	mu      sync.Mutex
+	codes   []int
+	got     []*http.Request
+	bodies  [][]byte
+	arrived chan struct{}
+}
+
+func (rc *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rc.mu.Lock()
+	code := http.StatusOK
+	if n := len(rc.got); n < len(rc.codes) {
+		code = rc.codes[n]
+	}
+	rc.got = append(rc.got, r)
+	rc.bodies = append(rc.bodies, body)
+	rc.mu.Unlock()
+	w.WriteHeader(code)
+	rc.arrived <- struct{}{}
+}
+
+// webhookTestEnv points the catalog at a fresh database and lets deliveries
+// reach a local receiver quickly.
+func webhookTestEnv(t *testing.T) {
+	oldCatalog, oldPrivate, oldBackoff, oldRetries := catalog, webhookAllowPrivate, webhookBackoff, webhookRetries
+	catalog = testCatalog(t)
+	webhookAllowPrivate, webhookBackoff, webhookRetries = true, time.Millisecond, 2
+	t.Cleanup(func() {
+		catalog, webhookAllowPrivate, webhookBackoff, webhookRetries = oldCatalog, oldPrivate, oldBackoff, oldRetries
+	})
+}
+
+func TestDeliverWebhook(t *testing.T) {
+	webhookTestEnv(t)
+	rc := &webhookReceiver{codes: []int{http.StatusInternalServerError, http.StatusFound}, arrived: make(chan struct{}, 10)}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	h := &Webhook{ID: "h", URL: srv.URL, Events: []string{eventWatchCompleted}, Secret: "s3cret", CreatedAt: time.Now().UTC().Format(time.RFC3339)}
+	if err := catalog.createWebhook(h); err != nil {
+		t.Fatal(err)
+	}
+	d := queueDelivery(h, eventWatchCompleted, webhookPayload{Status: "ok"})
+	deliverWebhook(h, d)
+
+	// A 500 and a redirect are retried; the third attempt is accepted
+	if !d.Delivered || d.Attempts != 3 || d.StatusCode != http.StatusOK {
+		t.Fatalf("delivery: %+v", d)
+	}
+	last := rc.got[2]
+	ts := last.Header.Get("X-Zonerama-Timestamp")
+	if last.Header.Get("X-Zonerama-Signature") != signWebhook("s3cret", ts, rc.bodies[2]) ||
+		last.Header.Get("X-Zonerama-Event") != eventWatchCompleted || last.Header.Get("X-Zonerama-Delivery") != d.ID {
+		t.Errorf("headers: %v", last.Header)
+	}
+	var p webhookPayload
+	if err := json.Unmarshal(rc.bodies[2], &p); err != nil || p.Event != eventWatchCompleted || p.DeliveryID != d.ID || p.Status != "ok" {
+		t.Errorf("payload: %+v, %v", p, err)
+	}
+	log, err := catalog.deliveries("h", 10, 0)
+	if err != nil || len(log) != 1 || !log[0].Delivered || log[0].Attempts != 3 {
+		t.Fatalf("delivery log: %+v, %v", log, err)
+	}
+
+	// A receiver that never accepts is given up on after the retries
+	rc.codes = []int{500, 500, 500, 500, 500, 500, 500}
+	rc.got, rc.bodies = nil, nil
+	d = queueDelivery(h, eventWatchCompleted, webhookPayload{})
+	deliverWebhook(h, d)
+	if d.Delivered || d.Attempts != webhookRetries+1 || d.Error == "" {
+		t.Errorf("failing delivery: %+v", d)
+	}
+}
+
+func TestNotifyWatchRun(t *testing.T) {
+	webhookTestEnv(t)
+	rc := &webhookReceiver{arrived: make(chan struct{}, 10)}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+	now := time.Now().UTC().Format(time.RFC3339)
+	hooks := []*Webhook{
+		{ID: "all", URL: srv.URL, Events: []string{eventAlbumNew, eventWatchCompleted}, CreatedAt: now},
+		{ID: "other-watch", URL: srv.URL, Events: []string{eventAlbumNew, eventWatchCompleted}, WatchID: "w2", CreatedAt: now},
+		{ID: "completed", URL: srv.URL, Events: []string{eventWatchCompleted}, WatchID: "w1", CreatedAt: now},
+	}
+	for _, h := range hooks {
+		if err := catalog.createWebhook(h); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	changes := &Changeset{AddedAlbums: []AlbumSummary{{ID: "13726013"}}, Summary: ChangeSummary{AddedAlbums: 1}}
+	notifyWatchRun(&Watch{ID: "w1", Link: "https://eu.zonerama.com/FKKofolaKrnov"}, "ok", "", changes)
+	// album.new to "all", watch.completed to "all" and "completed"
+	for range 3 {
+		select {
+		case <-rc.arrived:
+		case <-time.After(5 * time.Second):
+			t.Fatal("deliveries did not arrive")
+		}
+	}
+	select {
+	case <-rc.arrived:
+		t.Fatal("a webhook bound to another watch got a delivery")
+	case <-time.After(50 * time.Millisecond):
+	}
+	events := map[string]int{}
+	rc.mu.Lock()
+	for _, r := range rc.got {
+		events[r.Header.Get("X-Zonerama-Event")]++
+	}
+	rc.mu.Unlock()
+	if events[eventAlbumNew] != 1 || events[eventWatchCompleted] != 2 {
+		t.Errorf("events: %v", events)
+	}
+	// Wait for the deliveries to be logged before the catalog goes away
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		all, _ := catalog.deliveries("all", 10, 0)
+		completed, _ := catalog.deliveries("completed", 10, 0)
+		if len(all) == 2 && all[0].Delivered && all[1].Delivered && len(completed) == 1 && completed[0].Delivered {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("delivery log: %+v %+v", all, completed)
+		}
+	}
+}
+
+func TestWebhookOwner(t *testing.T) {
+	webhookTestEnv(t)
+	old := apiKeys
+	apiKeys = &apiKeyStore{usage: map[string]*keyUsage{}, keys: []apiKey{{Key: "key-a"}, {Key: "key-b"}}}
+	t.Cleanup(func() { apiKeys = old })
+	handler := requireAPIKey(webhooksHandler)
+	call := func(method, target, key, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+	list := func(key string) []string {
+		var out struct{ Webhooks []Webhook }
+		json.Unmarshal(call("GET", "/webhooks", key, "").Body.Bytes(), &out)
+		var ids []string
+		for _, h := range out.Webhooks {
+			ids = append(ids, h.ID)
+		}
+		return ids
+	}
+
+	// Created before keys were configured: no owner
+	if err := catalog.createWebhook(&Webhook{ID: "legacy", URL: "http://127.0.0.1/", Events: []string{eventAlbumNew}, Secret: "s", CreatedAt: "2025-01-01T00:00:00Z"}); err != nil {
+		t.Fatal(err)
+	}
+	w := call("POST", "/webhooks", "key-a", `{"url": "http://127.0.0.1/hook"}`)
+	var created Webhook
+	if err := json.Unmarshal(w.Body.Bytes(), &created); w.Code != http.StatusCreated || err != nil {
+		t.Fatalf("create: got %d: %s", w.Code, w.Body.String())
+	}
+
+	if got := list("key-a"); !reflect.DeepEqual(got, []string{"legacy", created.ID}) {
+		t.Errorf("key-a lists %v", got)
+	}
+	if got := list("key-b"); !reflect.DeepEqual(got, []string{"legacy"}) {
+		t.Errorf("key-b lists %v", got)
+	}
+	for _, c := range []struct{ method, path string }{
+		{"GET", "/webhooks/" + created.ID},
+		{"GET", "/webhooks/" + created.ID + "/deliveries"},
+		{"POST", "/webhooks/" + created.ID + "/test"},
+		{"DELETE", "/webhooks/" + created.ID},
+	} {
+		if w := call(c.method, c.path, "key-b", ""); w.Code != http.StatusNotFound {
+			t.Errorf("key-b %s %s: got %d", c.method, c.path, w.Code)
+		}
+	}
+	if w := call("DELETE", "/webhooks/"+created.ID, "key-a", ""); w.Code != http.StatusNoContent {
+		t.Errorf("key-a delete: got %d", w.Code)
+	}
+	if got := list("key-a"); !reflect.DeepEqual(got, []string{"legacy"}) {
+		t.Errorf("after delete key-a lists %v", got)
+	}
+}